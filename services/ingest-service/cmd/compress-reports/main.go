@@ -0,0 +1,112 @@
+// compress-reports backfills gzip-compressed copies of reports that were stored as plain XML
+// before the extract-attachment function began compressing them.
+//
+// Usage:
+//
+//	go run ./cmd/compress-reports -bucket <bucket> [-prefix reports/] [-delete] [-dry-run]
+//
+// Each reports/.../<name>.xml object is copied to <name>.xml.gz with a Content-Encoding of
+// gzip, keeping the content type, metadata and tags of the original so lifecycle rules and
+// per-tenant purges still apply to it.  Objects which already have a compressed copy are
+// skipped, so the command can be safely re-run.  The plain XML objects are only deleted when
+// -delete is given; keep them until any in-flight parse-report messages referencing them have
+// drained.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+)
+
+func main() {
+	bucket := flag.String("bucket", "", "name of the report storage bucket")
+	prefix := flag.String("prefix", "reports/", "key prefix of the reports to compress")
+	deleteOriginals := flag.Bool("delete", false, "delete the plain XML objects once compressed")
+	dryRun := flag.Bool("dry-run", false, "log the objects that would be compressed without changing anything")
+	flag.Parse()
+
+	if *bucket == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	awsClient, err := aws.NewAWSClient(ctx)
+	if err != nil {
+		log.Fatalf("error creating AWS client: %v", err)
+	}
+
+	var compressed, skipped int
+	err = awsClient.S3ListObjects(ctx, *bucket, *prefix, func(key string) error {
+		if !strings.HasSuffix(key, ".xml") {
+			return nil
+		}
+
+		done, err := compressReport(ctx, awsClient, *bucket, key, *deleteOriginals, *dryRun)
+		if err != nil {
+			return err
+		}
+		if done {
+			compressed++
+		} else {
+			skipped++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("error compressing reports: %v", err)
+	}
+
+	log.Printf("Compressed %d reports, skipped %d already compressed", compressed, skipped)
+}
+
+// compressReport stores a gzip-compressed copy of the report at key, returning false if a
+// compressed copy already exists.
+func compressReport(ctx context.Context, awsClient *aws.AWSClient, bucket, key string, deleteOriginal, dryRun bool) (bool, error) {
+	compressedKey := key + ".gz"
+
+	exists, err := awsClient.S3ObjectExists(ctx, bucket, compressedKey)
+	if err != nil {
+		return false, fmt.Errorf("error checking for %s: %w", compressedKey, err)
+	}
+
+	if !exists {
+		if dryRun {
+			log.Printf("Would compress %s to %s", key, compressedKey)
+			return true, nil
+		}
+
+		opts, err := awsClient.S3GetObjectOptions(ctx, bucket, key)
+		if err != nil {
+			return false, err
+		}
+		if opts.ContentType == "" {
+			opts.ContentType = "application/xml"
+		}
+
+		body, err := awsClient.S3GetObject(ctx, bucket, key)
+		if err != nil {
+			return false, err
+		}
+		if err := awsClient.S3PutGzipObject(ctx, bucket, compressedKey, opts, bytes.NewReader(body)); err != nil {
+			return false, err
+		}
+		log.Printf("Compressed %s to %s", key, compressedKey)
+	}
+
+	if deleteOriginal && !dryRun {
+		if err := awsClient.S3DeleteObject(ctx, bucket, key); err != nil {
+			return false, err
+		}
+		log.Printf("Deleted %s", key)
+	}
+
+	return !exists, nil
+}
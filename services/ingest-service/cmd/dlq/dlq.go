@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/quarantine"
+)
+
+// unknownKind is the kind of messages without a failure record
+const unknownKind = "Unknown"
+
+// awsAPI is the subset of the AWS client used by the tool, satisfied by *aws.AWSClient.
+type awsAPI interface {
+	aws.ObjectStore
+	aws.Queue
+	aws.DeadLetterQueue
+}
+
+// entry is a message in a dead-letter queue.
+type entry struct {
+	SQSMessageID string `json:"sqsMessageId"`
+	Stage        string `json:"stage"`
+	ReceiveCount int    `json:"receiveCount"`
+
+	// Message is the decoded message body
+	Message models.IngestMessage `json:"message"`
+
+	// Body is the raw message body, set when it could not be decoded into Message, including
+	// when it was published by a newer build with fields Message does not have
+	Body string `json:"body,omitempty"`
+
+	// Reason, Kind and FailedAt describe the last failure of the message
+	Reason   string `json:"reason,omitempty"`
+	Kind     string `json:"kind"`
+	FailedAt string `json:"failedAt,omitempty"`
+}
+
+// filter selects the messages to list or redrive.  Empty fields match every message.
+type filter struct {
+	Tenant     string
+	Kind       string
+	MessageIDs []string
+}
+
+func (f filter) matches(e entry) bool {
+	if f.Tenant != "" && e.Message.TenantID != f.Tenant {
+		return false
+	}
+	if f.Kind != "" && e.Kind != f.Kind {
+		return false
+	}
+	if len(f.MessageIDs) > 0 && !slices.Contains(f.MessageIDs, e.SQSMessageID) {
+		return false
+	}
+	return true
+}
+
+// dlqTool inspects and redrives the dead-letter queues of the pipeline stages.
+type dlqTool struct {
+	awsClient         awsAPI
+	bucket            string
+	visibilityTimeout time.Duration
+}
+
+// list returns the messages matching the filter in the dead-letter queues of the stage
+// queues, which are keyed by stage name.
+func (d *dlqTool) list(ctx context.Context, queues map[string]string, f filter) ([]entry, error) {
+	var entries []entry
+	for _, stage := range sortedStages(queues) {
+		deadLetterQueueURL, err := d.awsClient.SQSDeadLetterQueueURL(ctx, queues[stage])
+		if err != nil {
+			return nil, err
+		}
+
+		err = d.scan(ctx, stage, deadLetterQueueURL, f, func(e entry, message aws.SQSReceivedMessage) error {
+			entries = append(entries, e)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// redrive sends the messages matching the filter back to their stage queues and deletes them
+// from the dead-letter queues, returning the number of messages redriven.
+func (d *dlqTool) redrive(ctx context.Context, queues map[string]string, f filter, dryRun bool) (int, error) {
+	var redriven int
+	for _, stage := range sortedStages(queues) {
+		queueURL := queues[stage]
+		deadLetterQueueURL, err := d.awsClient.SQSDeadLetterQueueURL(ctx, queueURL)
+		if err != nil {
+			return redriven, err
+		}
+
+		err = d.scan(ctx, stage, deadLetterQueueURL, f, func(e entry, message aws.SQSReceivedMessage) error {
+			if dryRun {
+				log.Printf("Would redrive %s to %s", e.SQSMessageID, queueURL)
+				redriven++
+				return nil
+			}
+
+			if err := d.awsClient.SQSPublishMessageBatch(ctx, queueURL, []aws.SQSMessage{message.SQSMessage}); err != nil {
+				return fmt.Errorf("error redriving message %s: %w", e.SQSMessageID, err)
+			}
+			if err := d.awsClient.SQSDeleteMessage(ctx, deadLetterQueueURL, message.ReceiptHandle); err != nil {
+				return fmt.Errorf("error deleting redriven message %s: %w", e.SQSMessageID, err)
+			}
+			log.Printf("Redrove %s to %s", e.SQSMessageID, queueURL)
+			redriven++
+			return nil
+		})
+		if err != nil {
+			return redriven, err
+		}
+	}
+	return redriven, nil
+}
+
+// scan receives every message in a dead-letter queue, calling fn for those matching the
+// filter.  Received messages stay hidden for the visibility timeout, so each is seen once.
+func (d *dlqTool) scan(ctx context.Context, stage, deadLetterQueueURL string, f filter, fn func(entry, aws.SQSReceivedMessage) error) error {
+	seen := map[string]bool{}
+	for {
+		messages, err := d.awsClient.SQSReceiveMessages(ctx, deadLetterQueueURL, 10, d.visibilityTimeout)
+		if err != nil {
+			return err
+		}
+
+		// Messages reappear once their visibility timeout expires, so stop at the first batch
+		// of messages that have all been seen
+		var unseen int
+		for _, message := range messages {
+			if seen[message.MessageID] {
+				continue
+			}
+			seen[message.MessageID] = true
+			unseen++
+
+			e, err := d.entry(ctx, stage, message)
+			if err != nil {
+				return err
+			}
+			if !f.matches(e) {
+				continue
+			}
+			if err := fn(e, message); err != nil {
+				return err
+			}
+		}
+		if unseen == 0 {
+			return nil
+		}
+	}
+}
+
+// entry decodes a dead-letter queue message and finds the reason for its last failure.
+func (d *dlqTool) entry(ctx context.Context, stage string, message aws.SQSReceivedMessage) (entry, error) {
+	e := entry{
+		SQSMessageID: message.MessageID,
+		Stage:        stage,
+		ReceiveCount: message.ReceiveCount,
+		Kind:         unknownKind,
+	}
+	if err := aws.ParseSQSMessage(message.Body, &e.Message); err != nil {
+		e.Body = message.Body
+	} else if _, err := models.ParseIngestMessage(message.Body); err != nil {
+		e.Body = message.Body
+	}
+
+	failure, err := quarantine.GetFailure(ctx, d.awsClient, d.bucket, stage, message.MessageID)
+	if err != nil {
+		return entry{}, err
+	}
+	if failure != nil {
+		e.Reason, e.Kind, e.FailedAt = failure.Reason, failure.Kind, failure.FailedAt
+	}
+	return e, nil
+}
+
+// sortedStages returns the stage names of the queues in order, so output is stable.
+func sortedStages(queues map[string]string) []string {
+	stages := make([]string, 0, len(queues))
+	for stage := range queues {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	return stages
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws/awstest"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/errors"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/quarantine"
+)
+
+const testBucket = "ingest-storage"
+
+var testQueues = map[string]string{
+	"extract-attachment": "https://sqs.local/extract-attachment",
+	"parse-report":       "https://sqs.local/parse-report",
+}
+
+type testClient struct {
+	*awstest.ObjectStore
+	*awstest.Queue
+}
+
+// newTestTool returns a tool whose dead-letter queues hold a message for each of two
+// tenants in each stage.  The tenant-a messages failed with a throttling error, and the
+// tenant-b messages have no failure record.
+func newTestTool(t *testing.T) (*dlqTool, testClient) {
+	t.Helper()
+
+	client := testClient{awstest.NewObjectStore(), awstest.NewQueue()}
+	for stage, queueURL := range testQueues {
+		deadLetterQueueURL := queueURL + "-dlq"
+		client.SetDeadLetterQueue(queueURL, deadLetterQueueURL)
+
+		for _, tenantID := range []string{"tenant-a", "tenant-b"} {
+			body, err := json.Marshal(models.IngestMessage{MessageID: "email-" + tenantID, TenantID: tenantID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			messageID := stage + "-" + tenantID
+			client.Queue.Put(deadLetterQueueURL, messageID, aws.SQSMessage{
+				Body:       string(body),
+				Attributes: map[string]string{aws.MessageTypeAttribute: "ReportExtracted"},
+			}, 3)
+
+			if tenantID == "tenant-a" {
+				record := events.SQSMessage{MessageId: messageID, Attributes: map[string]string{"ApproximateReceiveCount": "3"}}
+				quarantine.RecordFailure(context.Background(), client, testBucket, stage, record, errors.New(errors.Throttled, "rate exceeded"))
+			}
+		}
+	}
+
+	return &dlqTool{awsClient: client, bucket: testBucket, visibilityTimeout: time.Minute}, client
+}
+
+func TestList(t *testing.T) {
+	tests := []struct {
+		name     string
+		queues   map[string]string
+		filter   filter
+		expected []string
+	}{
+		{
+			name:     "All messages",
+			queues:   testQueues,
+			expected: []string{"extract-attachment-tenant-a", "extract-attachment-tenant-b", "parse-report-tenant-a", "parse-report-tenant-b"},
+		},
+		{
+			name:     "Single stage",
+			queues:   map[string]string{"parse-report": testQueues["parse-report"]},
+			expected: []string{"parse-report-tenant-a", "parse-report-tenant-b"},
+		},
+		{
+			name:     "By tenant",
+			queues:   testQueues,
+			filter:   filter{Tenant: "tenant-b"},
+			expected: []string{"extract-attachment-tenant-b", "parse-report-tenant-b"},
+		},
+		{
+			name:     "By kind",
+			queues:   testQueues,
+			filter:   filter{Kind: "Throttled"},
+			expected: []string{"extract-attachment-tenant-a", "parse-report-tenant-a"},
+		},
+		{
+			name:     "Without failure record",
+			queues:   testQueues,
+			filter:   filter{Kind: unknownKind, MessageIDs: []string{"parse-report-tenant-b"}},
+			expected: []string{"parse-report-tenant-b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool, _ := newTestTool(t)
+
+			entries, err := tool.list(context.Background(), tt.queues, tt.filter)
+			if err != nil {
+				t.Fatalf("list() error = %v", err)
+			}
+
+			var ids []string
+			for _, e := range entries {
+				ids = append(ids, e.SQSMessageID)
+			}
+			if !slices.Equal(ids, tt.expected) {
+				t.Fatalf("list() = %v, expected %v", ids, tt.expected)
+			}
+		})
+	}
+}
+
+func TestListEntry(t *testing.T) {
+	tool, _ := newTestTool(t)
+
+	entries, err := tool.list(context.Background(), testQueues, filter{MessageIDs: []string{"parse-report-tenant-a"}})
+	if err != nil {
+		t.Fatalf("list() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+
+	e := entries[0]
+	if e.Stage != "parse-report" || e.Message.TenantID != "tenant-a" || e.Message.MessageID != "email-tenant-a" {
+		t.Errorf("entry = %+v, expected the tenant-a parse-report message", e)
+	}
+	if e.ReceiveCount != 4 {
+		t.Errorf("ReceiveCount = %d, expected 4 including the receive by list", e.ReceiveCount)
+	}
+	if e.Kind != "Throttled" || e.Reason != "rate exceeded" {
+		t.Errorf("failure = %s: %s, expected Throttled: rate exceeded", e.Kind, e.Reason)
+	}
+}
+
+func TestRedrive(t *testing.T) {
+	tool, client := newTestTool(t)
+
+	redriven, err := tool.redrive(context.Background(), testQueues, filter{Tenant: "tenant-a"}, false)
+	if err != nil {
+		t.Fatalf("redrive() error = %v", err)
+	}
+	if redriven != 2 {
+		t.Errorf("redrive() = %d, expected 2", redriven)
+	}
+
+	for stage, queueURL := range testQueues {
+		messages := client.Received(queueURL)
+		if len(messages) != 1 {
+			t.Fatalf("expected 1 message redriven to %s, got %d", stage, len(messages))
+		}
+		if messages[0].Attributes[aws.MessageTypeAttribute] != "ReportExtracted" {
+			t.Errorf("redriven message attributes = %v, expected the original attributes", messages[0].Attributes)
+		}
+
+		// Only the tenant-b message is left in the dead-letter queue
+		if remaining := client.Messages(queueURL + "-dlq"); len(remaining) != 1 {
+			t.Errorf("expected 1 message left in the %s dead-letter queue, got %d", stage, len(remaining))
+		}
+	}
+}
+
+func TestRedriveDryRun(t *testing.T) {
+	tool, client := newTestTool(t)
+
+	redriven, err := tool.redrive(context.Background(), testQueues, filter{}, true)
+	if err != nil {
+		t.Fatalf("redrive() error = %v", err)
+	}
+	if redriven != 4 {
+		t.Errorf("redrive() = %d, expected 4", redriven)
+	}
+
+	for stage, queueURL := range testQueues {
+		if messages := client.Received(queueURL); len(messages) != 0 {
+			t.Errorf("expected no messages redriven to %s, got %d", stage, len(messages))
+		}
+		if remaining := client.Messages(queueURL + "-dlq"); len(remaining) != 2 {
+			t.Errorf("expected 2 messages left in the %s dead-letter queue, got %d", stage, len(remaining))
+		}
+	}
+}
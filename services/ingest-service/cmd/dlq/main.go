@@ -0,0 +1,120 @@
+// dlq lists the messages in the dead-letter queues of the ingest pipeline, and redrives
+// selected messages to the queue of the stage they failed in.
+//
+// Usage:
+//
+//	go run ./cmd/dlq -bucket <bucket> -extract-attachment-queue <url> -parse-report-queue <url> \
+//		[-stage <stage>] [-tenant <tenant>] [-kind <kind>] [-json] list
+//	go run ./cmd/dlq ... [-message-id <id>,...] [-dry-run] redrive
+//
+// The dead-letter queue of each stage queue is found from its redrive policy.  Each message is
+// shown with its decoded models.IngestMessage, its receive count, and the reason and kind of
+// its last failure from the failure records the functions store in the bucket.  Messages
+// without a failure record have the kind Unknown.
+//
+// Listing receives every message, hiding it from other consumers for the visibility timeout,
+// so the receive counts increase each time the dead-letter queue is listed.  Redriving sends
+// each selected message to its stage queue with its original attributes before deleting it
+// from the dead-letter queue.  Set AWS_ENDPOINT_URL to run against local emulators.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+)
+
+func main() {
+	bucket := flag.String("bucket", "", "name of the ingest storage bucket holding the failure records")
+	extractAttachmentQueue := flag.String("extract-attachment-queue", "", "URL of the extract-attachment queue")
+	parseReportQueue := flag.String("parse-report-queue", "", "URL of the parse-report queue")
+	stage := flag.String("stage", "", "only include messages which failed in this stage")
+	tenant := flag.String("tenant", "", "only include messages for this tenant")
+	kind := flag.String("kind", "", "only include messages whose last failure was of this kind, such as Transient or Unknown")
+	messageIDs := flag.String("message-id", "", "comma-separated SQS message IDs to include")
+	visibilityTimeout := flag.Duration("visibility-timeout", 30*time.Second, "how long received messages are hidden from other consumers")
+	jsonOutput := flag.Bool("json", false, "print each message as a JSON object")
+	dryRun := flag.Bool("dry-run", false, "log the messages that would be redriven without changing anything")
+	flag.Parse()
+
+	if *bucket == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	queues := map[string]string{}
+	if *extractAttachmentQueue != "" {
+		queues["extract-attachment"] = *extractAttachmentQueue
+	}
+	if *parseReportQueue != "" {
+		queues["parse-report"] = *parseReportQueue
+	}
+	if *stage != "" {
+		if _, ok := queues[*stage]; !ok {
+			log.Fatalf("the queue of stage %s was not given", *stage)
+		}
+		queues = map[string]string{*stage: queues[*stage]}
+	}
+	if len(queues) == 0 {
+		log.Fatal("at least one stage queue must be given")
+	}
+
+	f := filter{Tenant: *tenant, Kind: *kind}
+	if *messageIDs != "" {
+		f.MessageIDs = strings.Split(*messageIDs, ",")
+	}
+
+	ctx := context.Background()
+	awsClient, err := aws.NewAWSClient(ctx)
+	if err != nil {
+		log.Fatalf("error creating AWS client: %v", err)
+	}
+	tool := &dlqTool{awsClient: awsClient, bucket: *bucket, visibilityTimeout: *visibilityTimeout}
+
+	switch command := flag.Arg(0); command {
+	case "list":
+		entries, err := tool.list(ctx, queues, f)
+		if err != nil {
+			log.Fatalf("error listing dead-letter queues: %v", err)
+		}
+		if err := printEntries(entries, *jsonOutput); err != nil {
+			log.Fatalf("error printing messages: %v", err)
+		}
+	case "redrive":
+		redriven, err := tool.redrive(ctx, queues, f, *dryRun)
+		if err != nil {
+			log.Fatalf("error redriving messages: %v", err)
+		}
+		log.Printf("Redrove %d messages", redriven)
+	default:
+		log.Fatalf("unknown command %q, expected list or redrive", command)
+	}
+}
+
+// printEntries prints the entries as a table, or as one JSON object per line.
+func printEntries(entries []entry, jsonOutput bool) error {
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := encoder.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SQS MESSAGE ID\tSTAGE\tTENANT\tEMAIL MESSAGE ID\tRECEIVES\tKIND\tREASON")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", e.SQSMessageID, e.Stage, e.Message.TenantID, e.Message.MessageID, e.ReceiveCount, e.Kind, e.Reason)
+	}
+	return w.Flush()
+}
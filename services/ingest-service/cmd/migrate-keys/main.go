@@ -0,0 +1,59 @@
+// migrate-keys copies the reports and records stored in the report and record tables into
+// the DMARC table, rewriting their keys into the tenant-scoped single-table design.
+//
+// Usage:
+//
+//	go run ./cmd/migrate-keys -report-table <table> -record-table <table> -table <table> [-dry-run]
+//
+// Reports are keyed on <tenant>#<reportId> in the report table, and records on
+// <tenant>#<reportId>#<index> in the record table.  Every report is read first, so each
+// record can be placed in the partition of its report.  Records whose report no longer exists
+// are skipped and logged.  Items are written with the same keys each time, so the command can
+// be safely re-run, such as to copy reports stored while parse-report was being redeployed.
+// The report and record tables are left unchanged.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+)
+
+func main() {
+	reportTable := flag.String("report-table", "", "name of the report table to copy reports from")
+	recordTable := flag.String("record-table", "", "name of the record table to copy records from")
+	table := flag.String("table", "", "name of the DMARC table to copy reports and records to")
+	dryRun := flag.Bool("dry-run", false, "count the items that would be copied without writing anything")
+	flag.Parse()
+
+	if *reportTable == "" || *recordTable == "" || *table == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	awsClient, err := aws.NewAWSClient(ctx)
+	if err != nil {
+		log.Fatalf("error creating AWS client: %v", err)
+	}
+
+	m := &migration{
+		awsClient:   awsClient,
+		reportTable: *reportTable,
+		recordTable: *recordTable,
+		table:       *table,
+		dryRun:      *dryRun,
+	}
+	if err := m.run(ctx); err != nil {
+		log.Fatalf("error migrating keys: %v", err)
+	}
+
+	verb := "Copied"
+	if *dryRun {
+		verb = "Would copy"
+	}
+	log.Printf("%s %d reports and %d records, skipped %d records without a report", verb, m.reports, m.records, m.skipped)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/dmarc"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+// writeBatchSize is how many items are buffered before writing them to the DMARC table
+const writeBatchSize = 100
+
+// awsAPI is the subset of the AWS client used by the migration, satisfied by *aws.AWSClient.
+type awsAPI interface {
+	aws.Table
+	aws.TableScanner
+}
+
+// legacyReportItem is a report item in the report table.  Reports stored before the tenant
+// was recorded on the item only have it in the ID.
+type legacyReportItem struct {
+	models.DmarcReportMetadataItem
+	ID string `dynamodbav:"id"`
+}
+
+// legacyRecordItem is a record item in the record table.  Records stored before the report
+// and index were recorded on the item only have them in the ID.
+type legacyRecordItem struct {
+	models.DmarcRecordItem
+	ID           string `dynamodbav:"id"`
+	ReportItemID string `dynamodbav:"reportItemId"`
+}
+
+// migration copies reports and records into the DMARC table, counting the items copied.
+type migration struct {
+	awsClient   awsAPI
+	reportTable string
+	recordTable string
+	table       string
+	dryRun      bool
+
+	reports int
+	records int
+	skipped int
+
+	pending []map[string]dynamodbTypes.AttributeValue
+}
+
+func (m *migration) run(ctx context.Context) error {
+	// Reports by their ID in the report table, which records refer to them by
+	reports := map[string]models.DmarcReportMetadataItem{}
+	err := m.awsClient.DynamoDBScan(ctx, m.reportTable, func(item map[string]dynamodbTypes.AttributeValue) error {
+		report, legacyID, err := migrateReport(item)
+		if err != nil {
+			return err
+		}
+		reports[legacyID] = report
+		m.reports++
+		return m.write(ctx, report)
+	})
+	if err != nil {
+		return fmt.Errorf("error copying reports: %w", err)
+	}
+
+	err = m.awsClient.DynamoDBScan(ctx, m.recordTable, func(item map[string]dynamodbTypes.AttributeValue) error {
+		record, reportItemID, err := migrateRecord(item)
+		if err != nil {
+			return err
+		}
+
+		report, ok := reports[reportItemID]
+		if !ok {
+			log.Printf("Skipping record %d of missing report %s", record.RecordIndex, reportItemID)
+			m.skipped++
+			return nil
+		}
+		dmarc.SetRecordKeys(report, &record)
+		m.records++
+		return m.write(ctx, record)
+	})
+	if err != nil {
+		return fmt.Errorf("error copying records: %w", err)
+	}
+
+	return m.flush(ctx)
+}
+
+// migrateReport returns a report item with its new keys, and its ID in the report table.
+func migrateReport(item map[string]dynamodbTypes.AttributeValue) (models.DmarcReportMetadataItem, string, error) {
+	var legacy legacyReportItem
+	if err := attributevalue.UnmarshalMap(item, &legacy); err != nil {
+		return models.DmarcReportMetadataItem{}, "", fmt.Errorf("error unmarshalling report item: %w", err)
+	}
+
+	report := legacy.DmarcReportMetadataItem
+	if report.TenantID == "" {
+		tenantID, _, ok := strings.Cut(legacy.ID, "#")
+		if !ok {
+			return models.DmarcReportMetadataItem{}, "", fmt.Errorf("report item %q has no tenant", legacy.ID)
+		}
+		report.TenantID = tenantID
+	}
+	dmarc.SetReportKeys(&report)
+	return report, legacy.ID, nil
+}
+
+// migrateRecord returns a record item with its index set, and the ID of its report in the
+// report table.  The keys of the record are set from its report.
+func migrateRecord(item map[string]dynamodbTypes.AttributeValue) (models.DmarcRecordItem, string, error) {
+	var legacy legacyRecordItem
+	if err := attributevalue.UnmarshalMap(item, &legacy); err != nil {
+		return models.DmarcRecordItem{}, "", fmt.Errorf("error unmarshalling record item: %w", err)
+	}
+
+	record := legacy.DmarcRecordItem
+	reportItemID := legacy.ReportItemID
+	if reportItemID == "" {
+		id, indexValue, ok := cutLast(legacy.ID, "#")
+		index, err := strconv.Atoi(indexValue)
+		if !ok || err != nil {
+			return models.DmarcRecordItem{}, "", fmt.Errorf("record item %q has no report", legacy.ID)
+		}
+		reportItemID, record.RecordIndex = id, index
+	}
+	return record, reportItemID, nil
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// write buffers an item to be written to the DMARC table, writing the buffer when it is full.
+func (m *migration) write(ctx context.Context, item any) error {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return fmt.Errorf("error marshalling item: %w", err)
+	}
+
+	m.pending = append(m.pending, av)
+	if len(m.pending) < writeBatchSize {
+		return nil
+	}
+	return m.flush(ctx)
+}
+
+// flush writes the buffered items to the DMARC table.
+func (m *migration) flush(ctx context.Context) error {
+	pending := m.pending
+	m.pending = nil
+	if m.dryRun || len(pending) == 0 {
+		return nil
+	}
+	return m.awsClient.DynamoDBPutBatchItems(ctx, m.table, pending)
+}
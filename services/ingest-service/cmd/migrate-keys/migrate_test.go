@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws/awstest"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+func putItems(t *testing.T, table *awstest.Table, tableName string, items ...map[string]any) {
+	t.Helper()
+
+	for _, item := range items {
+		av, err := attributevalue.MarshalMap(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := table.DynamoDBPutItem(context.Background(), tableName, &av); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigration(t *testing.T) {
+	table := awstest.NewTable()
+	table.SetKey("dmarc", "pk", "sk")
+
+	putItems(t, table, "reports",
+		map[string]any{
+			"id": "tenant-a#1", "reportId": "1", "tenantId": "tenant-a", "tenantDomain": "tenant-a#example.com",
+			"orgName": "google.com", "domain": "example.com", "dateRangeBegin": 1722470400,
+		},
+		// Stored before the tenant was recorded on the item
+		map[string]any{
+			"id": "tenant-b#2", "reportId": "2", "orgName": "yahoo.com", "domain": "example.org", "dateRangeBegin": 1722556800,
+		},
+	)
+	putItems(t, table, "records",
+		map[string]any{"id": "tenant-a#1#0", "reportItemId": "tenant-a#1", "recordIndex": 0, "sourceIp": "192.0.2.1", "count": 3},
+		map[string]any{"id": "tenant-a#1#1", "reportItemId": "tenant-a#1", "recordIndex": 1, "sourceIp": "192.0.2.2", "count": 1},
+		// Stored before the report and index were recorded on the item
+		map[string]any{"id": "tenant-b#2#0", "sourceIp": "192.0.2.1", "count": 5},
+		map[string]any{"id": "tenant-c#3#0", "sourceIp": "192.0.2.3"},
+	)
+
+	m := &migration{awsClient: table, reportTable: "reports", recordTable: "records", table: "dmarc"}
+	if err := m.run(context.Background()); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if m.reports != 2 || m.records != 3 || m.skipped != 1 {
+		t.Errorf("copied %d reports and %d records, skipped %d, expected 2, 3 and 1", m.reports, m.records, m.skipped)
+	}
+
+	expected := map[string]string{
+		"tenant-a#example.com REPORT#1722470400#1":        "",
+		"tenant-a#example.com RECORD#1722470400#1#000000": "tenant-a#192.0.2.1",
+		"tenant-a#example.com RECORD#1722470400#1#000001": "tenant-a#192.0.2.2",
+		"tenant-b#example.org REPORT#1722556800#2":        "",
+		"tenant-b#example.org RECORD#1722556800#2#000000": "tenant-b#192.0.2.1",
+	}
+	items := table.Items("dmarc")
+	if len(items) != len(expected) {
+		t.Fatalf("expected %d items, got %d", len(expected), len(items))
+	}
+	for _, item := range items {
+		var record models.DmarcRecordItem
+		if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+			t.Fatal(err)
+		}
+		sourceIPKey, ok := expected[record.PK+" "+record.SK]
+		if !ok {
+			t.Errorf("unexpected item %s %s", record.PK, record.SK)
+			continue
+		}
+		if record.SourceIPKey != sourceIPKey {
+			t.Errorf("item %s %s source IP key = %q, expected %q", record.PK, record.SK, record.SourceIPKey, sourceIPKey)
+		}
+		if _, ok := item["id"]; ok {
+			t.Errorf("item %s %s kept its legacy id", record.PK, record.SK)
+		}
+	}
+
+	// Running the migration again rewrites the same items
+	if err := (&migration{awsClient: table, reportTable: "reports", recordTable: "records", table: "dmarc"}).run(context.Background()); err != nil {
+		t.Fatalf("second run() error = %v", err)
+	}
+	if items := table.Items("dmarc"); len(items) != len(expected) {
+		t.Errorf("expected %d items after running again, got %d", len(expected), len(items))
+	}
+}
+
+func TestMigrationDryRun(t *testing.T) {
+	table := awstest.NewTable()
+	putItems(t, table, "reports", map[string]any{"id": "tenant-a#1", "reportId": "1", "tenantId": "tenant-a", "domain": "example.com"})
+	putItems(t, table, "records", map[string]any{"id": "tenant-a#1#0", "reportItemId": "tenant-a#1", "recordIndex": 0})
+
+	m := &migration{awsClient: table, reportTable: "reports", recordTable: "records", table: "dmarc", dryRun: true}
+	if err := m.run(context.Background()); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if m.reports != 1 || m.records != 1 {
+		t.Errorf("counted %d reports and %d records, expected 1 and 1", m.reports, m.records)
+	}
+	if items := table.Items("dmarc"); len(items) != 0 {
+		t.Errorf("expected no items written, got %d", len(items))
+	}
+}
@@ -2,5 +2,7 @@ package main
 
 type Config struct {
 	ReportStorageBucketName string `env:"INGEST_STORAGE_BUCKET_NAME"`
-	NextStageQueueURL       string `env:"NEXT_STAGE_QUEUE_URL"`
+	NextStageQueueURL       string `env:"NEXT_STAGE_QUEUE_URL" validate:"url"`
+	IdempotencyTableName    string `env:"IDEMPOTENCY_TABLE_NAME"`
+	EventBusName            string `env:"EVENT_BUS_NAME"`
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
@@ -14,6 +13,9 @@ import (
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
 )
 
+// stageName identifies this function in idempotency records
+const stageName = "enqueue-email"
+
 func handler(ctx context.Context, sesEvent events.SimpleEmailEvent) error {
 	config, err := config.NewConfig[Config]()
 	if err != nil {
@@ -25,11 +27,41 @@ func handler(ctx context.Context, sesEvent events.SimpleEmailEvent) error {
 		return errors.NewLambdaError(500, fmt.Sprintf("error creating AWS client: %v", err))
 	}
 
+	return handleEvent(ctx, awsClient, config, sesEvent)
+}
+
+// awsAPI is the subset of the AWS client used by this function, satisfied by *aws.AWSClient.
+type awsAPI interface {
+	aws.Queue
+	aws.Table
+	aws.EventPublisher
+}
+
+// handleEvent processes every email in the SES event.  It is separate from handler so it
+// can be tested without AWS access.
+func handleEvent(ctx context.Context, awsClient awsAPI, config *Config, sesEvent events.SimpleEmailEvent) error {
+	idempotency := aws.NewIdempotency(awsClient, config.IdempotencyTableName)
 	for _, record := range sesEvent.Records {
-		if err := processEmail(ctx, awsClient, config, record.SES.Mail); err != nil {
+		// SES retries a failed invocation, so emails already enqueued are skipped
+		key := aws.IdempotencyKey{MessageID: record.SES.Mail.MessageID, Stage: stageName}
+		enqueued := false
+		err := idempotency.Do(ctx, key, func(ctx context.Context) error {
+			if err := processEmail(ctx, awsClient, config, record.SES.Mail); err != nil {
+				return err
+			}
+			enqueued = true
+			return nil
+		})
+		if err != nil {
 			log.Printf("Error processing email with MessageID %s: %v", record.SES.Mail.MessageID, err)
 			// Optionally: continue processing other emails, or return the error
-			return errors.NewLambdaError(500, fmt.Sprintf("error processing email with MessageID %s: %v", record.SES.Mail.MessageID, err))
+			return errors.Classify(err, fmt.Sprintf("error processing email with MessageID %s", record.SES.Mail.MessageID))
+		}
+
+		// The event is published outside the unit of work, so failing to publish it cannot
+		// cause the email to be enqueued a second time
+		if enqueued {
+			publishEmailReceived(ctx, awsClient, config, record.SES.Mail)
 		}
 	}
 
@@ -37,21 +69,43 @@ func handler(ctx context.Context, sesEvent events.SimpleEmailEvent) error {
 }
 
 // processEmail processes an individual SES email message and adds it to the SQS queue for further processing downstream.
-func processEmail(ctx context.Context, awsClient *aws.AWSClient, config *Config, mail events.SimpleEmailMessage) error {
-	tenantID := strings.Split(mail.Destination[0], "@")[0]
-	messageJSON, err := json.Marshal(models.IngestMessage{
-		TenantID:         tenantID,
+// SES does not report how many times it has invoked the function, so the message history
+// records the first attempt.
+func processEmail(ctx context.Context, awsClient awsAPI, config *Config, mail events.SimpleEmailMessage) error {
+	stage := aws.StartStage(stageName, 1)
+	messageJSON, err := models.MarshalIngestMessage(models.IngestMessage{
+		TenantID:         tenantID(mail),
 		RawS3ObjectPath:  fmt.Sprintf("raw/%s", mail.MessageID),
 		MessageTimestamp: fmt.Sprintf("%d", mail.Timestamp.Unix()),
 		MessageID:        mail.MessageID,
+		History:          []models.StageRecord{stage.End()},
 	})
 	if err != nil {
-		return errors.NewLambdaError(500, fmt.Sprintf("error marshalling message: %v", err))
+		return errors.Wrap(errors.Permanent, err, "error marshalling message")
 	}
 
-	if err := awsClient.SQSPublishMessage(ctx, config.NextStageQueueURL, string(messageJSON)); err != nil {
-		return errors.NewLambdaError(500, fmt.Sprintf("error publishing message to SQS: %v", err))
+	if err := awsClient.SQSPublishMessage(ctx, config.NextStageQueueURL, messageJSON); err != nil {
+		return errors.Classify(err, "error publishing message to SQS")
 	}
 
 	return nil
 }
+
+// publishEmailReceived publishes an EmailReceived event for an email that has been enqueued.
+// Failing to publish is only logged, since the email has already been enqueued.
+func publishEmailReceived(ctx context.Context, awsClient aws.EventPublisher, config *Config, mail events.SimpleEmailMessage) {
+	err := awsClient.EventBridgePublishEvents(ctx, config.EventBusName, models.EmailReceivedEvent{
+		TenantID:   tenantID(mail),
+		MessageID:  mail.MessageID,
+		ReceivedAt: mail.Timestamp,
+	})
+	if err != nil {
+		log.Printf("Error publishing EmailReceived event for %s: %v", mail.MessageID, err)
+	}
+}
+
+// tenantID returns the tenant an email was sent to, which is the local part of its first
+// recipient.
+func tenantID(mail events.SimpleEmailMessage) string {
+	return strings.Split(mail.Destination[0], "@")[0]
+}
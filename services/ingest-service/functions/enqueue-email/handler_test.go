@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws/awstest"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+const testQueueURL = "https://sqs.eu-west-2.amazonaws.com/000000000000/extract-attachment"
+
+var testConfig = &Config{NextStageQueueURL: testQueueURL, IdempotencyTableName: "idempotency", EventBusName: "ingest-events"}
+
+func sesRecord(messageID string, destination string) events.SimpleEmailRecord {
+	return events.SimpleEmailRecord{
+		SES: events.SimpleEmailService{
+			Mail: events.SimpleEmailMessage{
+				MessageID:   messageID,
+				Timestamp:   time.Unix(1722470400, 0),
+				Destination: []string{destination},
+			},
+		},
+	}
+}
+
+func TestHandleEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		event     events.SimpleEmailEvent
+		queueErr  error
+		busErr    error
+		expected  []models.IngestMessage
+		expectErr bool
+	}{
+		{
+			name:  "Single email",
+			event: events.SimpleEmailEvent{Records: []events.SimpleEmailRecord{sesRecord("abc123", "tenant-a@ingest.example.com")}},
+			expected: []models.IngestMessage{
+				{MessageID: "abc123", MessageTimestamp: "1722470400", TenantID: "tenant-a", RawS3ObjectPath: "raw/abc123"},
+			},
+		},
+		{
+			name: "Multiple emails",
+			event: events.SimpleEmailEvent{Records: []events.SimpleEmailRecord{
+				sesRecord("abc123", "tenant-a@ingest.example.com"),
+				sesRecord("def456", "tenant-b@ingest.example.com"),
+			}},
+			expected: []models.IngestMessage{
+				{MessageID: "abc123", MessageTimestamp: "1722470400", TenantID: "tenant-a", RawS3ObjectPath: "raw/abc123"},
+				{MessageID: "def456", MessageTimestamp: "1722470400", TenantID: "tenant-b", RawS3ObjectPath: "raw/def456"},
+			},
+		},
+		{
+			name: "Email redelivered in the same event",
+			event: events.SimpleEmailEvent{Records: []events.SimpleEmailRecord{
+				sesRecord("abc123", "tenant-a@ingest.example.com"),
+				sesRecord("abc123", "tenant-a@ingest.example.com"),
+			}},
+			expected: []models.IngestMessage{
+				{MessageID: "abc123", MessageTimestamp: "1722470400", TenantID: "tenant-a", RawS3ObjectPath: "raw/abc123"},
+			},
+		},
+		{
+			// The email is enqueued once even though the event cannot be published
+			name: "Event bus unavailable",
+			event: events.SimpleEmailEvent{Records: []events.SimpleEmailRecord{
+				sesRecord("abc123", "tenant-a@ingest.example.com"),
+				sesRecord("abc123", "tenant-a@ingest.example.com"),
+			}},
+			busErr: stderrors.New("service unavailable"),
+			expected: []models.IngestMessage{
+				{MessageID: "abc123", MessageTimestamp: "1722470400", TenantID: "tenant-a", RawS3ObjectPath: "raw/abc123"},
+			},
+		},
+		{
+			name:      "Queue unavailable",
+			event:     events.SimpleEmailEvent{Records: []events.SimpleEmailRecord{sesRecord("abc123", "tenant-a@ingest.example.com")}},
+			queueErr:  stderrors.New("service unavailable"),
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := awstest.NewQueue()
+			queue.Err = tt.queueErr
+			bus := awstest.NewEventBus()
+			bus.Err = tt.busErr
+			client := struct {
+				*awstest.Queue
+				*awstest.Table
+				*awstest.EventBus
+			}{queue, awstest.NewTable(), bus}
+
+			err := handleEvent(context.Background(), client, testConfig, tt.event)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("handleEvent() error = %v, expectErr %v", err, tt.expectErr)
+			}
+
+			messages := queue.Messages(testQueueURL)
+			if len(messages) != len(tt.expected) {
+				t.Fatalf("expected %d messages, got %d", len(tt.expected), len(messages))
+			}
+			expectedEvents := len(tt.expected)
+			if tt.busErr != nil {
+				expectedEvents = 0
+			}
+			if events := bus.Events(testConfig.EventBusName); len(events) != expectedEvents {
+				t.Errorf("expected %d EmailReceived events, got %d", expectedEvents, len(events))
+			}
+			for i, body := range messages {
+				message, err := models.ParseIngestMessage(body)
+				if err != nil {
+					t.Fatalf("error unmarshalling message: %v", err)
+				}
+				if message.MessageID != tt.expected[i].MessageID || message.TenantID != tt.expected[i].TenantID ||
+					message.RawS3ObjectPath != tt.expected[i].RawS3ObjectPath || message.MessageTimestamp != tt.expected[i].MessageTimestamp {
+					t.Errorf("message %d = %+v, expected %+v", i, message, tt.expected[i])
+				}
+				if message.Version != models.IngestMessageVersion {
+					t.Errorf("message %d has version %d, expected %d", i, message.Version, models.IngestMessageVersion)
+				}
+				if len(message.History) != 1 || message.History[0].Stage != stageName || message.History[0].Attempt != 1 {
+					t.Errorf("message %d has history %+v, expected the first attempt of %s", i, message.History, stageName)
+				}
+			}
+		})
+	}
+}
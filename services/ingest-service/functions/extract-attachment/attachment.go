@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
@@ -12,60 +15,153 @@ import (
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/email/message"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/errors"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/quarantine"
 )
 
-// processEmailAttachment processes an individual SES email attachment by decompressing
-// it, saving it to the S3 bucket, and publishing a message to the next stage SQS queue.
-func processEmailAttachment(ctx context.Context, attachment *message.Attachment, awsClient *aws.AWSClient, config *Config, sqsMessage *models.IngestMessage) error {
-	data, err := getAttachmentData(attachment)
+// stageName identifies this function in quarantine and idempotency records
+const stageName = "extract-attachment"
+
+// reportMessageType is the MessageType attribute of the messages published for each report
+const reportMessageType = "ReportExtracted"
+
+// processEmailAttachment processes an individual SES email attachment by extracting every
+// report it contains and saving each to the S3 bucket.  It returns a message per report for
+// the next stage SQS queue, with the stage appended to its history.  The index distinguishes
+// attachments of the same email.
+func processEmailAttachment(ctx context.Context, index int, attachment *message.Attachment, awsClient awsAPI, config *Config, sqsMessage *models.IngestMessage, stage models.StageRecord) ([]aws.SQSMessage, error) {
+	reports, format, err := getAttachmentReports(attachment, config.DecompressionLimits)
 	if err != nil {
-		return errors.NewLambdaError(500, fmt.Sprintf("error getting attachment data: %v", err))
+		// Only this attachment is skipped, so the email's other attachments are still extracted
+		if !errors.IsRetryable(err) {
+			return nil, quarantineAttachment(ctx, awsClient, config, sqsMessage, attachment, err)
+		}
+		return nil, err
+	}
+
+	attributes := map[string]string{aws.MessageTypeAttribute: reportMessageType}
+	if traceContext := aws.TraceContext(ctx); traceContext != "" {
+		attributes[aws.TraceContextAttribute] = traceContext
 	}
 
-	attachmentS3ObjectPath, err := saveReport(ctx, awsClient, config, sqsMessage.MessageID, sqsMessage.TenantID, data)
+	messages := make([]aws.SQSMessage, 0, len(reports))
+	for i, report := range reports {
+		s3Key, err := reportKey(sqsMessage, index, i)
+		if err != nil {
+			return nil, err
+		}
+		if err := saveReport(ctx, awsClient, config, sqsMessage, s3Key, format, report.Data); err != nil {
+			return nil, err
+		}
+
+		messageJSON, err := models.MarshalIngestMessage(models.IngestMessage{
+			TenantID:               sqsMessage.TenantID,
+			RawS3ObjectPath:        sqsMessage.RawS3ObjectPath,
+			AttachmentS3ObjectPath: s3Key,
+			AttachmentFormat:       string(format),
+			ReportFilename:         report.Name,
+			MessageTimestamp:       sqsMessage.MessageTimestamp,
+			MessageID:              sqsMessage.MessageID,
+			DKIMResults:            sqsMessage.DKIMResults,
+			ARCResult:              sqsMessage.ARCResult,
+			History:                append(slices.Clip(sqsMessage.History), stage.End()),
+		})
+		if err != nil {
+			return nil, errors.Wrap(errors.Permanent, err, "error marshalling message")
+		}
+
+		messages = append(messages, aws.SQSMessage{Body: messageJSON, Attributes: attributes})
+	}
+
+	return messages, nil
+}
+
+// getAttachmentReports reads the attachment data, detects its format, and extracts every
+// report it contains within the limits, returning the reports along with the detected format.
+func getAttachmentReports(attachment *message.Attachment, limits compress.Limits) ([]compress.Report, compress.Format, error) {
+	data, err := io.ReadAll(attachment.Data)
 	if err != nil {
-		return errors.NewLambdaError(500, fmt.Sprintf("error saving report to S3: %v", err))
+		return nil, "", errors.Wrap(errors.InvalidInput, err, "error reading attachment data")
 	}
 
-	messageJSON, err := json.Marshal(models.IngestMessage{
-		TenantID:               sqsMessage.TenantID,
-		RawS3ObjectPath:        sqsMessage.RawS3ObjectPath,
-		AttachmentS3ObjectPath: attachmentS3ObjectPath,
-		MessageTimestamp:       sqsMessage.MessageTimestamp,
-		MessageID:              sqsMessage.MessageID,
-	})
+	format, err := compress.DetectFormat(data, attachment.Filename, attachment.ContentType)
 	if err != nil {
-		return errors.NewLambdaError(500, fmt.Sprintf("error marshalling message: %v", err))
+		return nil, "", errors.Wrap(errors.InvalidInput, err, "error detecting attachment format")
 	}
 
-	if err := awsClient.SQSPublishMessage(ctx, config.NextStageQueueURL, string(messageJSON)); err != nil {
-		return errors.NewLambdaError(500, fmt.Sprintf("error publishing message to SQS: %v", err))
+	reports, err := compress.Extract(data, attachment.Filename, format, limits)
+	if err != nil {
+		// Exceeding the limits suggests a decompression bomb rather than a corrupt archive
+		if compress.IsLimitError(err) {
+			return nil, "", errors.Wrap(errors.Security, err, "error extracting reports from attachment")
+		}
+		return nil, "", errors.Wrap(errors.InvalidInput, err, "error extracting reports from attachment")
 	}
-	return nil
+
+	return reports, format, nil
 }
 
-// getAttachmentData reads the attachment data, decompresses it, and returns the uncompressed data.
-func getAttachmentData(attachment *message.Attachment) ([]byte, error) {
-	data, err := io.ReadAll(attachment.Data)
+// quarantineAttachment records an attachment that failed permanently, such as by exceeding
+// the decompression limits or containing no reports, and publishes a ReportRejected event.
+// The attachment is skipped rather than retried, since it will never succeed.  Failing to
+// publish the event is only logged, so it does not cause the attachment to be processed again.
+func quarantineAttachment(ctx context.Context, awsClient awsAPI, config *Config, sqsMessage *models.IngestMessage, attachment *message.Attachment, cause error) error {
+	log.Printf("Quarantining attachment %s of message %s: %v", attachment.Filename, sqsMessage.MessageID, cause)
+
+	err := quarantine.Put(ctx, awsClient, config.ReportStorageBucketName, models.QuarantineRecord{
+		Message:    *sqsMessage,
+		Stage:      stageName,
+		Reason:     cause.Error(),
+		Kind:       errors.KindOf(cause).String(),
+		Attachment: attachment.Filename,
+	})
 	if err != nil {
-		return nil, errors.NewLambdaError(500, fmt.Sprintf("error reading attachment data: %v", err))
+		return errors.Classify(err, "error quarantining attachment")
 	}
 
-	uncompressed, err := compress.Decompress(data, attachment.ContentType)
+	err = awsClient.EventBridgePublishEvents(ctx, config.EventBusName, models.ReportRejectedEvent{
+		TenantID:   sqsMessage.TenantID,
+		MessageID:  sqsMessage.MessageID,
+		Attachment: attachment.Filename,
+		Reason:     cause.Error(),
+	})
 	if err != nil {
-		return nil, errors.NewLambdaError(500, fmt.Sprintf("error decompressing attachment data: %v", err))
+		log.Printf("Error publishing ReportRejected event for attachment %s of message %s: %v", attachment.Filename, sqsMessage.MessageID, err)
 	}
 
-	return uncompressed, nil
+	return nil
 }
 
-// saveReport saves the report data to the S3 bucket and returns the S3 key.
-func saveReport(ctx context.Context, awsClient *aws.AWSClient, config *Config, messageID string, tenantID string, data []byte) (string, error) {
-	s3Key := fmt.Sprintf("reports/%s/%s/%s.xml", tenantID, time.Now().Format("2006/01/02"), messageID)
-	contentType := "application/xml"
-	if err := awsClient.S3PutObject(ctx, config.ReportStorageBucketName, s3Key, contentType, data); err != nil {
-		return "", errors.NewLambdaError(500, fmt.Sprintf("error saving report to S3: %v", err))
+// reportKey returns the S3 key of a report, which is unique for each report of each
+// attachment of an email.  The key is dated by when the email was received rather than when
+// it was processed, so a redelivered message overwrites the same objects.  A message without
+// a valid timestamp is invalid, as no stable key can be derived for it.
+func reportKey(sqsMessage *models.IngestMessage, attachmentIndex int, reportIndex int) (string, error) {
+	seconds, err := strconv.ParseInt(sqsMessage.MessageTimestamp, 10, 64)
+	if err != nil {
+		return "", errors.Wrap(errors.InvalidInput, err, "error parsing message timestamp")
+	}
+	received := time.Unix(seconds, 0)
+	return fmt.Sprintf("reports/%s/%s/%s/%d-%d.xml.gz", sqsMessage.TenantID, received.UTC().Format("2006/01/02"), sqsMessage.MessageID, attachmentIndex, reportIndex), nil
+}
+
+// saveReport saves the report data to the S3 bucket under the given key.  Reports are stored
+// gzip-compressed, as XML compresses to a fraction of its size, and tagged with the tenant
+// and the email they were received in.
+func saveReport(ctx context.Context, awsClient aws.ObjectStore, config *Config, sqsMessage *models.IngestMessage, s3Key string, format compress.Format, data []byte) error {
+	opts := aws.S3ObjectOptions{
+		ContentType: "application/xml",
+		Metadata: map[string]string{
+			"attachment-format": string(format),
+		},
+		Tags: map[string]string{
+			aws.TagTenantID:  sqsMessage.TenantID,
+			aws.TagMessageID: sqsMessage.MessageID,
+			aws.TagStage:     stageName,
+		},
+	}
+	if err := awsClient.S3PutGzipObject(ctx, config.ReportStorageBucketName, s3Key, opts, bytes.NewReader(data)); err != nil {
+		return errors.Classify(err, "error saving report to S3")
 	}
 
-	return s3Key, nil
+	return nil
 }
@@ -1,6 +1,15 @@
 package main
 
+import "github.com/rsturla/dmarc-monitor/services/ingest-service/internal/compress"
+
 type Config struct {
 	ReportStorageBucketName string `env:"INGEST_STORAGE_BUCKET_NAME"`
-	NextStageQueueURL       string `env:"NEXT_STAGE_QUEUE_URL"`
+	NextStageQueueURL       string `env:"NEXT_STAGE_QUEUE_URL" validate:"url"`
+	IdempotencyTableName    string `env:"IDEMPOTENCY_TABLE_NAME"`
+	EventBusName            string `env:"EVENT_BUS_NAME"`
+
+	// DecompressionLimits bound the reports extracted from an attachment, read from the
+	// DECOMPRESSION_MAX_BYTES, DECOMPRESSION_MAX_RATIO, DECOMPRESSION_MAX_ZIP_ENTRIES and
+	// DECOMPRESSION_MAX_DEPTH environment variables
+	DecompressionLimits compress.Limits `prefix:"DECOMPRESSION_"`
 }
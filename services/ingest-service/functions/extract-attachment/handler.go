@@ -5,56 +5,86 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strconv"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/config"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/email/dkim"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/email/message"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/errors"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/quarantine"
 )
 
-func handler(ctx context.Context, sqsEvent events.SQSEvent) error {
+func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
 	config, err := config.NewConfig[Config]()
 	if err != nil {
-		return errors.NewLambdaError(500, fmt.Sprintf("error loading configuration: %v", err))
+		return events.SQSEventResponse{}, errors.NewLambdaError(500, fmt.Sprintf("error loading configuration: %v", err))
 	}
 
 	awsClient, err := aws.NewAWSClient(ctx)
 	if err != nil {
-		return errors.NewLambdaError(500, fmt.Sprintf("error creating AWS client: %v", err))
+		return events.SQSEventResponse{}, errors.NewLambdaError(500, fmt.Sprintf("error creating AWS client: %v", err))
 	}
 
-	for _, record := range sqsEvent.Records {
-		if err := processRecord(ctx, awsClient, config, record); err != nil {
-			log.Printf("Error processing SQS message with MessageID %s: %v", record.MessageId, err)
-			return errors.NewLambdaError(500, fmt.Sprintf("error processing message with SQS MessageID %s: %v", record.MessageId, err))
-		}
-	}
+	return handleEvent(ctx, awsClient, dkim.NewDNSResolver(), config, sqsEvent), nil
+}
 
-	return nil
+// awsAPI is the subset of the AWS client used by this function, satisfied by *aws.AWSClient.
+type awsAPI interface {
+	aws.ObjectStore
+	aws.Queue
+	aws.Table
+	aws.EventPublisher
+}
+
+// handleEvent processes every record in the SQS event, reporting the records which failed so
+// only they are retried.  Records which failed permanently are quarantined instead, and the
+// reason the others failed is recorded for inspecting the dead-letter queue.  It is separate
+// from handler so it can be tested without AWS or DNS access.
+func handleEvent(ctx context.Context, awsClient awsAPI, keyResolver dkim.Resolver, config *Config, sqsEvent events.SQSEvent) events.SQSEventResponse {
+	return aws.ProcessSQSRecords(ctx, sqsEvent, func(ctx context.Context, record events.SQSMessage) error {
+		err := processRecord(ctx, awsClient, keyResolver, config, record)
+		if err = quarantine.Permanent(ctx, awsClient, config.ReportStorageBucketName, stageName, record, err); err != nil {
+			quarantine.RecordFailure(ctx, awsClient, config.ReportStorageBucketName, stageName, record, err)
+		}
+		return err
+	})
 }
 
 // processRecord processes an individual SQS record and extracts the attachment into the S3 bucket
-func processRecord(ctx context.Context, awsClient *aws.AWSClient, config *Config, record events.SQSMessage) error {
-	var sqsMessage models.IngestMessage
-	if err := aws.ParseSQSMessage(record.Body, &sqsMessage); err != nil {
-		return errors.NewLambdaError(500, fmt.Sprintf("error unmarshalling message: %v", err))
+func processRecord(ctx context.Context, awsClient awsAPI, keyResolver dkim.Resolver, config *Config, record events.SQSMessage) error {
+	stage := aws.StartStage(stageName, aws.ReceiveCount(record))
+	sqsMessage, err := models.ParseIngestMessage(record.Body)
+	if err != nil {
+		return err
 	}
 
-	rawEmail, err := getRawEmail(ctx, awsClient, config, sqsMessage.MessageID)
+	rawEmail, err := getRawEmail(ctx, awsClient, config, sqsMessage.RawS3ObjectPath)
 	if err != nil {
 		return err
 	}
 
+	// DKIM signatures cover the exact bytes that were received, so they must be verified
+	// against the raw email before it is parsed
+	sqsMessage.DKIMResults = verifyDKIM(ctx, keyResolver, rawEmail)
+	sqsMessage.ARCResult = validateARC(ctx, keyResolver, rawEmail)
+
 	email, err := message.ParseMail(bytes.NewReader(rawEmail))
 	if err != nil {
-		return errors.NewLambdaError(500, fmt.Sprintf("error parsing email: %v", err))
+		return errors.Wrap(errors.InvalidInput, err, "error parsing email")
 	}
 
-	for _, attachment := range email.Attachments {
-		// Save the report to the S3 bucket - under the reports/<message> key
-		if err := processEmailAttachment(ctx, &attachment, awsClient, config, &sqsMessage); err != nil {
+	idempotency := aws.NewIdempotency(awsClient, config.IdempotencyTableName)
+	for i, attachment := range email.Attachments {
+		// Each attachment is a unit of work, so attachments completed before a failure are
+		// not saved or published again when the message is redelivered
+		key := aws.IdempotencyKey{MessageID: sqsMessage.MessageID, Stage: stageName, Attachment: strconv.Itoa(i)}
+		err := idempotency.Do(ctx, key, func(ctx context.Context) error {
+			return processAttachment(ctx, i, &attachment, awsClient, config, &sqsMessage, stage)
+		})
+		if err != nil {
 			return err
 		}
 	}
@@ -62,12 +92,79 @@ func processRecord(ctx context.Context, awsClient *aws.AWSClient, config *Config
 	return nil
 }
 
+// processAttachment saves every report in the attachment to the S3 bucket - under the
+// reports/<tenant>/<date>/<message>/ prefix - and publishes them to the next stage together
+// once all have been saved.
+func processAttachment(ctx context.Context, index int, attachment *message.Attachment, awsClient awsAPI, config *Config, sqsMessage *models.IngestMessage, stage models.StageRecord) error {
+	messages, err := processEmailAttachment(ctx, index, attachment, awsClient, config, sqsMessage, stage)
+	if err != nil {
+		return err
+	}
+
+	if len(messages) > 0 {
+		if err := awsClient.SQSPublishMessageBatch(ctx, config.NextStageQueueURL, messages); err != nil {
+			return errors.Classify(err, "error publishing messages to SQS")
+		}
+	}
+
+	return nil
+}
+
 // getRawEmail retrieves the raw email from S3
-func getRawEmail(ctx context.Context, awsClient *aws.AWSClient, config *Config, messageID string) ([]byte, error) {
-	body, err := awsClient.S3GetObject(ctx, config.ReportStorageBucketName, messageID)
+func getRawEmail(ctx context.Context, awsClient aws.ObjectStore, config *Config, rawS3ObjectPath string) ([]byte, error) {
+	body, err := awsClient.S3GetObject(ctx, config.ReportStorageBucketName, rawS3ObjectPath)
 	if err != nil {
-		return nil, errors.NewLambdaError(500, fmt.Sprintf("error getting raw email from S3: %v", err))
+		if aws.IsObjectNotFound(err) {
+			return nil, errors.Wrap(errors.Permanent, err, "raw email not found in S3")
+		}
+		return nil, errors.Wrap(errors.Transient, err, "error getting raw email from S3")
 	}
 
 	return body, nil
 }
+
+// verifyDKIM verifies the DKIM signatures on the raw email.  Failed signatures do not stop
+// the report from being processed, but are recorded with it.  A header which cannot be split
+// into fields, which the email parser may still accept, is recorded as a single permerror.
+func verifyDKIM(ctx context.Context, keyResolver dkim.Resolver, rawEmail []byte) []models.DKIMResult {
+	results, err := dkim.Verify(ctx, rawEmail, keyResolver)
+	if err != nil {
+		log.Printf("DKIM signatures could not be verified: %v", err)
+		return []models.DKIMResult{{Result: string(dkim.StatusPermError)}}
+	}
+
+	dkimResults := make([]models.DKIMResult, len(results))
+	for i, result := range results {
+		if result.Err != nil {
+			log.Printf("DKIM signature from %s (selector %s) did not verify: %s: %v", result.Domain, result.Selector, result.Status, result.Err)
+		}
+		dkimResults[i] = models.DKIMResult{
+			Domain:   result.Domain,
+			Selector: result.Selector,
+			Result:   string(result.Status),
+		}
+	}
+
+	return dkimResults
+}
+
+// validateARC validates the ARC chain on the raw email, which is present when the report was
+// forwarded by an intermediary such as the tenant's own mailbox.  A header which cannot be
+// split into fields is recorded as having no chain, so it is not trusted.
+func validateARC(ctx context.Context, keyResolver dkim.Resolver, rawEmail []byte) models.ARCResult {
+	validation, err := message.ValidateARC(ctx, rawEmail, keyResolver)
+	if err != nil {
+		log.Printf("ARC chain could not be validated: %v", err)
+		return models.ARCResult{Result: string(message.ARCResultNone)}
+	}
+
+	if validation.Err != nil {
+		log.Printf("ARC chain did not validate: %v", validation.Err)
+	}
+
+	return models.ARCResult{
+		Result:                        string(validation.Result),
+		Instances:                     len(validation.Sets),
+		OriginalAuthenticationResults: validation.OriginalAuthResults(),
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/smithy-go"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws/awstest"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/compress"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/compress/compresstest"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/email/dkim"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/email/message"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/quarantine"
+)
+
+const (
+	testBucket   = "ingest-storage"
+	testQueueURL = "https://sqs.eu-west-2.amazonaws.com/000000000000/parse-report"
+	testReport   = `<?xml version="1.0" encoding="UTF-8" ?><feedback><report_metadata><report_id>1</report_id></report_metadata></feedback>`
+)
+
+var testConfig = &Config{
+	ReportStorageBucketName: testBucket,
+	NextStageQueueURL:       testQueueURL,
+	IdempotencyTableName:    "idempotency",
+	EventBusName:            "ingest-events",
+	DecompressionLimits:     compress.DefaultLimits,
+}
+
+func zipData(t *testing.T, names []string, data [][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("error creating zip entry: %v", err)
+		}
+		if _, err := f.Write(data[i]); err != nil {
+			t.Fatalf("error writing zip entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing zip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func buildEmail(t *testing.T, attachments ...*message.Part) []byte {
+	t.Helper()
+
+	b := message.NewBuilder().
+		From(&mail.Address{Address: "noreply-dmarc-support@google.com"}).
+		To(&mail.Address{Address: "tenant-a@ingest.example.com"}).
+		Subject("Report domain: example.com").
+		Date(time.Date(2024, 8, 1, 10, 30, 0, 0, time.UTC)).
+		Text("This is an aggregate report.")
+	for _, attachment := range attachments {
+		b.Attach(attachment)
+	}
+
+	raw, err := b.Bytes()
+	if err != nil {
+		t.Fatalf("error building email: %v", err)
+	}
+	return raw
+}
+
+func sqsEvent(t *testing.T, messageID string) events.SQSEvent {
+	t.Helper()
+
+	body, err := models.MarshalIngestMessage(models.IngestMessage{
+		MessageID:        messageID,
+		MessageTimestamp: "1722470400",
+		TenantID:         "tenant-a",
+		RawS3ObjectPath:  "raw/" + messageID,
+		History:          []models.StageRecord{{Stage: "enqueue-email", Attempt: 1}},
+	})
+	if err != nil {
+		t.Fatalf("error marshalling message: %v", err)
+	}
+	return events.SQSEvent{Records: []events.SQSMessage{{
+		MessageId:  "sqs-" + messageID,
+		Body:       body,
+		Attributes: map[string]string{"ApproximateReceiveCount": "2"},
+	}}}
+}
+
+func TestHandleEvent(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating Ed25519 key: %v", err)
+	}
+	resolver := dkim.StaticResolver{"google._domainkey.google.com": key.Public()}
+
+	gzipAttachment := message.NewAttachmentPart("google.com!example.com!1722470400!1722556799.xml.gz", "application/gzip", compresstest.Gzip(t, []byte(testReport)))
+	signed, err := dkim.Sign(buildEmail(t, gzipAttachment), dkim.SignOptions{Domain: "google.com", Selector: "google", Signer: key})
+	if err != nil {
+		t.Fatalf("error signing email: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		raw           []byte
+		reports       []string
+		filenames     []string
+		quarantined   bool
+		rejected      bool
+		dkim          []models.DKIMResult
+		storeErr      error
+		expectFailure bool
+	}{
+		{
+			name:      "Gzip attachment",
+			raw:       buildEmail(t, gzipAttachment),
+			reports:   []string{"0-0.xml.gz"},
+			filenames: []string{"google.com!example.com!1722470400!1722556799.xml"},
+		},
+		{
+			name: "Zip attachment with multiple reports",
+			raw: buildEmail(t, message.NewAttachmentPart("reports.zip", "application/zip",
+				zipData(t, []string{"a.xml", "b.xml"}, [][]byte{[]byte(testReport), []byte(testReport)}))),
+			reports:   []string{"0-0.xml.gz", "0-1.xml.gz"},
+			filenames: []string{"a.xml", "b.xml"},
+		},
+		{
+			name: "Multiple attachments",
+			raw: buildEmail(t, gzipAttachment,
+				message.NewAttachmentPart("report.xml", "text/xml", []byte(testReport))),
+			reports:   []string{"0-0.xml.gz", "1-0.xml.gz"},
+			filenames: []string{"google.com!example.com!1722470400!1722556799.xml", "report.xml"},
+		},
+		{
+			name: "Archive without reports is quarantined",
+			raw: buildEmail(t, message.NewAttachmentPart("reports.zip", "application/zip",
+				zipData(t, []string{"README.txt"}, [][]byte{[]byte("nothing to see")}))),
+			quarantined: true,
+			rejected:    true,
+		},
+		{
+			name:      "DKIM signed email",
+			raw:       signed,
+			reports:   []string{"0-0.xml.gz"},
+			filenames: []string{"google.com!example.com!1722470400!1722556799.xml"},
+			dkim:      []models.DKIMResult{{Domain: "google.com", Selector: "google", Result: "pass"}},
+		},
+		{
+			// net/mail accepts whitespace before the colon, but DKIM verification cannot split
+			// the header, so the email is processed without verified signatures
+			name:      "Malformed header field",
+			raw:       append([]byte("X-Forwarded-Note : from the tenant's mailbox\r\n"), buildEmail(t, gzipAttachment)...),
+			reports:   []string{"0-0.xml.gz"},
+			filenames: []string{"google.com!example.com!1722470400!1722556799.xml"},
+			dkim:      []models.DKIMResult{{Result: "permerror"}},
+		},
+		{
+			name:        "Missing raw email is quarantined",
+			quarantined: true,
+		},
+		{
+			name:          "Transient S3 error is retried",
+			raw:           buildEmail(t, gzipAttachment),
+			storeErr:      fmt.Errorf("service unavailable"),
+			expectFailure: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := awstest.NewObjectStore()
+			queue := awstest.NewQueue()
+			bus := awstest.NewEventBus()
+			client := struct {
+				*awstest.ObjectStore
+				*awstest.Queue
+				*awstest.Table
+				*awstest.EventBus
+			}{store, queue, awstest.NewTable(), bus}
+
+			if tt.raw != nil {
+				store.Put(testBucket, "raw/abc123", awstest.Object{Data: tt.raw})
+			}
+
+			store.Err = tt.storeErr
+
+			response := handleEvent(context.Background(), client, resolver, testConfig, sqsEvent(t, "abc123"))
+			if failed := len(response.BatchItemFailures) > 0; failed != tt.expectFailure {
+				t.Fatalf("handleEvent() failures = %v, expectFailure %v", response.BatchItemFailures, tt.expectFailure)
+			}
+			store.Err = nil
+
+			keys := store.Keys(testBucket, "reports/")
+			if len(keys) != len(tt.reports) {
+				t.Fatalf("expected %d reports, got %v", len(tt.reports), keys)
+			}
+			for i, key := range keys {
+				if expected := "reports/tenant-a/2024/08/01/abc123/" + tt.reports[i]; key != expected {
+					t.Errorf("report key = %s, expected %s", key, expected)
+				}
+				obj, _ := store.Object(testBucket, key)
+				if obj.ContentEncoding != "gzip" {
+					t.Errorf("report %s content encoding = %q, expected gzip", key, obj.ContentEncoding)
+				}
+				if obj.ContentType != "application/xml" {
+					t.Errorf("report %s content type = %q, expected application/xml", key, obj.ContentType)
+				}
+				if obj.Tags[aws.TagTenantID] != "tenant-a" || obj.Tags[aws.TagMessageID] != "abc123" {
+					t.Errorf("report %s tags = %v, expected tenant-a and abc123", key, obj.Tags)
+				}
+				if report := compresstest.Gunzip(t, obj.Data); string(report) != testReport {
+					t.Errorf("report %s = %q, expected %q", key, report, testReport)
+				}
+			}
+
+			messages := queue.Received(testQueueURL)
+			if len(messages) != len(tt.reports) {
+				t.Fatalf("expected %d messages, got %d", len(tt.reports), len(messages))
+			}
+			for i, message := range messages {
+				if messageType := message.Attributes[aws.MessageTypeAttribute]; messageType != reportMessageType {
+					t.Errorf("message %d type = %q, expected %q", i, messageType, reportMessageType)
+				}
+
+				msg, err := models.ParseIngestMessage(message.Body)
+				if err != nil {
+					t.Fatalf("error unmarshalling message: %v", err)
+				}
+				if msg.AttachmentS3ObjectPath != keys[i] {
+					t.Errorf("message %d attachment path = %s, expected %s", i, msg.AttachmentS3ObjectPath, keys[i])
+				}
+				if msg.ReportFilename != tt.filenames[i] {
+					t.Errorf("message %d report filename = %s, expected %s", i, msg.ReportFilename, tt.filenames[i])
+				}
+				if len(msg.DKIMResults) != len(tt.dkim) || (len(tt.dkim) > 0 && msg.DKIMResults[0] != tt.dkim[0]) {
+					t.Errorf("message %d DKIM results = %+v, expected %+v", i, msg.DKIMResults, tt.dkim)
+				}
+				if len(msg.History) != 2 || msg.History[0].Stage != "enqueue-email" || msg.History[1].Stage != stageName || msg.History[1].Attempt != 2 {
+					t.Errorf("message %d history = %+v, expected enqueue-email then the second attempt of %s", i, msg.History, stageName)
+				}
+			}
+
+			if quarantined := len(store.Keys(testBucket, "quarantine/")) > 0; quarantined != tt.quarantined {
+				t.Errorf("quarantined = %v, expected %v", quarantined, tt.quarantined)
+			}
+			if rejected := len(bus.Events(testConfig.EventBusName)) > 0; rejected != tt.rejected {
+				t.Errorf("ReportRejected published = %v, expected %v", rejected, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestHandleEventRedelivered(t *testing.T) {
+	store := awstest.NewObjectStore()
+	queue := awstest.NewQueue()
+	client := struct {
+		*awstest.ObjectStore
+		*awstest.Queue
+		*awstest.Table
+		*awstest.EventBus
+	}{store, queue, awstest.NewTable(), awstest.NewEventBus()}
+
+	store.Put(testBucket, "raw/abc123", awstest.Object{Data: buildEmail(t,
+		message.NewAttachmentPart("a.xml", "text/xml", []byte(testReport)),
+		message.NewAttachmentPart("b.xml", "text/xml", []byte(testReport)))})
+
+	// Publishing fails after the first attachment is complete, so the message is retried
+	failing := struct {
+		*awstest.ObjectStore
+		*failingQueue
+		*awstest.Table
+		*awstest.EventBus
+	}{store, &failingQueue{Queue: queue, failAfter: 1}, client.Table, client.EventBus}
+	if response := handleEvent(context.Background(), failing, dkim.StaticResolver{}, testConfig, sqsEvent(t, "abc123")); len(response.BatchItemFailures) != 1 {
+		t.Fatalf("expected the first delivery to fail, got %v", response.BatchItemFailures)
+	}
+
+	failure, err := quarantine.GetFailure(context.Background(), store, testBucket, stageName, "sqs-abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failure == nil || failure.Kind != "Transient" {
+		t.Errorf("failure record = %+v, expected a Transient failure", failure)
+	}
+
+	for range 2 {
+		if response := handleEvent(context.Background(), client, dkim.StaticResolver{}, testConfig, sqsEvent(t, "abc123")); len(response.BatchItemFailures) > 0 {
+			t.Fatalf("handleEvent() failures = %v", response.BatchItemFailures)
+		}
+	}
+
+	if keys := store.Keys(testBucket, "reports/"); len(keys) != 2 {
+		t.Errorf("expected 2 reports, got %v", keys)
+	}
+	if messages := queue.Messages(testQueueURL); len(messages) != 2 {
+		t.Errorf("expected 2 messages, got %d", len(messages))
+	}
+}
+
+func TestHandleEventPermanentPublishError(t *testing.T) {
+	store := awstest.NewObjectStore()
+	queue := awstest.NewQueue()
+	client := struct {
+		*awstest.ObjectStore
+		*failingQueue
+		*awstest.Table
+		*awstest.EventBus
+	}{store, &failingQueue{Queue: queue, err: fmt.Errorf("error publishing messages: %w", &smithy.GenericAPIError{Code: "AccessDenied"})}, awstest.NewTable(), awstest.NewEventBus()}
+
+	store.Put(testBucket, "raw/abc123", awstest.Object{Data: buildEmail(t, message.NewAttachmentPart("a.xml", "text/xml", []byte(testReport)))})
+
+	// Retrying cannot fix a request the function is not allowed to make, so the message is
+	// quarantined rather than left to reach the dead-letter queue
+	if response := handleEvent(context.Background(), client, dkim.StaticResolver{}, testConfig, sqsEvent(t, "abc123")); len(response.BatchItemFailures) > 0 {
+		t.Fatalf("handleEvent() failures = %v", response.BatchItemFailures)
+	}
+
+	keys := store.Keys(testBucket, "quarantine/")
+	if len(keys) != 1 {
+		t.Fatalf("expected the message to be quarantined, got %v", keys)
+	}
+	obj, _ := store.Object(testBucket, keys[0])
+	var record models.QuarantineRecord
+	if err := json.Unmarshal(obj.Data, &record); err != nil {
+		t.Fatalf("error unmarshalling quarantine record: %v", err)
+	}
+	if record.Kind != "Permanent" || !strings.Contains(record.Reason, "AccessDenied") {
+		t.Errorf("quarantine record = %+v, expected a Permanent AccessDenied failure", record)
+	}
+}
+
+func TestHandleEventIncompatibleMessage(t *testing.T) {
+	store := awstest.NewObjectStore()
+	queue := awstest.NewQueue()
+	client := struct {
+		*awstest.ObjectStore
+		*awstest.Queue
+		*awstest.Table
+		*awstest.EventBus
+	}{store, queue, awstest.NewTable(), awstest.NewEventBus()}
+
+	store.Put(testBucket, "raw/abc123", awstest.Object{Data: buildEmail(t, message.NewAttachmentPart("a.xml", "text/xml", []byte(testReport)))})
+
+	// A message published by a newer enqueue-email can never be processed by this build, so it
+	// is quarantined to be replayed once this stage is updated, rather than retried
+	body := fmt.Sprintf(`{"version":%d,"messageID":"abc123","tenantID":"tenant-a","s3ObjectPath":"raw/abc123"}`, models.IngestMessageVersion+1)
+	event := events.SQSEvent{Records: []events.SQSMessage{{MessageId: "sqs-abc123", Body: body}}}
+	if response := handleEvent(context.Background(), client, dkim.StaticResolver{}, testConfig, event); len(response.BatchItemFailures) > 0 {
+		t.Fatalf("handleEvent() failures = %v", response.BatchItemFailures)
+	}
+
+	keys := store.Keys(testBucket, "quarantine/")
+	if len(keys) != 1 {
+		t.Fatalf("expected the message to be quarantined, got %v", keys)
+	}
+	obj, _ := store.Object(testBucket, keys[0])
+	var record models.QuarantineRecord
+	if err := json.Unmarshal(obj.Data, &record); err != nil {
+		t.Fatalf("error unmarshalling quarantine record: %v", err)
+	}
+	if record.Kind != "InvalidInput" || !strings.Contains(record.Reason, models.ErrIncompatibleMessage.Error()) {
+		t.Errorf("quarantine record = %+v, expected an incompatible message version", record)
+	}
+	if messages := queue.Messages(testQueueURL); len(messages) > 0 {
+		t.Errorf("expected no messages, got %d", len(messages))
+	}
+}
+
+func TestHandleEventInvalidTimestamp(t *testing.T) {
+	store := awstest.NewObjectStore()
+	queue := awstest.NewQueue()
+	client := struct {
+		*awstest.ObjectStore
+		*awstest.Queue
+		*awstest.Table
+		*awstest.EventBus
+	}{store, queue, awstest.NewTable(), awstest.NewEventBus()}
+
+	store.Put(testBucket, "raw/abc123", awstest.Object{Data: buildEmail(t, message.NewAttachmentPart("a.xml", "text/xml", []byte(testReport)))})
+
+	// Reports are keyed by when the email was received, so without a timestamp no key can be
+	// derived that a redelivery would reproduce
+	body, err := models.MarshalIngestMessage(models.IngestMessage{MessageID: "abc123", MessageTimestamp: "yesterday", TenantID: "tenant-a", RawS3ObjectPath: "raw/abc123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := events.SQSEvent{Records: []events.SQSMessage{{MessageId: "sqs-abc123", Body: body}}}
+	if response := handleEvent(context.Background(), client, dkim.StaticResolver{}, testConfig, event); len(response.BatchItemFailures) > 0 {
+		t.Fatalf("handleEvent() failures = %v", response.BatchItemFailures)
+	}
+
+	if keys := store.Keys(testBucket, "quarantine/"); len(keys) != 1 {
+		t.Errorf("expected the message to be quarantined, got %v", keys)
+	}
+	if keys := store.Keys(testBucket, "reports/"); len(keys) > 0 {
+		t.Errorf("expected no reports, got %v", keys)
+	}
+	if messages := queue.Messages(testQueueURL); len(messages) > 0 {
+		t.Errorf("expected no messages, got %d", len(messages))
+	}
+}
+
+// failingQueue fails every batch published after the first failAfter, with err if set.
+type failingQueue struct {
+	*awstest.Queue
+	failAfter int
+	calls     int
+	err       error
+}
+
+func (q *failingQueue) SQSPublishMessageBatch(ctx context.Context, queueURL string, messages []aws.SQSMessage) error {
+	q.calls++
+	if q.calls > q.failAfter {
+		if q.err != nil {
+			return q.err
+		}
+		return stderrors.New("service unavailable")
+	}
+	return q.Queue.SQSPublishMessageBatch(ctx, queueURL, messages)
+}
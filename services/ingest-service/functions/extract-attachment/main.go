@@ -15,9 +15,13 @@ func main() {
 		if err != nil {
 			log.Printf("Error creating local event: %v\n", err)
 		}
-		if err := handler(ctx, event); err != nil {
+		response, err := handler(ctx, event)
+		if err != nil {
 			log.Printf("Error processing local event: %v\n", err)
 		}
+		for _, failure := range response.BatchItemFailures {
+			log.Printf("Failed to process message %s\n", failure.ItemIdentifier)
+		}
 	} else {
 		lambda.Start(handler)
 	}
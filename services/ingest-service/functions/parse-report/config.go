@@ -1,7 +1,15 @@
 package main
 
+import "github.com/rsturla/dmarc-monitor/services/ingest-service/internal/compress"
+
 type Config struct {
 	ReportStorageBucketName string `env:"INGEST_STORAGE_BUCKET_NAME"`
-	ReportTableName         string `env:"INGEST_TABLE_NAME"`
-	RecordTableName         string `env:"INGEST_RECORD_TABLE_NAME"`
+	TableName               string `env:"DMARC_TABLE_NAME"`
+	IdempotencyTableName    string `env:"IDEMPOTENCY_TABLE_NAME"`
+	EventBusName            string `env:"EVENT_BUS_NAME"`
+
+	// DecompressionLimits bound the size of a decompressed report, read from the
+	// DECOMPRESSION_MAX_BYTES, DECOMPRESSION_MAX_RATIO, DECOMPRESSION_MAX_ZIP_ENTRIES and
+	// DECOMPRESSION_MAX_DEPTH environment variables
+	DecompressionLimits compress.Limits `prefix:"DECOMPRESSION_"`
 }
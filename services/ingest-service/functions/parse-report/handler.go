@@ -1,77 +1,234 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	stderrors "errors"
 	"fmt"
+	"io"
 	"log"
+	"slices"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/compress"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/config"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/dmarc"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/dmarc/rua"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/errors"
 	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/quarantine"
 )
 
+// stageName identifies this function in idempotency records
+const stageName = "parse-report"
+
 // handler processes the SQS event
-func handler(ctx context.Context, sqsEvent events.SQSEvent) error {
+func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
 	cfg, err := config.NewConfig[Config]()
 	if err != nil {
-		return fmt.Errorf("error loading configuration: %w", err)
+		return events.SQSEventResponse{}, fmt.Errorf("error loading configuration: %w", err)
 	}
 
 	awsClient, err := aws.NewAWSClient(ctx)
 	if err != nil {
-		return fmt.Errorf("error creating AWS client: %w", err)
+		return events.SQSEventResponse{}, fmt.Errorf("error creating AWS client: %w", err)
+	}
+
+	return handleEvent(ctx, awsClient, cfg, sqsEvent), nil
+}
+
+// awsAPI is the subset of the AWS client used by this function, satisfied by *aws.AWSClient.
+type awsAPI interface {
+	aws.ObjectStore
+	aws.Table
+	aws.TableCounter
+	aws.EventPublisher
+}
+
+// handleEvent processes every record in the SQS event, reporting the records which failed so
+// only they are retried.  Records which failed permanently are quarantined instead, and the
+// reason the others failed is recorded for inspecting the dead-letter queue.  It is separate
+// from handler so it can be tested without AWS access.
+func handleEvent(ctx context.Context, awsClient awsAPI, cfg *Config, sqsEvent events.SQSEvent) events.SQSEventResponse {
+	return aws.ProcessSQSRecords(ctx, sqsEvent, func(ctx context.Context, record events.SQSMessage) error {
+		err := processRecord(ctx, awsClient, cfg, record)
+		if err = quarantine.Permanent(ctx, awsClient, cfg.ReportStorageBucketName, stageName, record, err); err != nil {
+			quarantine.RecordFailure(ctx, awsClient, cfg.ReportStorageBucketName, stageName, record, err)
+		}
+		return err
+	})
+}
+
+// ProcessRecord processes an individual SQS record
+func processRecord(ctx context.Context, awsClient awsAPI, cfg *Config, record events.SQSMessage) error {
+	stage := aws.StartStage(stageName, aws.ReceiveCount(record))
+	sqsMessage, err := models.ParseIngestMessage(record.Body)
+	if err != nil {
+		return err
 	}
 
-	for _, record := range sqsEvent.Records {
-		if err := processRecord(ctx, awsClient, cfg, record); err != nil {
-			log.Printf("Error processing message: %v", err)
-			return fmt.Errorf("error processing message: %w", err)
+	// Each report is a unit of work, so a redelivered message does not store it again
+	idempotency := aws.NewIdempotency(awsClient, cfg.IdempotencyTableName)
+	key := aws.IdempotencyKey{MessageID: sqsMessage.MessageID, Stage: stageName, Attachment: sqsMessage.AttachmentS3ObjectPath}
+	var stored *models.ReportStoredEvent
+	err = idempotency.Do(ctx, key, func(ctx context.Context) error {
+		body, err := getReport(ctx, awsClient, cfg, sqsMessage.AttachmentS3ObjectPath)
+		if err != nil {
+			return err
+		}
+
+		ruaReport, err := dmarc.ParseRUAReport(body)
+		if err != nil {
+			publishParseFailed(ctx, awsClient, cfg, sqsMessage, err)
+			return errors.Wrap(errors.InvalidInput, err, "error parsing report")
+		}
+
+		event, err := storeReports(ctx, awsClient, cfg, sqsMessage, stage, ruaReport)
+		if err != nil {
+			return err
 		}
+		stored = &event
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
+	// The event is published outside the unit of work, so failing to publish it cannot
+	// release the lock on a report which has already been stored
+	if stored != nil {
+		publishReportStored(ctx, awsClient, cfg, *stored)
+	}
 	return nil
 }
 
-// ProcessRecord processes an individual SQS record
-func processRecord(ctx context.Context, awsClient *aws.AWSClient, cfg *Config, record events.SQSMessage) error {
-	var sqsMessage models.IngestMessage
-	if err := aws.ParseSQSMessage(record.Body, &sqsMessage); err != nil {
-		return errors.NewLambdaError(500, fmt.Sprintf("error unmarshalling message: %v", err))
+// publishReportStored publishes a ReportStored event for a report that has been stored.
+// Failing to publish is only logged, since the report has already been stored.
+func publishReportStored(ctx context.Context, awsClient aws.EventPublisher, cfg *Config, event models.ReportStoredEvent) {
+	if err := awsClient.EventBridgePublishEvents(ctx, cfg.EventBusName, event); err != nil {
+		log.Printf("Error publishing ReportStored event for report %s: %v", event.ReportID, err)
 	}
+}
 
-	body, err := awsClient.S3GetObject(ctx, cfg.ReportStorageBucketName, sqsMessage.AttachmentS3ObjectPath)
+// publishParseFailed publishes a ParseFailed event for a report that could not be parsed.
+// Failing to publish is only logged, so the parse error is what gets reported.
+func publishParseFailed(ctx context.Context, awsClient aws.EventPublisher, cfg *Config, sqsMessage models.IngestMessage, cause error) {
+	err := awsClient.EventBridgePublishEvents(ctx, cfg.EventBusName, models.ParseFailedEvent{
+		TenantID:               sqsMessage.TenantID,
+		MessageID:              sqsMessage.MessageID,
+		AttachmentS3ObjectPath: sqsMessage.AttachmentS3ObjectPath,
+		Reason:                 cause.Error(),
+	})
 	if err != nil {
-		return err
+		log.Printf("Error publishing ParseFailed event for %s: %v", sqsMessage.AttachmentS3ObjectPath, err)
 	}
+}
 
-	ruaReport, err := dmarc.ParseRUAReport(body)
+// formatPeekSize is how much of a report is read to detect its format, which covers the magic
+// bytes of every format: the furthest are tar's, at offset 257.
+const formatPeekSize = 512
+
+// getReport retrieves a report from S3, decompressing it as it is read so the compressed report
+// is not held in memory alongside it.  Reports extracted before compressed storage was
+// introduced are stored as plain XML.
+func getReport(ctx context.Context, awsClient aws.ObjectStore, cfg *Config, key string) ([]byte, error) {
+	obj, err := awsClient.S3GetObjectReader(ctx, cfg.ReportStorageBucketName, key)
 	if err != nil {
-		return err
+		if aws.IsObjectNotFound(err) {
+			return nil, errors.Wrap(errors.Permanent, err, "report not found in S3")
+		}
+		return nil, err
 	}
+	defer obj.Close()
 
-	return storeReports(ctx, awsClient, cfg, sqsMessage, ruaReport)
+	// Errors reading the object are kept apart from errors in its contents, since only the
+	// latter mean the report is invalid
+	source := &sourceReader{r: obj}
+	body := bufio.NewReaderSize(source, formatPeekSize)
+	header, err := body.Peek(formatPeekSize)
+	if err != nil && err != io.EOF {
+		return nil, errors.Classify(err, "error reading report from S3")
+	}
+
+	format, err := compress.DetectFormat(header, key, "")
+	if err != nil {
+		return nil, errors.Wrap(errors.InvalidInput, err, "error detecting report format")
+	}
+
+	reader, err := compress.NewReader(body, format, cfg.DecompressionLimits)
+	if err == nil {
+		defer reader.Close()
+		var report []byte
+		if report, err = io.ReadAll(reader); err == nil {
+			return report, nil
+		}
+	}
+
+	switch {
+	case source.err != nil:
+		return nil, errors.Classify(source.err, "error reading report from S3")
+	case compress.IsLimitError(err):
+		return nil, errors.Wrap(errors.Security, err, "error decompressing report")
+	default:
+		return nil, errors.Wrap(errors.InvalidInput, err, "error decompressing report")
+	}
 }
 
-// StoreReports stores the DMARC reports and records in DynamoDB
-func storeReports(ctx context.Context, awsClient *aws.AWSClient, cfg *Config, sqsMessage models.IngestMessage, ruaReport *rua.RUA) error {
+// sourceReader records the error, other than io.EOF, returned by the reader it wraps.
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// storeReports stores the DMARC reports and records in DynamoDB and adds the records to the
+// daily rollups, returning the ReportStored event to publish.  The report keeps the message
+// history, ending with this stage, as a record of how it was ingested.
+func storeReports(ctx context.Context, awsClient awsAPI, cfg *Config, sqsMessage models.IngestMessage, stage models.StageRecord, ruaReport *rua.RUA) (models.ReportStoredEvent, error) {
 	dmarcReportItem := dmarc.CreateDmarcReportItem(sqsMessage.TenantID, ruaReport)
+	dmarcReportItem.AttachmentFormat = sqsMessage.AttachmentFormat
+	dmarcReportItem.ReportFilename = sqsMessage.ReportFilename
+	dmarcReportItem.DKIMResults = sqsMessage.DKIMResults
+	dmarcReportItem.ARCResult = sqsMessage.ARCResult
+	dmarcReportItem.History = append(slices.Clip(sqsMessage.History), stage.End())
 	dmarcRecordItems := dmarc.CreateDmarcRecordItems(dmarcReportItem, ruaReport)
 
-	if err := storeDmarcReportItem(ctx, awsClient, cfg.ReportTableName, dmarcReportItem); err != nil {
-		return err
+	if err := storeDmarcReportItem(ctx, awsClient, cfg.TableName, dmarcReportItem); err != nil {
+		return models.ReportStoredEvent{}, err
+	}
+
+	if err := storeDmarcRecordItems(ctx, awsClient, cfg.TableName, dmarcRecordItems); err != nil {
+		return models.ReportStoredEvent{}, err
 	}
 
-	return storeDmarcRecordItems(ctx, awsClient, cfg.RecordTableName, dmarcRecordItems)
+	if err := addDmarcRollupItems(ctx, awsClient, cfg.TableName, dmarc.CreateDmarcRollupItems(dmarcReportItem, dmarcRecordItems)); err != nil {
+		return models.ReportStoredEvent{}, err
+	}
+
+	return models.ReportStoredEvent{
+		TenantID:       sqsMessage.TenantID,
+		MessageID:      sqsMessage.MessageID,
+		ReportID:       dmarcReportItem.ReportId,
+		OrgName:        dmarcReportItem.OrgName,
+		Domain:         dmarcReportItem.Domain,
+		DateRangeBegin: dmarcReportItem.DateRangeBegin,
+		DateRangeEnd:   dmarcReportItem.DateRangeEnd,
+		RecordCount:    len(dmarcRecordItems),
+	}, nil
 }
 
 // StoreDmarcReportItem stores the DMARC report item in DynamoDB
-func storeDmarcReportItem(ctx context.Context, awsClient *aws.AWSClient, tableName string, item models.DmarcReportMetadataItem) error {
+func storeDmarcReportItem(ctx context.Context, awsClient aws.Table, tableName string, item models.DmarcReportMetadataItem) error {
 	reportStorageObject, err := attributevalue.MarshalMap(item)
 	if err != nil {
 		return fmt.Errorf("error marshalling DmarcReportItem: %w", err)
@@ -85,7 +242,7 @@ func storeDmarcReportItem(ctx context.Context, awsClient *aws.AWSClient, tableNa
 }
 
 // StoreDmarcRecordItems stores the DMARC record items in DynamoDB
-func storeDmarcRecordItems(ctx context.Context, awsClient *aws.AWSClient, tableName string, items []models.DmarcRecordItem) error {
+func storeDmarcRecordItems(ctx context.Context, awsClient aws.Table, tableName string, items []models.DmarcRecordItem) error {
 	reportStorageObjects := make([]map[string]dynamodbTypes.AttributeValue, len(items))
 	for i, record := range items {
 		recordStorageObject, err := attributevalue.MarshalMap(record)
@@ -96,8 +253,42 @@ func storeDmarcRecordItems(ctx context.Context, awsClient *aws.AWSClient, tableN
 	}
 
 	if err := awsClient.DynamoDBPutBatchItems(ctx, tableName, reportStorageObjects); err != nil {
+		var batchErr *aws.BatchWriteError
+		if stderrors.As(err, &batchErr) {
+			log.Printf("DmarcRecordItems not written to %s: %v", tableName, recordIDs(batchErr))
+		}
 		return fmt.Errorf("error putting DmarcRecordItems: %w", err)
 	}
 
 	return nil
 }
+
+// addDmarcRollupItems adds the DMARC rollup items of a report to the daily rollups in DynamoDB.
+// Rollups which already count the report are skipped, so when the report is redelivered after
+// only some were updated, the rest are updated without counting it twice.
+func addDmarcRollupItems(ctx context.Context, awsClient aws.TableCounter, tableName string, items []models.DmarcRollupItem) error {
+	for _, item := range items {
+		err := awsClient.DynamoDBAddCounters(ctx, tableName, dmarc.RollupUpdate(item))
+		if stderrors.Is(err, aws.ErrConditionFailed) {
+			log.Printf("DmarcRollupItem %s already counts report %s", item.SK, item.Report)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error updating DmarcRollupItem %s: %w", item.SK, err)
+		}
+	}
+
+	return nil
+}
+
+// recordIDs returns the sort keys of the record items that were not written, which identify
+// them within the report's partition.
+func recordIDs(batchErr *aws.BatchWriteError) []string {
+	var ids []string
+	for _, key := range batchErr.Keys("sk") {
+		if id, ok := key["sk"].(*dynamodbTypes.AttributeValueMemberS); ok {
+			ids = append(ids, id.Value)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws/awstest"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/compress"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/compress/compresstest"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+const testBucket = "ingest-storage"
+
+var testConfig = &Config{
+	ReportStorageBucketName: testBucket,
+	TableName:               "dmarc",
+	IdempotencyTableName:    "idempotency",
+	EventBusName:            "ingest-events",
+	DecompressionLimits:     compress.DefaultLimits,
+}
+
+func sqsEvent(t *testing.T, attachmentPath string) events.SQSEvent {
+	t.Helper()
+
+	body, err := models.MarshalIngestMessage(models.IngestMessage{
+		MessageID:              "abc123",
+		TenantID:               "tenant-a",
+		AttachmentS3ObjectPath: attachmentPath,
+		AttachmentFormat:       "gzip",
+		ReportFilename:         "report.xml",
+		DKIMResults:            []models.DKIMResult{{Domain: "google.com", Selector: "google", Result: "pass"}},
+		History: []models.StageRecord{
+			{Stage: "enqueue-email", Attempt: 1},
+			{Stage: "extract-attachment", Attempt: 1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error marshalling message: %v", err)
+	}
+	return events.SQSEvent{Records: []events.SQSMessage{{MessageId: "sqs-abc123", Body: body}}}
+}
+
+// newTable returns a table keyed as the DMARC table is.
+func newTable() *awstest.Table {
+	table := awstest.NewTable()
+	table.SetKey(testConfig.TableName, "pk", "sk")
+	return table
+}
+
+// itemsByType splits the items of the DMARC table into reports, records, rollups and the
+// markers of the reports counted by the rollups.
+func itemsByType(items []map[string]dynamodbTypes.AttributeValue) (reports, records, rollups, markers []map[string]dynamodbTypes.AttributeValue) {
+	for _, item := range items {
+		sk, _ := item["sk"].(*dynamodbTypes.AttributeValueMemberS)
+		switch {
+		case sk != nil && strings.HasPrefix(sk.Value, "REPORT#"):
+			reports = append(reports, item)
+		case sk != nil && strings.HasPrefix(sk.Value, "DAILY#"):
+			rollups = append(rollups, item)
+		case sk != nil && strings.HasPrefix(sk.Value, "COUNTED#"):
+			markers = append(markers, item)
+		default:
+			records = append(records, item)
+		}
+	}
+	return reports, records, rollups, markers
+}
+
+func TestHandleEvent(t *testing.T) {
+	report, err := os.ReadFile("testdata/report.xml")
+	if err != nil {
+		t.Fatalf("error reading report: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		key           string
+		object        *awstest.Object
+		records       int
+		rollups       int
+		events        []string
+		storeErr      error
+		busErr        error
+		quarantined   bool
+		expectFailure bool
+	}{
+		{
+			name:    "Compressed report",
+			key:     "reports/tenant-a/2024/08/01/abc123/0-0.xml.gz",
+			object:  &awstest.Object{Data: compresstest.Gzip(t, report), ContentEncoding: "gzip"},
+			records: 4,
+			rollups: 5,
+			events:  []string{"ReportStored"},
+		},
+		{
+			name:    "Uncompressed report stored before compression",
+			key:     "reports/tenant-a/2024/08/01/abc123.xml",
+			object:  &awstest.Object{Data: report},
+			records: 4,
+			rollups: 5,
+			events:  []string{"ReportStored"},
+		},
+		{
+			// The report has been stored, so it is not retried when the event cannot be published
+			name:    "Event bus unavailable",
+			key:     "reports/tenant-a/2024/08/01/abc123/0-0.xml.gz",
+			object:  &awstest.Object{Data: compresstest.Gzip(t, report), ContentEncoding: "gzip"},
+			records: 4,
+			rollups: 5,
+			busErr:  fmt.Errorf("service unavailable"),
+		},
+		{
+			name:        "Invalid report is quarantined",
+			key:         "reports/tenant-a/2024/08/01/abc123/0-0.xml.gz",
+			object:      &awstest.Object{Data: compresstest.Gzip(t, []byte("<feedback>")), ContentEncoding: "gzip"},
+			events:      []string{"ParseFailed"},
+			quarantined: true,
+		},
+		{
+			// Zeros compress far beyond the maximum ratio, so decompression stops part way
+			name:        "Decompression bomb is quarantined",
+			key:         "reports/tenant-a/2024/08/01/abc123/0-0.xml.gz",
+			object:      &awstest.Object{Data: compresstest.Gzip(t, make([]byte, 10<<20)), ContentEncoding: "gzip"},
+			quarantined: true,
+		},
+		{
+			name:        "Missing report is quarantined",
+			key:         "reports/tenant-a/2024/08/01/abc123/0-0.xml.gz",
+			quarantined: true,
+		},
+		{
+			name:          "Transient S3 error is retried",
+			key:           "reports/tenant-a/2024/08/01/abc123/0-0.xml.gz",
+			storeErr:      fmt.Errorf("service unavailable"),
+			expectFailure: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := awstest.NewObjectStore()
+			table := newTable()
+			bus := awstest.NewEventBus()
+			bus.Err = tt.busErr
+			client := struct {
+				*awstest.ObjectStore
+				*awstest.Table
+				*awstest.EventBus
+			}{store, table, bus}
+
+			if tt.object != nil {
+				store.Put(testBucket, tt.key, *tt.object)
+			}
+
+			store.Err = tt.storeErr
+
+			response := handleEvent(context.Background(), client, testConfig, sqsEvent(t, tt.key))
+			if failed := len(response.BatchItemFailures) > 0; failed != tt.expectFailure {
+				t.Fatalf("handleEvent() failures = %v, expectFailure %v", response.BatchItemFailures, tt.expectFailure)
+			}
+			if events := bus.EventTypes(testConfig.EventBusName); !slices.Equal(events, tt.events) {
+				t.Errorf("events = %v, expected %v", events, tt.events)
+			}
+
+			store.Err = nil
+			if quarantined := len(store.Keys(testBucket, "quarantine/parse-report/")) > 0; quarantined != tt.quarantined {
+				t.Errorf("quarantined = %v, expected %v", quarantined, tt.quarantined)
+			}
+			if tt.expectFailure || tt.quarantined {
+				if items := table.Items(testConfig.TableName); len(items) != 0 {
+					t.Errorf("expected no report or record items, got %d", len(items))
+				}
+				return
+			}
+
+			reportItems, recordItems, rollupItems, _ := itemsByType(table.Items(testConfig.TableName))
+			var reports []models.DmarcReportMetadataItem
+			if err := attributevalue.UnmarshalListOfMaps(reportItems, &reports); err != nil {
+				t.Fatalf("error unmarshalling report items: %v", err)
+			}
+			if len(reports) != 1 {
+				t.Fatalf("expected 1 report item, got %d", len(reports))
+			}
+			if reports[0].PK != "tenant-a#sturla.dev" || reports[0].SK != "REPORT#1721174400#1111111111111111111" {
+				t.Errorf("report key = %s %s, expected tenant-a#sturla.dev REPORT#1721174400#1111111111111111111", reports[0].PK, reports[0].SK)
+			}
+			if reports[0].ReportFilename != "report.xml" || len(reports[0].DKIMResults) != 1 {
+				t.Errorf("report item did not carry message metadata: %+v", reports[0])
+			}
+			if history := reports[0].History; len(history) != 3 || history[2].Stage != stageName || history[2].EndedAt.IsZero() {
+				t.Errorf("report item history = %+v, expected the message history followed by %s", history, stageName)
+			}
+
+			if len(recordItems) != tt.records {
+				t.Errorf("expected %d record items, got %d", tt.records, len(recordItems))
+			}
+			if len(rollupItems) != tt.rollups {
+				t.Errorf("expected %d rollup items, got %d", tt.rollups, len(rollupItems))
+			}
+		})
+	}
+}
+
+func TestHandleEventRedelivered(t *testing.T) {
+	report, err := os.ReadFile("testdata/report.xml")
+	if err != nil {
+		t.Fatalf("error reading report: %v", err)
+	}
+
+	store := awstest.NewObjectStore()
+	table := newTable()
+	client := struct {
+		*awstest.ObjectStore
+		*awstest.Table
+		*awstest.EventBus
+	}{store, table, awstest.NewEventBus()}
+
+	key := "reports/tenant-a/2024/08/01/abc123/0-0.xml.gz"
+	store.Put(testBucket, key, awstest.Object{Data: compresstest.Gzip(t, report), ContentEncoding: "gzip"})
+
+	if response := handleEvent(context.Background(), client, testConfig, sqsEvent(t, key)); len(response.BatchItemFailures) > 0 {
+		t.Fatalf("handleEvent() failures = %v", response.BatchItemFailures)
+	}
+
+	// The report has already been stored, so the redelivered message must not read it again
+	if err := store.S3DeleteObject(context.Background(), testBucket, key); err != nil {
+		t.Fatal(err)
+	}
+	if response := handleEvent(context.Background(), client, testConfig, sqsEvent(t, key)); len(response.BatchItemFailures) > 0 {
+		t.Fatalf("redelivered handleEvent() failures = %v", response.BatchItemFailures)
+	}
+
+	if reports, _, _, _ := itemsByType(table.Items(testConfig.TableName)); len(reports) != 1 {
+		t.Errorf("expected 1 report item, got %d", len(reports))
+	}
+	if items := table.Items(testConfig.IdempotencyTableName); len(items) != 1 {
+		t.Errorf("expected 1 idempotency record, got %d", len(items))
+	}
+}
+
+func TestHandleEventRollupsCountedOnce(t *testing.T) {
+	report, err := os.ReadFile("testdata/report.xml")
+	if err != nil {
+		t.Fatalf("error reading report: %v", err)
+	}
+
+	store := awstest.NewObjectStore()
+	table := newTable()
+	bus := awstest.NewEventBus()
+	key := "reports/tenant-a/2024/08/01/abc123/0-0.xml.gz"
+	store.Put(testBucket, key, awstest.Object{Data: compresstest.Gzip(t, report), ContentEncoding: "gzip"})
+
+	// Updating the rollups fails part way, so the message is retried with some rollups
+	// already counting the report
+	failing := struct {
+		*awstest.ObjectStore
+		*failingCounter
+		*awstest.EventBus
+	}{store, &failingCounter{Table: table, failAfter: 2}, bus}
+	if response := handleEvent(context.Background(), failing, testConfig, sqsEvent(t, key)); len(response.BatchItemFailures) != 1 {
+		t.Fatalf("expected the first delivery to fail, got %v", response.BatchItemFailures)
+	}
+
+	client := struct {
+		*awstest.ObjectStore
+		*awstest.Table
+		*awstest.EventBus
+	}{store, table, bus}
+	for range 2 {
+		if response := handleEvent(context.Background(), client, testConfig, sqsEvent(t, key)); len(response.BatchItemFailures) > 0 {
+			t.Fatalf("handleEvent() failures = %v", response.BatchItemFailures)
+		}
+	}
+
+	_, _, rollupItems, markers := itemsByType(table.Items(testConfig.TableName))
+	if len(markers) != 5 {
+		t.Errorf("expected 5 rollup markers, got %d", len(markers))
+	}
+	for _, item := range rollupItems {
+		if _, ok := item["reports"]; ok {
+			t.Errorf("rollup %v records its reports, expected them only in markers", item["sk"])
+		}
+	}
+
+	var rollups []models.DmarcRollupItem
+	if err := attributevalue.UnmarshalListOfMaps(rollupItems, &rollups); err != nil {
+		t.Fatalf("error unmarshalling rollup items: %v", err)
+	}
+	if len(rollups) != 5 {
+		t.Fatalf("expected 5 rollup items, got %d", len(rollups))
+	}
+
+	for _, rollup := range rollups {
+		if rollup.SK != "DAILY#REPORTER#2024-07-17#google.com" {
+			continue
+		}
+		expected := models.DmarcRollupItem{
+			PK:                    "tenant-a#sturla.dev",
+			SK:                    rollup.SK,
+			Domain:                "sturla.dev",
+			Day:                   "2024-07-17",
+			OrgName:               "google.com",
+			Messages:              7,
+			DKIMPass:              2,
+			SPFPass:               2,
+			Fail:                  5,
+			DispositionNone:       2,
+			DispositionQuarantine: 1,
+			DispositionReject:     4,
+		}
+		if !reflect.DeepEqual(rollup, expected) {
+			t.Errorf("reporter rollup = %+v, expected %+v", rollup, expected)
+		}
+	}
+}
+
+// failingCounter fails every counter update after the first failAfter.
+type failingCounter struct {
+	*awstest.Table
+	failAfter int
+	calls     int
+}
+
+func (c *failingCounter) DynamoDBAddCounters(ctx context.Context, tableName string, update aws.CounterUpdate) error {
+	c.calls++
+	if c.calls > c.failAfter {
+		return stderrors.New("service unavailable")
+	}
+	return c.Table.DynamoDBAddCounters(ctx, tableName, update)
+}
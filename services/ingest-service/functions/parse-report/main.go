@@ -16,9 +16,13 @@ func main() {
 		if err != nil {
 			log.Fatalf("Error creating local event: %v", err)
 		}
-		if err := handler(ctx, event); err != nil {
+		response, err := handler(ctx, event)
+		if err != nil {
 			log.Fatalf("Error processing local event: %v", err)
 		}
+		for _, failure := range response.BatchItemFailures {
+			log.Printf("Failed to process message %s", failure.ItemIdentifier)
+		}
 	} else {
 		lambda.Start(handler)
 	}
@@ -12,8 +12,9 @@ new StatelessStack(app, `${appName}-StatelessStack`, {
   receiverDomain: process.env.RECEIVER_DOMAIN || "dm.sturla.tech",
   extractAttachmentQueueArn: statefulStack.extractAttachmentQueue.queueArn,
   parseReportQueueArn: statefulStack.parseReportQueue.queueArn,
-  dmarcReportTableName: statefulStack.dmarcReportTable.tableName,
-  dmarcRecordTableName: statefulStack.dmarcRecordTable.tableName,
+  dmarcTableName: statefulStack.dmarcTable.tableName,
+  idempotencyTableName: statefulStack.idempotencyTable.tableName,
+  ingestEventBusName: statefulStack.ingestEventBus.eventBusName,
 });
 
 app.synth();
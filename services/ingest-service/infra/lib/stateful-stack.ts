@@ -5,6 +5,7 @@ import { Construct } from "constructs";
 import { DynamoDBTable, S3Bucket } from "cdk-constructs/datastores";
 import { SQSQueue } from "cdk-constructs/messaging";
 import { AttributeType } from "aws-cdk-lib/aws-dynamodb";
+import { EventBus } from "aws-cdk-lib/aws-events";
 
 export class StatefulStack extends Stack {
   public readonly ingestStorageBucket: Bucket;
@@ -12,8 +13,10 @@ export class StatefulStack extends Stack {
   public readonly extractAttachmentQueue: SQSQueue;
   public readonly parseReportQueue: SQSQueue;
 
-  public readonly dmarcReportTable: DynamoDBTable;
-  public readonly dmarcRecordTable: DynamoDBTable;
+  public readonly dmarcTable: DynamoDBTable;
+  public readonly idempotencyTable: DynamoDBTable;
+
+  public readonly ingestEventBus: EventBus;
 
   constructor(scope: Construct, id: string, props?: StackProps) {
     super(scope, id, props);
@@ -36,6 +39,15 @@ export class StatefulStack extends Stack {
             },
           ],
         },
+        {
+          prefix: "quarantine/",
+          expiration: Duration.days(90),
+        },
+        // Failure records are only needed while their messages can be in a dead-letter queue
+        {
+          prefix: "failures/",
+          expiration: Duration.days(14),
+        },
         {
           prefix: "reports/",
           expiration: Duration.days(365),
@@ -71,23 +83,66 @@ export class StatefulStack extends Stack {
       enableDeadLetterQueue: true,
     });
 
-    const dmarcReportTable = new DynamoDBTable(this, "DmarcReportTable", {
+    // DmarcTable: Reports and their records, partitioned by tenant and policy domain and
+    // sorted by the beginning of the report's date range.  Reports are also listed by tenant
+    // and by reporter, and records by source IP.  See internal/dmarc/keys.go for the key
+    // design
+    const dmarcTable = new DynamoDBTable(this, "DmarcTable", {
+      partitionKey: { name: "pk", type: AttributeType.STRING },
+      sortKey: { name: "sk", type: AttributeType.STRING },
+      globalSecondaryIndexes: [
+        {
+          indexName: "TenantIndex",
+          partitionKey: { name: "tenantId", type: AttributeType.STRING },
+          sortKey: { name: "sk", type: AttributeType.STRING },
+        },
+        {
+          indexName: "ReporterIndex",
+          partitionKey: { name: "reporterKey", type: AttributeType.STRING },
+          sortKey: { name: "sk", type: AttributeType.STRING },
+        },
+        {
+          indexName: "SourceIPIndex",
+          partitionKey: { name: "sourceIpKey", type: AttributeType.STRING },
+          sortKey: { name: "sk", type: AttributeType.STRING },
+        },
+      ],
+    });
+
+    // The report and record tables are replaced by DmarcTable.  They are kept until
+    // cmd/migrate-keys has copied their items into it, and can then be removed
+    new DynamoDBTable(this, "DmarcReportTable", {
+      partitionKey: {
+        name: "id",
+        type: AttributeType.STRING,
+      },
+    });
+    new DynamoDBTable(this, "DmarcRecordTable", {
       partitionKey: {
         name: "id",
         type: AttributeType.STRING,
       },
     });
-    const dmarcRecordTable = new DynamoDBTable(this, "DmarcRecordTable", {
+
+    // IdempotencyTable: Records which units of work each pipeline stage has completed,
+    // so redelivered messages are not processed twice.  Records expire after 14 days
+    const idempotencyTable = new DynamoDBTable(this, "IdempotencyTable", {
       partitionKey: {
         name: "id",
         type: AttributeType.STRING,
       },
+      timeToLiveAttribute: "ttl",
     });
 
+    // IngestEventBus: Events published when the pipeline reaches a milestone, such as a report
+    // being stored, for other services to react to
+    const ingestEventBus = new EventBus(this, "IngestEventBus");
+
     this.ingestStorageBucket = ingestStorageBucket;
     this.extractAttachmentQueue = extractAttachmentQueue;
     this.parseReportQueue = parseReportQueue;
-    this.dmarcReportTable = dmarcReportTable;
-    this.dmarcRecordTable = dmarcRecordTable;
+    this.dmarcTable = dmarcTable;
+    this.idempotencyTable = idempotencyTable;
+    this.ingestEventBus = ingestEventBus;
   }
 }
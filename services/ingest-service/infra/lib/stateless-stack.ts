@@ -3,6 +3,7 @@ import { Construct } from "constructs";
 import * as s3 from "aws-cdk-lib/aws-s3";
 import * as sqs from "aws-cdk-lib/aws-sqs";
 import * as dynamodb from "aws-cdk-lib/aws-dynamodb";
+import * as events from "aws-cdk-lib/aws-events";
 import * as ses from "aws-cdk-lib/aws-ses";
 import * as ses_actions from "aws-cdk-lib/aws-ses-actions";
 import * as iam from "aws-cdk-lib/aws-iam";
@@ -15,8 +16,9 @@ export interface StatelessStackProps extends cdk.StackProps {
   readonly receiverDomain: string;
   readonly extractAttachmentQueueArn: string;
   readonly parseReportQueueArn: string;
-  readonly dmarcReportTableName: string;
-  readonly dmarcRecordTableName: string;
+  readonly dmarcTableName: string;
+  readonly idempotencyTableName: string;
+  readonly ingestEventBusName: string;
 }
 
 export class StatelessStack extends cdk.Stack {
@@ -28,8 +30,32 @@ export class StatelessStack extends cdk.Stack {
       props.extractAttachmentQueueArn
     );
     const parseReportQueue = this.getSQSQueue(props.parseReportQueueArn);
-    const dmarcReportTable = this.getDynamoDBTable(props.dmarcReportTableName);
-    const dmarcRecordTable = this.getDynamoDBTable(props.dmarcRecordTableName);
+    const dmarcTable = this.getDynamoDBTable(props.dmarcTableName);
+    const idempotencyTable = this.getDynamoDBTable(props.idempotencyTableName);
+
+    // Every function records the units of work it has completed in the idempotency table
+    const idempotencyPolicy = new iam.PolicyStatement({
+      actions: [
+        "dynamodb:GetItem",
+        "dynamodb:PutItem",
+        "dynamodb:DeleteItem",
+      ],
+      resources: [idempotencyTable.tableArn],
+    });
+
+    // Without s3:ListBucket, S3 reports a missing object as AccessDenied rather than
+    // NoSuchKey, so the functions would retry it instead of quarantining the message
+    const listIngestStoragePolicy = new iam.PolicyStatement({
+      actions: ["s3:ListBucket"],
+      resources: [ingestStorageBucket.bucketArn],
+    });
+
+    // Every function publishes domain events to the ingest event bus
+    const ingestEventBus = this.getEventBus(props.ingestEventBusName);
+    const ingestEventBusPolicy = new iam.PolicyStatement({
+      actions: ["events:PutEvents"],
+      resources: [ingestEventBus.eventBusArn],
+    });
 
     // Create SES identity to for SES to establish trust with
     new ses.EmailIdentity(this, "EmailIdentity", {
@@ -68,6 +94,8 @@ export class StatelessStack extends cdk.Stack {
       {
         INGEST_STORAGE_BUCKET_NAME: ingestStorageBucket.bucketName,
         NEXT_STAGE_QUEUE_URL: extractAttachmentQueue.queueUrl,
+        IDEMPOTENCY_TABLE_NAME: idempotencyTable.tableName,
+        EVENT_BUS_NAME: ingestEventBus.eventBusName,
       }
     );
     const enqueueEmailFunctionPolicies: iam.PolicyStatement[] = [
@@ -79,6 +107,8 @@ export class StatelessStack extends cdk.Stack {
         actions: ["s3:GetObject"],
         resources: [`${ingestStorageBucket.bucketArn}/raw/*`],
       }),
+      idempotencyPolicy,
+      ingestEventBusPolicy,
     ];
     this.attachLambdaPolicies(
       enqueueEmailFunction,
@@ -111,6 +141,8 @@ export class StatelessStack extends cdk.Stack {
       {
         INGEST_STORAGE_BUCKET_NAME: ingestStorageBucket.bucketName,
         NEXT_STAGE_QUEUE_URL: parseReportQueue.queueUrl,
+        IDEMPOTENCY_TABLE_NAME: idempotencyTable.tableName,
+        EVENT_BUS_NAME: ingestEventBus.eventBusName,
       }
     );
 
@@ -120,6 +152,7 @@ export class StatelessStack extends cdk.Stack {
         eventSourceArn: extractAttachmentQueue.queueArn,
         batchSize: 10,
         maxBatchingWindow: cdk.Duration.seconds(10),
+        reportBatchItemFailures: true,
       }
     );
     const extractAttachmentFunctionPolicies: iam.PolicyStatement[] = [
@@ -127,9 +160,14 @@ export class StatelessStack extends cdk.Stack {
         actions: ["s3:GetObject"],
         resources: [`${ingestStorageBucket.bucketArn}/raw/*`],
       }),
+      listIngestStoragePolicy,
       new iam.PolicyStatement({
-        actions: ["s3:PutObject"],
-        resources: [`${ingestStorageBucket.bucketArn}/reports/*`],
+        actions: ["s3:PutObject", "s3:PutObjectTagging"],
+        resources: [
+          `${ingestStorageBucket.bucketArn}/reports/*`,
+          `${ingestStorageBucket.bucketArn}/quarantine/*`,
+          `${ingestStorageBucket.bucketArn}/failures/*`,
+        ],
       }),
       new iam.PolicyStatement({
         actions: ["sqs:SendMessage"],
@@ -143,6 +181,8 @@ export class StatelessStack extends cdk.Stack {
         ],
         resources: [extractAttachmentQueue.queueArn],
       }),
+      idempotencyPolicy,
+      ingestEventBusPolicy,
     ];
     this.attachLambdaPolicies(
       extractAttachmentFunction,
@@ -155,8 +195,9 @@ export class StatelessStack extends cdk.Stack {
       "../bin/parse-report",
       {
         INGEST_STORAGE_BUCKET_NAME: ingestStorageBucket.bucketName,
-        INGEST_TABLE_NAME: dmarcReportTable.tableName,
-        INGEST_RECORD_TABLE_NAME: dmarcRecordTable.tableName,
+        DMARC_TABLE_NAME: dmarcTable.tableName,
+        IDEMPOTENCY_TABLE_NAME: idempotencyTable.tableName,
+        EVENT_BUS_NAME: ingestEventBus.eventBusName,
       }
     );
 
@@ -164,12 +205,21 @@ export class StatelessStack extends cdk.Stack {
       eventSourceArn: parseReportQueue.queueArn,
       batchSize: 10,
       maxBatchingWindow: cdk.Duration.seconds(10),
+      reportBatchItemFailures: true,
     });
     const parseReportFunctionPolicies: iam.PolicyStatement[] = [
       new iam.PolicyStatement({
         actions: ["s3:GetObject"],
         resources: [`${ingestStorageBucket.bucketArn}/reports/*`],
       }),
+      new iam.PolicyStatement({
+        actions: ["s3:PutObject", "s3:PutObjectTagging"],
+        resources: [
+          `${ingestStorageBucket.bucketArn}/quarantine/*`,
+          `${ingestStorageBucket.bucketArn}/failures/*`,
+        ],
+      }),
+      listIngestStoragePolicy,
       new iam.PolicyStatement({
         actions: [
           "sqs:DeleteMessage",
@@ -179,9 +229,15 @@ export class StatelessStack extends cdk.Stack {
         resources: [parseReportQueue.queueArn],
       }),
       new iam.PolicyStatement({
-        actions: ["dynamodb:PutItem", "dynamodb:BatchWriteItem"],
-        resources: [dmarcReportTable.tableArn, dmarcRecordTable.tableArn],
+        actions: [
+          "dynamodb:PutItem",
+          "dynamodb:BatchWriteItem",
+          "dynamodb:UpdateItem",
+        ],
+        resources: [dmarcTable.tableArn],
       }),
+      idempotencyPolicy,
+      ingestEventBusPolicy,
     ];
     this.attachLambdaPolicies(parseReportFunction, parseReportFunctionPolicies);
   }
@@ -198,6 +254,10 @@ export class StatelessStack extends cdk.Stack {
     return dynamodb.Table.fromTableName(this, tableName, tableName);
   }
 
+  private getEventBus(eventBusName: string): events.IEventBus {
+    return events.EventBus.fromEventBusName(this, eventBusName, eventBusName);
+  }
+
   private setActiveReceiptRuleSet(receiptRuleSetName: string): void {
     const setActiveReceiptRuleSetSdkCall: cr.AwsSdkCall = {
       service: "SES",
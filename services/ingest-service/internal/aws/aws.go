@@ -2,32 +2,60 @@ package aws
 
 import (
 	"context"
-	"fmt"
+	"os"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
 )
 
-// AWSClient wraps S3 and SQS clients
+// AWSClient wraps S3, SQS, DynamoDB and EventBridge clients
 type AWSClient struct {
-	S3       *s3.Client
-	SQS      *sqs.Client
-	DynamoDb *dynamodb.Client
+	S3          *s3.Client
+	SQS         *sqs.Client
+	DynamoDb    *dynamodb.Client
+	EventBridge *eventbridge.Client
 }
 
 // NewAWSClient initializes a new AWSClient. While it initializes S3, SQS and DynamoDB clients,
-// the function is fast enough to not introduce any noticeable delay or overhead.
+// the function is fast enough to not introduce any noticeable delay or overhead.  Endpoints
+// can be overridden with environment variables, see ClientOptionsFromEnv.
 func NewAWSClient(ctx context.Context) (*AWSClient, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+	opts, err := ClientOptionsFromEnv()
 	if err != nil {
-		return nil, fmt.Errorf("error loading AWS SDK config: %w", err)
+		return nil, err
+	}
+
+	return NewAWSClientWithOptions(ctx, opts)
+}
+
+// NewAWSClientWithOptions initializes a new AWSClient connecting as described by opts.
+func NewAWSClientWithOptions(ctx context.Context, opts ClientOptions) (*AWSClient, error) {
+	cfg, err := opts.loadConfig(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	return &AWSClient{
-		S3:       s3.NewFromConfig(cfg),
-		SQS:      sqs.NewFromConfig(cfg),
-		DynamoDb: dynamodb.NewFromConfig(cfg),
+		S3:          opts.newS3Client(cfg),
+		SQS:         opts.newSQSClient(cfg),
+		DynamoDb:    opts.newDynamoDBClient(cfg),
+		EventBridge: opts.newEventBridgeClient(cfg),
 	}, nil
 }
+
+// StartStage returns a record of a pipeline stage starting to process a message now, run by
+// the current version of the Lambda function, which is unset when run locally.  The attempt
+// counts the times the stage has received the message.  Call End on the record once the stage
+// has finished, before appending it to the history of the messages it publishes.
+func StartStage(stage string, attempt int) models.StageRecord {
+	return models.StageRecord{
+		Stage:           stage,
+		FunctionVersion: os.Getenv("AWS_LAMBDA_FUNCTION_VERSION"),
+		StartedAt:       time.Now().UTC(),
+		Attempt:         attempt,
+	}
+}
@@ -0,0 +1,249 @@
+package awstest
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"strconv"
+	"sync"
+
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+)
+
+// Table is an in-memory aws.Table.  Items are keyed on the key attributes of their table, so
+// writing an item with the same key as an existing item replaces it, as DynamoDB does.
+type Table struct {
+	mu    sync.Mutex
+	items map[string][]map[string]dynamodbTypes.AttributeValue
+	keys  map[string][]string
+
+	// Key is the partition key attribute of tables without a key set by SetKey, "id" by
+	// default
+	Key string
+
+	// Err is returned by every method when set
+	Err error
+}
+
+var (
+	_ aws.Table        = (*Table)(nil)
+	_ aws.TableScanner = (*Table)(nil)
+	_ aws.TableCounter = (*Table)(nil)
+)
+
+// NewTable returns a Table with no items.
+func NewTable() *Table {
+	return &Table{
+		items: map[string][]map[string]dynamodbTypes.AttributeValue{},
+		keys:  map[string][]string{},
+		Key:   "id",
+	}
+}
+
+// SetKey sets the key attributes of a table, such as a partition key and a sort key.
+func (t *Table) SetKey(tableName string, keyAttributes ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.keys[tableName] = keyAttributes
+}
+
+// Items returns the items in the table, in the order they were first written.
+func (t *Table) Items(tableName string) []map[string]dynamodbTypes.AttributeValue {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]map[string]dynamodbTypes.AttributeValue(nil), t.items[tableName]...)
+}
+
+func (t *Table) DynamoDBGetItem(ctx context.Context, tableName string, key map[string]dynamodbTypes.AttributeValue) (map[string]dynamodbTypes.AttributeValue, error) {
+	if t.Err != nil {
+		return nil, t.Err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if i := t.find(tableName, key); i >= 0 {
+		return t.items[tableName][i], nil
+	}
+	return nil, nil
+}
+
+func (t *Table) DynamoDBPutItem(ctx context.Context, tableName string, item *map[string]dynamodbTypes.AttributeValue) error {
+	if t.Err != nil {
+		return t.Err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.put(tableName, *item)
+	return nil
+}
+
+func (t *Table) DynamoDBPutItemIfNotExists(ctx context.Context, tableName string, keyAttribute string, item map[string]dynamodbTypes.AttributeValue) error {
+	if t.Err != nil {
+		return t.Err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.find(tableName, item) >= 0 {
+		return aws.ErrConditionFailed
+	}
+	t.put(tableName, item)
+	return nil
+}
+
+func (t *Table) DynamoDBPutItemIfMatches(ctx context.Context, tableName string, item map[string]dynamodbTypes.AttributeValue, attribute string, expected dynamodbTypes.AttributeValue) error {
+	if t.Err != nil {
+		return t.Err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := t.find(tableName, item)
+	if i < 0 || !reflect.DeepEqual(t.items[tableName][i][attribute], expected) {
+		return aws.ErrConditionFailed
+	}
+	t.items[tableName][i] = item
+	return nil
+}
+
+func (t *Table) DynamoDBDeleteItem(ctx context.Context, tableName string, key map[string]dynamodbTypes.AttributeValue) error {
+	if t.Err != nil {
+		return t.Err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if i := t.find(tableName, key); i >= 0 {
+		t.items[tableName] = append(t.items[tableName][:i], t.items[tableName][i+1:]...)
+	}
+	return nil
+}
+
+func (t *Table) DynamoDBDeleteItemIfMatches(ctx context.Context, tableName string, key map[string]dynamodbTypes.AttributeValue, attribute string, expected dynamodbTypes.AttributeValue) error {
+	if t.Err != nil {
+		return t.Err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := t.find(tableName, key)
+	if i < 0 || !reflect.DeepEqual(t.items[tableName][i][attribute], expected) {
+		return aws.ErrConditionFailed
+	}
+	t.items[tableName] = append(t.items[tableName][:i], t.items[tableName][i+1:]...)
+	return nil
+}
+
+func (t *Table) DynamoDBPutBatchItems(ctx context.Context, tableName string, items []map[string]dynamodbTypes.AttributeValue) error {
+	if t.Err != nil {
+		return t.Err
+	}
+
+	for _, item := range items {
+		if len(item) == 0 {
+			return fmt.Errorf("missing required fields in item: %v", item)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, item := range items {
+		t.put(tableName, item)
+	}
+	return nil
+}
+
+func (t *Table) DynamoDBScan(ctx context.Context, tableName string, fn func(item map[string]dynamodbTypes.AttributeValue) error) error {
+	if t.Err != nil {
+		return t.Err
+	}
+
+	for _, item := range t.Items(tableName) {
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) DynamoDBAddCounters(ctx context.Context, tableName string, update aws.CounterUpdate) error {
+	if t.Err != nil {
+		return t.Err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.find(tableName, update.Marker) >= 0 {
+		return aws.ErrConditionFailed
+	}
+
+	item := maps.Clone(update.Key)
+	if i := t.find(tableName, update.Key); i >= 0 {
+		item = maps.Clone(t.items[tableName][i])
+	}
+	for name, value := range update.Attributes {
+		item[name] = value
+	}
+	for name, amount := range update.Counters {
+		var count int64
+		if n, ok := item[name].(*dynamodbTypes.AttributeValueMemberN); ok {
+			count, _ = strconv.ParseInt(n.Value, 10, 64)
+		}
+		item[name] = &dynamodbTypes.AttributeValueMemberN{Value: strconv.FormatInt(count+amount, 10)}
+	}
+
+	t.put(tableName, maps.Clone(update.Marker))
+	t.put(tableName, item)
+	return nil
+}
+
+// find returns the index of the item with the same key as item, or -1 if there is none.
+func (t *Table) find(tableName string, item map[string]dynamodbTypes.AttributeValue) int {
+	keyAttributes, ok := t.keys[tableName]
+	if !ok {
+		keyAttributes = []string{t.Key}
+	}
+	for _, name := range keyAttributes {
+		if _, ok := item[name]; !ok {
+			return -1
+		}
+	}
+
+	for i, existing := range t.items[tableName] {
+		if sameKey(existing, item, keyAttributes) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameKey reports whether two items have equal values for every key attribute.
+func sameKey(a, b map[string]dynamodbTypes.AttributeValue, keyAttributes []string) bool {
+	for _, name := range keyAttributes {
+		if !reflect.DeepEqual(a[name], b[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Table) put(tableName string, item map[string]dynamodbTypes.AttributeValue) {
+	if i := t.find(tableName, item); i >= 0 {
+		t.items[tableName][i] = item
+		return
+	}
+	t.items[tableName] = append(t.items[tableName], item)
+}
@@ -0,0 +1,55 @@
+package awstest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+)
+
+// EventBus is an in-memory aws.EventPublisher which records every published event.
+type EventBus struct {
+	mu     sync.Mutex
+	events map[string][]aws.Event
+
+	// Err is returned by every method when set
+	Err error
+}
+
+var _ aws.EventPublisher = (*EventBus)(nil)
+
+// NewEventBus returns an EventBus with no events.
+func NewEventBus() *EventBus {
+	return &EventBus{events: map[string][]aws.Event{}}
+}
+
+// Events returns the events published to the bus, in the order they were published.
+func (b *EventBus) Events(busName string) []aws.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]aws.Event(nil), b.events[busName]...)
+}
+
+// EventTypes returns the types of the events published to the bus, in the order they were
+// published.
+func (b *EventBus) EventTypes(busName string) []string {
+	events := b.Events(busName)
+	types := make([]string, len(events))
+	for i, event := range events {
+		types[i] = event.EventType()
+	}
+	return types
+}
+
+func (b *EventBus) EventBridgePublishEvents(ctx context.Context, busName string, events ...aws.Event) error {
+	if b.Err != nil {
+		return b.Err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.events[busName] = append(b.events[busName], events...)
+	return nil
+}
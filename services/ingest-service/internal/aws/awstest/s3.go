@@ -0,0 +1,187 @@
+// Package awstest provides in-memory implementations of the interfaces in the aws package,
+// so handlers can be tested without AWS access.
+package awstest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+)
+
+// Object is an object held by an ObjectStore.
+type Object struct {
+	Data            []byte
+	ContentType     string
+	ContentEncoding string
+	Metadata        map[string]string
+	Tags            map[string]string
+}
+
+// newObject returns an object holding data with the given options.
+func newObject(data []byte, opts aws.S3ObjectOptions) Object {
+	return Object{
+		Data:            data,
+		ContentType:     opts.ContentType,
+		ContentEncoding: opts.ContentEncoding,
+		Metadata:        opts.Metadata,
+		Tags:            opts.Tags,
+	}
+}
+
+// ObjectStore is an in-memory aws.ObjectStore.  Objects are held exactly as S3 would store
+// them, so objects written with S3PutGzipObject hold the compressed data.
+type ObjectStore struct {
+	mu      sync.Mutex
+	objects map[string]Object
+
+	// Err is returned by every method when set
+	Err error
+}
+
+var _ aws.ObjectStore = (*ObjectStore)(nil)
+
+// NewObjectStore returns an empty ObjectStore.
+func NewObjectStore() *ObjectStore {
+	return &ObjectStore{objects: map[string]Object{}}
+}
+
+// Object returns the object stored under the key, if any.
+func (s *ObjectStore) Object(bucket, key string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.objects[objectPath(bucket, key)]
+	return obj, ok
+}
+
+// Keys returns the keys of every object in the bucket under the prefix, in lexicographic
+// order as S3 lists them.
+func (s *ObjectStore) Keys(bucket, prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for path := range s.objects {
+		if key, ok := strings.CutPrefix(path, bucket+"/"); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Put stores an object directly, for seeding the store in tests.
+func (s *ObjectStore) Put(bucket, key string, obj Object) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.objects[objectPath(bucket, key)] = obj
+}
+
+func (s *ObjectStore) S3ObjectExists(ctx context.Context, bucket, key string) (bool, error) {
+	if s.Err != nil {
+		return false, s.Err
+	}
+
+	_, ok := s.Object(bucket, key)
+	return ok, nil
+}
+
+func (s *ObjectStore) S3GetObject(ctx context.Context, bucket, key string) ([]byte, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	obj, ok := s.Object(bucket, key)
+	if !ok {
+		return nil, fmt.Errorf("error getting object from S3: %w", &s3Types.NoSuchKey{Message: &key})
+	}
+	return bytes.Clone(obj.Data), nil
+}
+
+func (s *ObjectStore) S3GetObjectReader(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	data, err := s.S3GetObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *ObjectStore) S3PutObject(ctx context.Context, bucket, key string, opts aws.S3ObjectOptions, body []byte) error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	s.Put(bucket, key, newObject(bytes.Clone(body), opts))
+	return nil
+}
+
+func (s *ObjectStore) S3UploadObject(ctx context.Context, bucket, key string, opts aws.S3ObjectOptions, body io.Reader) error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("error uploading object to S3: %w", err)
+	}
+
+	s.Put(bucket, key, newObject(data, opts))
+	return nil
+}
+
+func (s *ObjectStore) S3PutGzipObject(ctx context.Context, bucket, key string, opts aws.S3ObjectOptions, body io.Reader) error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	if _, err := io.Copy(gzipWriter, body); err != nil {
+		return fmt.Errorf("error uploading compressed object to S3: %w", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return fmt.Errorf("error uploading compressed object to S3: %w", err)
+	}
+
+	opts.ContentEncoding = "gzip"
+	s.Put(bucket, key, newObject(buf.Bytes(), opts))
+	return nil
+}
+
+func (s *ObjectStore) S3ListObjects(ctx context.Context, bucket, prefix string, fn func(key string) error) error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	for _, key := range s.Keys(bucket, prefix) {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ObjectStore) S3DeleteObject(ctx context.Context, bucket, key string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.objects, objectPath(bucket, key))
+	return nil
+}
+
+func objectPath(bucket, key string) string {
+	return bucket + "/" + key
+}
@@ -0,0 +1,167 @@
+package awstest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+)
+
+// queuedMessage is a message in a Queue, with the state SQS tracks for receiving it.
+type queuedMessage struct {
+	aws.SQSReceivedMessage
+	invisibleUntil time.Time
+}
+
+// Queue is an in-memory aws.Queue which records every published message.  Published messages
+// can also be received and deleted, as with aws.DeadLetterQueue.
+type Queue struct {
+	mu               sync.Mutex
+	messages         map[string][]*queuedMessage
+	deadLetterQueues map[string]string
+	nextID           int
+
+	// Err is returned by every method when set
+	Err error
+}
+
+var (
+	_ aws.Queue           = (*Queue)(nil)
+	_ aws.DeadLetterQueue = (*Queue)(nil)
+)
+
+// NewQueue returns a Queue with no messages.
+func NewQueue() *Queue {
+	return &Queue{messages: map[string][]*queuedMessage{}, deadLetterQueues: map[string]string{}}
+}
+
+// Messages returns the bodies of the messages in the queue, in the order they were
+// published.
+func (q *Queue) Messages(queueURL string) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	bodies := make([]string, len(q.messages[queueURL]))
+	for i, message := range q.messages[queueURL] {
+		bodies[i] = message.Body
+	}
+	return bodies
+}
+
+// Received returns the messages in the queue including their attributes, in the order they
+// were published.
+func (q *Queue) Received(queueURL string) []aws.SQSMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	messages := make([]aws.SQSMessage, len(q.messages[queueURL]))
+	for i, message := range q.messages[queueURL] {
+		messages[i] = message.SQSMessage
+	}
+	return messages
+}
+
+// Put adds a message to the queue as if it had already been received receiveCount times,
+// such as a message moved to a dead-letter queue.
+func (q *Queue) Put(queueURL, messageID string, message aws.SQSMessage, receiveCount int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.messages[queueURL] = append(q.messages[queueURL], &queuedMessage{SQSReceivedMessage: aws.SQSReceivedMessage{
+		SQSMessage:   message,
+		MessageID:    messageID,
+		ReceiveCount: receiveCount,
+	}})
+}
+
+// SetDeadLetterQueue sets the dead-letter queue of a queue.
+func (q *Queue) SetDeadLetterQueue(queueURL, deadLetterQueueURL string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.deadLetterQueues[queueURL] = deadLetterQueueURL
+}
+
+func (q *Queue) SQSPublishMessage(ctx context.Context, queueURL, message string) error {
+	return q.SQSPublishMessageBatch(ctx, queueURL, []aws.SQSMessage{{Body: message}})
+}
+
+func (q *Queue) SQSPublishMessageBatch(ctx context.Context, queueURL string, messages []aws.SQSMessage) error {
+	if q.Err != nil {
+		return q.Err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, message := range messages {
+		q.nextID++
+		q.messages[queueURL] = append(q.messages[queueURL], &queuedMessage{SQSReceivedMessage: aws.SQSReceivedMessage{
+			SQSMessage: message,
+			MessageID:  fmt.Sprintf("message-%d", q.nextID),
+		}})
+	}
+	return nil
+}
+
+func (q *Queue) SQSDeadLetterQueueURL(ctx context.Context, queueURL string) (string, error) {
+	if q.Err != nil {
+		return "", q.Err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	deadLetterQueueURL, ok := q.deadLetterQueues[queueURL]
+	if !ok {
+		return "", fmt.Errorf("queue %s has no dead-letter queue", queueURL)
+	}
+	return deadLetterQueueURL, nil
+}
+
+func (q *Queue) SQSReceiveMessages(ctx context.Context, queueURL string, maxMessages int, visibilityTimeout time.Duration) ([]aws.SQSReceivedMessage, error) {
+	if q.Err != nil {
+		return nil, q.Err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var received []aws.SQSReceivedMessage
+	for _, message := range q.messages[queueURL] {
+		if len(received) == maxMessages {
+			break
+		}
+		if now.Before(message.invisibleUntil) {
+			continue
+		}
+
+		q.nextID++
+		message.ReceiveCount++
+		message.ReceiptHandle = fmt.Sprintf("receipt-%d", q.nextID)
+		message.invisibleUntil = now.Add(visibilityTimeout)
+		received = append(received, message.SQSReceivedMessage)
+	}
+	return received, nil
+}
+
+func (q *Queue) SQSDeleteMessage(ctx context.Context, queueURL, receiptHandle string) error {
+	if q.Err != nil {
+		return q.Err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	messages := q.messages[queueURL]
+	for i, message := range messages {
+		if message.ReceiptHandle == receiptHandle {
+			q.messages[queueURL] = append(messages[:i], messages[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no message in queue %s has receipt handle %s", queueURL, receiptHandle)
+}
@@ -0,0 +1,50 @@
+package aws
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// backoff is an exponential backoff policy with full jitter.
+type backoff struct {
+	base     time.Duration
+	max      time.Duration
+	attempts int
+}
+
+// deadlineMargin is the time left before the context deadline at which retries stop, leaving
+// the function time to report the failure before it is killed.
+const deadlineMargin = 500 * time.Millisecond
+
+// delay returns a random delay of up to base * 2^attempt, capped at max.
+func (b backoff) delay(attempt int) time.Duration {
+	d := b.base << attempt
+	if d > b.max || d <= 0 {
+		d = b.max
+	}
+	return time.Duration(rand.Int64N(int64(d) + 1))
+}
+
+// wait sleeps before the retry following the given attempt, which counts from zero.  It
+// returns false without sleeping if the attempts are exhausted or the context deadline is too
+// close to retry, and false if the context is cancelled while sleeping.
+func (b backoff) wait(ctx context.Context, attempt int) bool {
+	if attempt+1 >= b.attempts {
+		return false
+	}
+
+	delay := b.delay(attempt)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline)-deadlineMargin < delay {
+		return false
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
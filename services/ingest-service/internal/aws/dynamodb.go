@@ -2,7 +2,12 @@ package aws
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -21,10 +26,292 @@ func (c *AWSClient) DynamoDBPutItem(ctx context.Context, tableName string, item
 	return nil
 }
 
+// ErrConditionFailed is returned when a conditional write is rejected because the item does
+// not meet the condition.
+var ErrConditionFailed = errors.New("condition failed")
+
+// Gets a single item from a DynamoDB table with a strongly consistent read.  A nil item is
+// returned if there is no item with the key.
+func (c *AWSClient) DynamoDBGetItem(ctx context.Context, tableName string, key map[string]dynamodbTypes.AttributeValue) (map[string]dynamodbTypes.AttributeValue, error) {
+	consistentRead := true
+	output, err := c.DynamoDb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      &tableName,
+		Key:            key,
+		ConsistentRead: &consistentRead,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting item from DynamoDB table %s: %w", tableName, err)
+	}
+
+	return output.Item, nil
+}
+
+// Puts a single item into a DynamoDB table only if no item with the same key exists.  The
+// keyAttribute is the partition key of the table.  ErrConditionFailed is returned if the item
+// already exists.
+func (c *AWSClient) DynamoDBPutItemIfNotExists(ctx context.Context, tableName string, keyAttribute string, item map[string]dynamodbTypes.AttributeValue) error {
+	condition := "attribute_not_exists(#key)"
+	return c.dynamoDBPutItemConditional(ctx, tableName, item, &dynamodb.PutItemInput{
+		ConditionExpression:      &condition,
+		ExpressionAttributeNames: map[string]string{"#key": keyAttribute},
+	})
+}
+
+// Replaces a single item in a DynamoDB table only if the existing item has the expected value
+// for the attribute, so concurrent writers cannot overwrite each other.  ErrConditionFailed is
+// returned if the existing item does not match.
+func (c *AWSClient) DynamoDBPutItemIfMatches(ctx context.Context, tableName string, item map[string]dynamodbTypes.AttributeValue, attribute string, expected dynamodbTypes.AttributeValue) error {
+	condition := "#attribute = :expected"
+	return c.dynamoDBPutItemConditional(ctx, tableName, item, &dynamodb.PutItemInput{
+		ConditionExpression:       &condition,
+		ExpressionAttributeNames:  map[string]string{"#attribute": attribute},
+		ExpressionAttributeValues: map[string]dynamodbTypes.AttributeValue{":expected": expected},
+	})
+}
+
+func (c *AWSClient) dynamoDBPutItemConditional(ctx context.Context, tableName string, item map[string]dynamodbTypes.AttributeValue, input *dynamodb.PutItemInput) error {
+	input.TableName = &tableName
+	input.Item = item
+
+	_, err := c.DynamoDb.PutItem(ctx, input)
+	if err != nil {
+		var conditionErr *dynamodbTypes.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return fmt.Errorf("error putting item to DynamoDB table %s: %w", tableName, ErrConditionFailed)
+		}
+		return fmt.Errorf("error putting item to DynamoDB table %s: %w", tableName, err)
+	}
+
+	return nil
+}
+
+// CounterUpdate atomically adds to the number attributes of an item, creating the item and
+// the attributes if they do not exist.
+type CounterUpdate struct {
+	// Key is the key of the item to update
+	Key map[string]dynamodbTypes.AttributeValue
+
+	// Attributes are set on the item, such as attributes identifying what it counts
+	Attributes map[string]dynamodbTypes.AttributeValue
+
+	// Counters are the amounts to add to each number attribute
+	Counters map[string]int64
+
+	// Marker is the key of an item written with the update, recording that the update has
+	// been applied.  It has the same key attributes as the updated item.
+	Marker map[string]dynamodbTypes.AttributeValue
+}
+
+// Updates the counters of a single item in a DynamoDB table, unless the update's marker item
+// already exists, so a retried update is not counted twice.  The marker is put in the same
+// transaction as the update, so the updated item does not grow with every update applied.
+// ErrConditionFailed is returned if the update has already been applied.
+func (c *AWSClient) DynamoDBAddCounters(ctx context.Context, tableName string, update CounterUpdate) error {
+	input := counterUpdateInput(tableName, update)
+	_, err := c.DynamoDb.TransactWriteItems(ctx, input)
+	if err != nil {
+		var canceledErr *dynamodbTypes.TransactionCanceledException
+		if errors.As(err, &canceledErr) && conditionFailed(canceledErr.CancellationReasons) {
+			return fmt.Errorf("error updating item in DynamoDB table %s: %w", tableName, ErrConditionFailed)
+		}
+		return fmt.Errorf("error updating item in DynamoDB table %s: %w", tableName, err)
+	}
+
+	return nil
+}
+
+// conditionFailed reports whether a transaction was canceled because a condition failed.
+func conditionFailed(reasons []dynamodbTypes.CancellationReason) bool {
+	for _, reason := range reasons {
+		if reason.Code != nil && *reason.Code == "ConditionalCheckFailed" {
+			return true
+		}
+	}
+	return false
+}
+
+// counterUpdateInput builds the transaction of a counter update: a put of the marker item,
+// on the condition it does not exist, and the update of the counters.  Attribute names are
+// replaced with placeholders, in sorted order so the expressions are stable.
+func counterUpdateInput(tableName string, update CounterUpdate) *dynamodb.TransactWriteItemsInput {
+	names := map[string]string{}
+	values := map[string]dynamodbTypes.AttributeValue{}
+
+	var set []string
+	for i, name := range sortedKeys(update.Attributes) {
+		names[fmt.Sprintf("#a%d", i)] = name
+		values[fmt.Sprintf(":a%d", i)] = update.Attributes[name]
+		set = append(set, fmt.Sprintf("#a%d = :a%d", i, i))
+	}
+
+	var add []string
+	for i, name := range sortedKeys(update.Counters) {
+		names[fmt.Sprintf("#c%d", i)] = name
+		values[fmt.Sprintf(":c%d", i)] = &dynamodbTypes.AttributeValueMemberN{Value: strconv.FormatInt(update.Counters[name], 10)}
+		add = append(add, fmt.Sprintf("#c%d :c%d", i, i))
+	}
+
+	expression := "ADD " + strings.Join(add, ", ")
+	if len(set) > 0 {
+		expression = "SET " + strings.Join(set, ", ") + " " + expression
+	}
+	condition := "attribute_not_exists(#key)"
+
+	return &dynamodb.TransactWriteItemsInput{
+		TransactItems: []dynamodbTypes.TransactWriteItem{
+			{Put: &dynamodbTypes.Put{
+				TableName:                &tableName,
+				Item:                     update.Marker,
+				ConditionExpression:      &condition,
+				ExpressionAttributeNames: map[string]string{"#key": sortedKeys(update.Key)[0]},
+			}},
+			{Update: &dynamodbTypes.Update{
+				TableName:                 &tableName,
+				Key:                       update.Key,
+				UpdateExpression:          &expression,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			}},
+		},
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Deletes a single item from a DynamoDB table.  Deleting an item that does not exist is not
+// an error.
+func (c *AWSClient) DynamoDBDeleteItem(ctx context.Context, tableName string, key map[string]dynamodbTypes.AttributeValue) error {
+	_, err := c.DynamoDb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: &tableName,
+		Key:       key,
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting item from DynamoDB table %s: %w", tableName, err)
+	}
+
+	return nil
+}
+
+// Deletes a single item from a DynamoDB table only if it has the expected value for the
+// attribute, so an item replaced by another writer is left in place.  ErrConditionFailed is
+// returned if the item does not exist or does not match.
+func (c *AWSClient) DynamoDBDeleteItemIfMatches(ctx context.Context, tableName string, key map[string]dynamodbTypes.AttributeValue, attribute string, expected dynamodbTypes.AttributeValue) error {
+	condition := "#attribute = :expected"
+	_, err := c.DynamoDb.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 &tableName,
+		Key:                       key,
+		ConditionExpression:       &condition,
+		ExpressionAttributeNames:  map[string]string{"#attribute": attribute},
+		ExpressionAttributeValues: map[string]dynamodbTypes.AttributeValue{":expected": expected},
+	})
+	if err != nil {
+		var conditionErr *dynamodbTypes.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return fmt.Errorf("error deleting item from DynamoDB table %s: %w", tableName, ErrConditionFailed)
+		}
+		return fmt.Errorf("error deleting item from DynamoDB table %s: %w", tableName, err)
+	}
+
+	return nil
+}
+
+// QueryInput describes a query of a DynamoDB table or one of its indexes.
+type QueryInput struct {
+	TableName string
+
+	// IndexName is the index to query, or empty to query the table
+	IndexName string
+
+	KeyConditionExpression    string
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues map[string]dynamodbTypes.AttributeValue
+
+	// Limit is the most items to return, or zero for as many as fit in a response
+	Limit int32
+
+	// ExclusiveStartKey is the key to continue from, returned by the previous page
+	ExclusiveStartKey map[string]dynamodbTypes.AttributeValue
+
+	// Descending returns items in descending sort key order
+	Descending bool
+}
+
+// Queries a DynamoDB table or index, returning a single page of items and the key to continue
+// from.  The key is nil when there are no more items.
+func (c *AWSClient) DynamoDBQuery(ctx context.Context, input QueryInput) ([]map[string]dynamodbTypes.AttributeValue, map[string]dynamodbTypes.AttributeValue, error) {
+	query := &dynamodb.QueryInput{
+		TableName:                 &input.TableName,
+		KeyConditionExpression:    &input.KeyConditionExpression,
+		ExpressionAttributeNames:  input.ExpressionAttributeNames,
+		ExpressionAttributeValues: input.ExpressionAttributeValues,
+		ExclusiveStartKey:         input.ExclusiveStartKey,
+	}
+	if input.IndexName != "" {
+		query.IndexName = &input.IndexName
+	}
+	if input.Limit > 0 {
+		query.Limit = &input.Limit
+	}
+	if input.Descending {
+		scanIndexForward := false
+		query.ScanIndexForward = &scanIndexForward
+	}
+
+	output, err := c.DynamoDb.Query(ctx, query)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying DynamoDB table %s: %w", input.TableName, err)
+	}
+
+	return output.Items, output.LastEvaluatedKey, nil
+}
+
+// Scans every item in a DynamoDB table a page at a time, calling fn for each item.  Scanning
+// stops at the first error fn returns.
+func (c *AWSClient) DynamoDBScan(ctx context.Context, tableName string, fn func(item map[string]dynamodbTypes.AttributeValue) error) error {
+	paginator := dynamodb.NewScanPaginator(c.DynamoDb, &dynamodb.ScanInput{
+		TableName: &tableName,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error scanning DynamoDB table %s: %w", tableName, err)
+		}
+		for _, item := range page.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Puts multiple items into a DynamoDB table in batches of 25 items.  The function owns the batch logic.
+// Items left unprocessed by DynamoDB, typically because the table is throttled, are retried with
+// backoff until the context deadline approaches.  Any items that still could not be written are
+// returned in a BatchWriteError.
 func (c *AWSClient) DynamoDBPutBatchItems(ctx context.Context, tableName string, items []map[string]dynamodbTypes.AttributeValue) error {
 	const batchSize = 25
 
+	write := func(ctx context.Context, requests []dynamodbTypes.WriteRequest) ([]dynamodbTypes.WriteRequest, error) {
+		output, err := c.DynamoDb.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]dynamodbTypes.WriteRequest{
+				tableName: requests,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return output.UnprocessedItems[tableName], nil
+	}
+
+	var unprocessed []map[string]dynamodbTypes.AttributeValue
 	for i := 0; i < len(items); i += batchSize {
 		end := i + batchSize
 		if end > len(items) {
@@ -52,15 +339,69 @@ func (c *AWSClient) DynamoDBPutBatchItems(ctx context.Context, tableName string,
 			return fmt.Errorf("no valid items to write in batch starting at index %d", i)
 		}
 
-		_, err := c.DynamoDb.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
-			RequestItems: map[string][]dynamodbTypes.WriteRequest{
-				tableName: writeRequests,
-			},
-		})
+		// Leftover items are collected so the remaining batches are still attempted
+		remaining, err := writeBatch(ctx, writeRequests, batchWriteBackoff, write)
 		if err != nil {
 			return fmt.Errorf("error putting batch items to DynamoDB table %s (batch starting at index %d): %w", tableName, i, err)
 		}
+		for _, request := range remaining {
+			unprocessed = append(unprocessed, request.PutRequest.Item)
+		}
 	}
 
+	if len(unprocessed) > 0 {
+		return &BatchWriteError{TableName: tableName, Unprocessed: unprocessed}
+	}
 	return nil
 }
+
+// BatchWriteError is returned when items remain unprocessed after every retry of a batch
+// write.  The items were not written, so the caller must retry or quarantine the message
+// they came from.
+type BatchWriteError struct {
+	TableName   string
+	Unprocessed []map[string]dynamodbTypes.AttributeValue
+}
+
+// Error returns the error message.
+func (e *BatchWriteError) Error() string {
+	return fmt.Sprintf("%d items were not written to DynamoDB table %s", len(e.Unprocessed), e.TableName)
+}
+
+// Keys returns the given key attributes of each unprocessed item, identifying the items that
+// were not written.
+func (e *BatchWriteError) Keys(keyAttributes ...string) []map[string]dynamodbTypes.AttributeValue {
+	keys := make([]map[string]dynamodbTypes.AttributeValue, len(e.Unprocessed))
+	for i, item := range e.Unprocessed {
+		keys[i] = make(map[string]dynamodbTypes.AttributeValue, len(keyAttributes))
+		for _, name := range keyAttributes {
+			if value, ok := item[name]; ok {
+				keys[i][name] = value
+			}
+		}
+	}
+	return keys
+}
+
+// batchWriteBackoff retries unprocessed items for a little over ten seconds in total, which
+// is long enough for DynamoDB to adapt capacity to a burst of writes.
+var batchWriteBackoff = backoff{base: 50 * time.Millisecond, max: 5 * time.Second, attempts: 10}
+
+// writeBatch writes the requests, retrying any that are left unprocessed according to the
+// backoff policy.  It returns the requests that were still unprocessed when it gave up.
+func writeBatch(ctx context.Context, requests []dynamodbTypes.WriteRequest, b backoff, write func(context.Context, []dynamodbTypes.WriteRequest) ([]dynamodbTypes.WriteRequest, error)) ([]dynamodbTypes.WriteRequest, error) {
+	for attempt := 0; ; attempt++ {
+		unprocessed, err := write(ctx, requests)
+		if err != nil {
+			return nil, err
+		}
+		if len(unprocessed) == 0 {
+			return nil, nil
+		}
+		requests = unprocessed
+
+		if !b.wait(ctx, attempt) {
+			return requests, nil
+		}
+	}
+}
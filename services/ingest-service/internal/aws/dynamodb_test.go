@@ -0,0 +1,197 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func putRequests(ids ...string) []dynamodbTypes.WriteRequest {
+	requests := make([]dynamodbTypes.WriteRequest, len(ids))
+	for i, id := range ids {
+		requests[i] = dynamodbTypes.WriteRequest{PutRequest: &dynamodbTypes.PutRequest{
+			Item: map[string]dynamodbTypes.AttributeValue{"id": &dynamodbTypes.AttributeValueMemberS{Value: id}},
+		}}
+	}
+	return requests
+}
+
+func TestWriteBatch(t *testing.T) {
+	testBackoff := backoff{base: time.Millisecond, max: 5 * time.Millisecond, attempts: 4}
+	writeErr := errors.New("validation error")
+
+	tests := []struct {
+		name        string
+		unprocessed [][]dynamodbTypes.WriteRequest
+		writeErr    error
+		deadline    time.Duration
+		calls       int
+		remaining   int
+		expectErr   bool
+	}{
+		{
+			name:  "All items processed",
+			calls: 1,
+		},
+		{
+			name:        "Unprocessed items retried",
+			unprocessed: [][]dynamodbTypes.WriteRequest{putRequests("b", "c"), putRequests("c")},
+			calls:       3,
+		},
+		{
+			name:        "Attempts exhausted",
+			unprocessed: [][]dynamodbTypes.WriteRequest{putRequests("c"), putRequests("c"), putRequests("c"), putRequests("c")},
+			calls:       4,
+			remaining:   1,
+		},
+		{
+			name:        "Deadline too close to retry",
+			unprocessed: [][]dynamodbTypes.WriteRequest{putRequests("c")},
+			deadline:    deadlineMargin / 2,
+			calls:       1,
+			remaining:   1,
+		},
+		{
+			name:      "Request fails",
+			writeErr:  writeErr,
+			calls:     1,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.deadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+
+			calls := 0
+			write := func(ctx context.Context, requests []dynamodbTypes.WriteRequest) ([]dynamodbTypes.WriteRequest, error) {
+				calls++
+				if tt.writeErr != nil {
+					return nil, tt.writeErr
+				}
+				if calls <= len(tt.unprocessed) {
+					return tt.unprocessed[calls-1], nil
+				}
+				return nil, nil
+			}
+
+			remaining, err := writeBatch(ctx, putRequests("a", "b", "c"), testBackoff, write)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("writeBatch() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if calls != tt.calls {
+				t.Errorf("writeBatch() made %d calls, expected %d", calls, tt.calls)
+			}
+			if len(remaining) != tt.remaining {
+				t.Errorf("writeBatch() left %d items, expected %d", len(remaining), tt.remaining)
+			}
+		})
+	}
+}
+
+func TestBatchWriteErrorKeys(t *testing.T) {
+	err := &BatchWriteError{
+		TableName: "records",
+		Unprocessed: []map[string]dynamodbTypes.AttributeValue{{
+			"id":    &dynamodbTypes.AttributeValueMemberS{Value: "tenant#report#0"},
+			"count": &dynamodbTypes.AttributeValueMemberN{Value: "1"},
+		}},
+	}
+
+	keys := err.Keys("id")
+	if len(keys) != 1 || len(keys[0]) != 1 {
+		t.Fatalf("Keys() = %v, expected a single id attribute", keys)
+	}
+	if id, ok := keys[0]["id"].(*dynamodbTypes.AttributeValueMemberS); !ok || id.Value != "tenant#report#0" {
+		t.Errorf("Keys() id = %v, expected tenant#report#0", keys[0]["id"])
+	}
+}
+
+func TestCounterUpdateInput(t *testing.T) {
+	marker := map[string]dynamodbTypes.AttributeValue{
+		"pk": &dynamodbTypes.AttributeValueMemberS{Value: "tenant#example.com"},
+		"sk": &dynamodbTypes.AttributeValueMemberS{Value: "COUNTED#DAILY#SOURCE#2024-07-17#192.0.2.1#REPORT#1721174400#1"},
+	}
+	input := counterUpdateInput("dmarc", CounterUpdate{
+		Key: map[string]dynamodbTypes.AttributeValue{
+			"pk": &dynamodbTypes.AttributeValueMemberS{Value: "tenant#example.com"},
+			"sk": &dynamodbTypes.AttributeValueMemberS{Value: "DAILY#SOURCE#2024-07-17#192.0.2.1"},
+		},
+		Attributes: map[string]dynamodbTypes.AttributeValue{"day": &dynamodbTypes.AttributeValueMemberS{Value: "2024-07-17"}},
+		Counters:   map[string]int64{"spfPass": 2, "messages": 3},
+		Marker:     marker,
+	})
+
+	if len(input.TransactItems) != 2 {
+		t.Fatalf("TransactItems = %d, expected a put of the marker and an update", len(input.TransactItems))
+	}
+	put, update := input.TransactItems[0].Put, input.TransactItems[1].Update
+	if put == nil || update == nil {
+		t.Fatalf("TransactItems = %+v, expected a put then an update", input.TransactItems)
+	}
+
+	if !reflect.DeepEqual(put.Item, marker) {
+		t.Errorf("marker = %v, expected %v", put.Item, marker)
+	}
+	if expected := "attribute_not_exists(#key)"; *put.ConditionExpression != expected {
+		t.Errorf("marker ConditionExpression = %q, expected %q", *put.ConditionExpression, expected)
+	}
+	if put.ExpressionAttributeNames["#key"] != "pk" {
+		t.Errorf("marker #key = %q, expected pk", put.ExpressionAttributeNames["#key"])
+	}
+
+	if expected := "SET #a0 = :a0 ADD #c0 :c0, #c1 :c1"; *update.UpdateExpression != expected {
+		t.Errorf("UpdateExpression = %q, expected %q", *update.UpdateExpression, expected)
+	}
+	if update.ConditionExpression != nil {
+		t.Errorf("update ConditionExpression = %q, expected none", *update.ConditionExpression)
+	}
+	if update.ExpressionAttributeNames["#c0"] != "messages" || update.ExpressionAttributeNames["#c1"] != "spfPass" {
+		t.Errorf("counter names = %v, expected messages then spfPass", update.ExpressionAttributeNames)
+	}
+	if count, ok := update.ExpressionAttributeValues[":c0"].(*dynamodbTypes.AttributeValueMemberN); !ok || count.Value != "3" {
+		t.Errorf(":c0 = %v, expected 3", update.ExpressionAttributeValues[":c0"])
+	}
+}
+
+func TestConditionFailed(t *testing.T) {
+	code := func(c string) dynamodbTypes.CancellationReason {
+		return dynamodbTypes.CancellationReason{Code: &c}
+	}
+
+	tests := []struct {
+		name     string
+		reasons  []dynamodbTypes.CancellationReason
+		expected bool
+	}{
+		{
+			name:     "Marker exists",
+			reasons:  []dynamodbTypes.CancellationReason{code("ConditionalCheckFailed"), code("None")},
+			expected: true,
+		},
+		{
+			name:    "Transaction conflict",
+			reasons: []dynamodbTypes.CancellationReason{code("None"), code("TransactionConflict")},
+		},
+		{
+			name: "No reasons",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := conditionFailed(tt.reasons); got != tt.expected {
+				t.Errorf("conditionFailed() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
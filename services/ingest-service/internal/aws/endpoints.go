@@ -0,0 +1,173 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Environment variables read by ClientOptionsFromEnv.  The endpoint variables share their
+// names with those understood by the AWS CLI, so the same environment works for both.
+const (
+	EndpointEnv               = "AWS_ENDPOINT_URL"
+	S3EndpointEnv             = "AWS_ENDPOINT_URL_S3"
+	SQSEndpointEnv            = "AWS_ENDPOINT_URL_SQS"
+	DynamoDBEndpointEnv       = "AWS_ENDPOINT_URL_DYNAMODB"
+	SSMEndpointEnv            = "AWS_ENDPOINT_URL_SSM"
+	EventBridgeEndpointEnv    = "AWS_ENDPOINT_URL_EVENTBRIDGE"
+	SecretsManagerEndpointEnv = "AWS_ENDPOINT_URL_SECRETS_MANAGER"
+	S3UsePathStyleEnv         = "AWS_S3_USE_PATH_STYLE"
+)
+
+// ClientOptions overrides how the AWS clients connect, so they can be pointed at local
+// emulators such as LocalStack and MinIO.  The zero value uses the real AWS endpoints and the
+// default credential chain.
+type ClientOptions struct {
+	// Endpoint is used by every service without its own endpoint
+	Endpoint string
+
+	S3Endpoint             string
+	SQSEndpoint            string
+	DynamoDBEndpoint       string
+	SSMEndpoint            string
+	EventBridgeEndpoint    string
+	SecretsManagerEndpoint string
+
+	// S3UsePathStyle addresses buckets as <endpoint>/<bucket> rather than by subdomain, which
+	// emulators without wildcard DNS require
+	S3UsePathStyle bool
+
+	// Region overrides the region from the environment
+	Region string
+
+	// AccessKeyID and SecretAccessKey replace the default credential chain when both are set
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// ClientOptionsFromEnv returns the ClientOptions set by environment variables.  Credentials
+// and region are left to the default chain, which already reads them from the environment.
+func ClientOptionsFromEnv() (ClientOptions, error) {
+	opts := ClientOptions{
+		Endpoint:               os.Getenv(EndpointEnv),
+		S3Endpoint:             os.Getenv(S3EndpointEnv),
+		SQSEndpoint:            os.Getenv(SQSEndpointEnv),
+		DynamoDBEndpoint:       os.Getenv(DynamoDBEndpointEnv),
+		SSMEndpoint:            os.Getenv(SSMEndpointEnv),
+		EventBridgeEndpoint:    os.Getenv(EventBridgeEndpointEnv),
+		SecretsManagerEndpoint: os.Getenv(SecretsManagerEndpointEnv),
+	}
+
+	if value := os.Getenv(S3UsePathStyleEnv); value != "" {
+		usePathStyle, err := strconv.ParseBool(value)
+		if err != nil {
+			return ClientOptions{}, fmt.Errorf("invalid %s %q: %w", S3UsePathStyleEnv, value, err)
+		}
+		opts.S3UsePathStyle = usePathStyle
+	}
+
+	return opts, nil
+}
+
+// loadConfig loads the AWS SDK config, applying the region and credential overrides.
+func (o ClientOptions) loadConfig(ctx context.Context) (awssdk.Config, error) {
+	var loadOptions []func(*config.LoadOptions) error
+	if o.Region != "" {
+		loadOptions = append(loadOptions, config.WithRegion(o.Region))
+	}
+	if o.AccessKeyID != "" && o.SecretAccessKey != "" {
+		loadOptions = append(loadOptions, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(o.AccessKeyID, o.SecretAccessKey, "")))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return awssdk.Config{}, fmt.Errorf("error loading AWS SDK config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// endpoint returns the endpoint for a service, falling back to the shared endpoint.  Nil is
+// returned when neither is set, leaving the SDK to resolve the real AWS endpoint.
+func (o ClientOptions) endpoint(serviceEndpoint string) *string {
+	if serviceEndpoint != "" {
+		return &serviceEndpoint
+	}
+	if o.Endpoint != "" {
+		return &o.Endpoint
+	}
+	return nil
+}
+
+func (o ClientOptions) newS3Client(cfg awssdk.Config) *s3.Client {
+	return s3.NewFromConfig(cfg, func(s3Options *s3.Options) {
+		if endpoint := o.endpoint(o.S3Endpoint); endpoint != nil {
+			s3Options.BaseEndpoint = endpoint
+		}
+		s3Options.UsePathStyle = o.S3UsePathStyle
+	})
+}
+
+func (o ClientOptions) newSQSClient(cfg awssdk.Config) *sqs.Client {
+	return sqs.NewFromConfig(cfg, func(sqsOptions *sqs.Options) {
+		if endpoint := o.endpoint(o.SQSEndpoint); endpoint != nil {
+			sqsOptions.BaseEndpoint = endpoint
+		}
+	})
+}
+
+func (o ClientOptions) newDynamoDBClient(cfg awssdk.Config) *dynamodb.Client {
+	return dynamodb.NewFromConfig(cfg, func(dynamodbOptions *dynamodb.Options) {
+		if endpoint := o.endpoint(o.DynamoDBEndpoint); endpoint != nil {
+			dynamodbOptions.BaseEndpoint = endpoint
+		}
+	})
+}
+
+func (o ClientOptions) newEventBridgeClient(cfg awssdk.Config) *eventbridge.Client {
+	return eventbridge.NewFromConfig(cfg, func(eventbridgeOptions *eventbridge.Options) {
+		if endpoint := o.endpoint(o.EventBridgeEndpoint); endpoint != nil {
+			eventbridgeOptions.BaseEndpoint = endpoint
+		}
+	})
+}
+
+// NewSSMClient returns an SSM client configured by the options.
+func NewSSMClient(ctx context.Context, opts ClientOptions) (*ssm.Client, error) {
+	cfg, err := opts.loadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssm.NewFromConfig(cfg, func(ssmOptions *ssm.Options) {
+		if endpoint := opts.endpoint(opts.SSMEndpoint); endpoint != nil {
+			ssmOptions.BaseEndpoint = endpoint
+		}
+	}), nil
+}
+
+// NewSecretsManagerClient returns a Secrets Manager client configured by the options.
+func NewSecretsManagerClient(ctx context.Context, opts ClientOptions) (*secretsmanager.Client, error) {
+	cfg, err := opts.loadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return secretsmanager.NewFromConfig(cfg, func(secretsmanagerOptions *secretsmanager.Options) {
+		if endpoint := opts.endpoint(opts.SecretsManagerEndpoint); endpoint != nil {
+			secretsmanagerOptions.BaseEndpoint = endpoint
+		}
+	}), nil
+}
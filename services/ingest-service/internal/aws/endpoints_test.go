@@ -0,0 +1,94 @@
+package aws
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClientOptionsFromEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		expected  ClientOptions
+		expectErr bool
+	}{
+		{
+			name: "No overrides",
+		},
+		{
+			name: "Shared and service endpoints",
+			env: map[string]string{
+				EndpointEnv:       "http://localhost:4566",
+				S3EndpointEnv:     "http://localhost:9000",
+				S3UsePathStyleEnv: "true",
+			},
+			expected: ClientOptions{
+				Endpoint:       "http://localhost:4566",
+				S3Endpoint:     "http://localhost:9000",
+				S3UsePathStyle: true,
+			},
+		},
+		{
+			name:      "Invalid path style",
+			env:       map[string]string{S3UsePathStyleEnv: "sometimes"},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{EndpointEnv, S3EndpointEnv, SQSEndpointEnv, DynamoDBEndpointEnv, SSMEndpointEnv, EventBridgeEndpointEnv, SecretsManagerEndpointEnv, S3UsePathStyleEnv} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			opts, err := ClientOptionsFromEnv()
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("ClientOptionsFromEnv() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if opts != tt.expected {
+				t.Errorf("ClientOptionsFromEnv() = %+v, expected %+v", opts, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewAWSClientWithOptions(t *testing.T) {
+	opts := ClientOptions{
+		Endpoint:        "http://localhost:4566",
+		S3Endpoint:      "http://localhost:9000",
+		S3UsePathStyle:  true,
+		Region:          "eu-west-2",
+		AccessKeyID:     "test",
+		SecretAccessKey: "secret",
+	}
+
+	client, err := NewAWSClientWithOptions(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("NewAWSClientWithOptions() error = %v", err)
+	}
+
+	s3Options := client.S3.Options()
+	if s3Options.BaseEndpoint == nil || *s3Options.BaseEndpoint != opts.S3Endpoint {
+		t.Errorf("S3 endpoint = %v, expected %s", s3Options.BaseEndpoint, opts.S3Endpoint)
+	}
+	if !s3Options.UsePathStyle {
+		t.Error("expected S3 to use path style addressing")
+	}
+	if endpoint := client.SQS.Options().BaseEndpoint; endpoint == nil || *endpoint != opts.Endpoint {
+		t.Errorf("SQS endpoint = %v, expected %s", endpoint, opts.Endpoint)
+	}
+	if endpoint := client.DynamoDb.Options().BaseEndpoint; endpoint == nil || *endpoint != opts.Endpoint {
+		t.Errorf("DynamoDB endpoint = %v, expected %s", endpoint, opts.Endpoint)
+	}
+	if region := client.S3.Options().Region; region != opts.Region {
+		t.Errorf("region = %s, expected %s", region, opts.Region)
+	}
+
+	credentials, err := s3Options.Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("error retrieving credentials: %v", err)
+	}
+	if credentials.AccessKeyID != opts.AccessKeyID || credentials.SecretAccessKey != opts.SecretAccessKey {
+		t.Errorf("credentials = %s/%s, expected static credentials", credentials.AccessKeyID, credentials.SecretAccessKey)
+	}
+}
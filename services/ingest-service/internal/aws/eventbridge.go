@@ -0,0 +1,77 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	eventbridgeTypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+// EventSource is the source of every event published by the ingest service
+const EventSource = "dmarc-monitor.ingest-service"
+
+// eventBatchEntries is the most entries EventBridge accepts in a single PutEvents request
+const eventBatchEntries = 10
+
+// Event is a domain event published when the pipeline reaches a milestone.  The event type
+// becomes the EventBridge detail-type, so rules can match on it.
+type Event interface {
+	EventType() string
+}
+
+// eventDetail is the detail of every published event.  The event is wrapped so its schema
+// version can be matched on without knowing the event's fields.
+type eventDetail struct {
+	Version int   `json:"version"`
+	Data    Event `json:"data"`
+}
+
+// Publishes events to an EventBridge event bus, in batches of up to 10.  An error is returned
+// if any event is rejected.
+func (c *AWSClient) EventBridgePublishEvents(ctx context.Context, busName string, events ...Event) error {
+	for start := 0; start < len(events); start += eventBatchEntries {
+		end := min(start+eventBatchEntries, len(events))
+
+		entries := make([]eventbridgeTypes.PutEventsRequestEntry, 0, end-start)
+		for _, event := range events[start:end] {
+			entry, err := eventEntry(busName, event)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+
+		output, err := c.EventBridge.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: entries})
+		if err != nil {
+			return fmt.Errorf("error publishing events to %s: %w", busName, err)
+		}
+		if output.FailedEntryCount > 0 {
+			for _, entry := range output.Entries {
+				if entry.ErrorCode != nil {
+					return fmt.Errorf("%d events were not published to %s: %s: %s", output.FailedEntryCount, busName, *entry.ErrorCode, stringValue(entry.ErrorMessage))
+				}
+			}
+			return fmt.Errorf("%d events were not published to %s", output.FailedEntryCount, busName)
+		}
+	}
+
+	return nil
+}
+
+// eventEntry returns the PutEvents entry for an event.
+func eventEntry(busName string, event Event) (eventbridgeTypes.PutEventsRequestEntry, error) {
+	detail, err := json.Marshal(eventDetail{Version: models.EventSchemaVersion, Data: event})
+	if err != nil {
+		return eventbridgeTypes.PutEventsRequestEntry{}, fmt.Errorf("error marshalling %s event: %w", event.EventType(), err)
+	}
+
+	return eventbridgeTypes.PutEventsRequestEntry{
+		EventBusName: stringPtr(busName),
+		Source:       stringPtr(EventSource),
+		DetailType:   stringPtr(event.EventType()),
+		Detail:       stringPtr(string(detail)),
+	}, nil
+}
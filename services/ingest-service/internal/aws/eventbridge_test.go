@@ -0,0 +1,35 @@
+package aws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+func TestEventEntry(t *testing.T) {
+	event := models.ReportStoredEvent{TenantID: "tenant-a", MessageID: "abc123", ReportID: "1", RecordCount: 4}
+
+	entry, err := eventEntry("ingest-events", event)
+	if err != nil {
+		t.Fatalf("eventEntry() error = %v", err)
+	}
+
+	if *entry.EventBusName != "ingest-events" || *entry.Source != EventSource || *entry.DetailType != "ReportStored" {
+		t.Errorf("entry = %s %s %s, expected ingest-events %s ReportStored", *entry.EventBusName, *entry.Source, *entry.DetailType, EventSource)
+	}
+
+	var detail struct {
+		Version int                      `json:"version"`
+		Data    models.ReportStoredEvent `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(*entry.Detail), &detail); err != nil {
+		t.Fatalf("error unmarshalling detail: %v", err)
+	}
+	if detail.Version != models.EventSchemaVersion {
+		t.Errorf("detail version = %d, expected %d", detail.Version, models.EventSchemaVersion)
+	}
+	if detail.Data != event {
+		t.Errorf("detail data = %+v, expected %+v", detail.Data, event)
+	}
+}
@@ -0,0 +1,185 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrInProgress is returned by Idempotency.Do when another invocation holds the lock for the
+// same key.  The message should be retried once the other invocation has finished.
+var ErrInProgress = errors.New("already in progress")
+
+// Idempotency record statuses
+const (
+	idempotencyInProgress = "IN_PROGRESS"
+	idempotencyCompleted  = "COMPLETED"
+)
+
+// idempotencyKeyAttribute is the partition key of the idempotency table
+const idempotencyKeyAttribute = "id"
+
+// IdempotencyKey identifies a unit of work done by a pipeline stage for a single email.
+type IdempotencyKey struct {
+	MessageID string
+	Stage     string
+
+	// Attachment distinguishes units of work within the same email, and may be empty
+	Attachment string
+}
+
+// String returns the key as stored in the idempotency table.
+func (k IdempotencyKey) String() string {
+	return fmt.Sprintf("%s#%s#%s", k.Stage, k.MessageID, k.Attachment)
+}
+
+type idempotencyRecord struct {
+	ID            string `dynamodbav:"id"`
+	Status        string `dynamodbav:"status"`
+	LockExpiresAt int64  `dynamodbav:"lockExpiresAt"`
+	TTL           int64  `dynamodbav:"ttl"`
+}
+
+// Idempotency ensures a unit of work is completed at most once, however many times SQS
+// delivers the message it came from.  A record for each key is locked while the work runs
+// and marked completed afterwards; records expire with a DynamoDB TTL.
+type Idempotency struct {
+	table     Table
+	tableName string
+
+	// TTL is how long records are kept, which must exceed the time a message can be redelivered
+	TTL time.Duration
+
+	// LockTimeout is how long a lock is held before another invocation may take it over,
+	// which must exceed the Lambda timeout
+	LockTimeout time.Duration
+
+	now func() time.Time
+}
+
+// NewIdempotency returns an Idempotency backed by the given table.  Records are kept for 14
+// days, the longest SQS retains a message.
+func NewIdempotency(table Table, tableName string) *Idempotency {
+	return &Idempotency{
+		table:       table,
+		tableName:   tableName,
+		TTL:         14 * 24 * time.Hour,
+		LockTimeout: 15 * time.Minute,
+		now:         time.Now,
+	}
+}
+
+// completeBackoff is the retry policy for marking a unit of work completed.
+var completeBackoff = backoff{base: 20 * time.Millisecond, max: 500 * time.Millisecond, attempts: 5}
+
+// Do runs fn unless the unit of work identified by key has already completed, in which case
+// it returns nil without running fn.  ErrInProgress is returned if another invocation is
+// running the same unit of work.  If fn fails the lock is released so a retry can run it,
+// unless another invocation has since taken the lock over.
+//
+// Once fn has succeeded Do returns nil, even if the work cannot be marked completed, so the
+// message is not redelivered to run fn again once the lock expires.
+func (i *Idempotency) Do(ctx context.Context, key IdempotencyKey, fn func(context.Context) error) error {
+	lockExpiresAt, err := i.lock(ctx, key)
+	if err != nil {
+		if errors.Is(err, errAlreadyCompleted) {
+			log.Printf("Skipping %s, which has already completed", key)
+			return nil
+		}
+		return err
+	}
+
+	if err := fn(ctx); err != nil {
+		releaseErr := i.table.DynamoDBDeleteItemIfMatches(ctx, i.tableName, i.itemKey(key), "lockExpiresAt", lockExpiresAt)
+		if errors.Is(releaseErr, ErrConditionFailed) {
+			log.Printf("Idempotency lock for %s was taken over by another invocation, leaving it in place", key)
+		} else if releaseErr != nil {
+			log.Printf("Error releasing idempotency lock for %s, it will expire: %v", key, releaseErr)
+		}
+		return err
+	}
+
+	if err := i.complete(ctx, key); err != nil {
+		log.Printf("Error completing idempotency record for %s, its lock will expire: %v", key, err)
+	}
+	return nil
+}
+
+// complete marks the unit of work completed, retrying with backoff.
+func (i *Idempotency) complete(ctx context.Context, key IdempotencyKey) error {
+	now := i.now()
+	item, err := attributevalue.MarshalMap(idempotencyRecord{
+		ID:     key.String(),
+		Status: idempotencyCompleted,
+		TTL:    now.Add(i.TTL).Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("error marshalling idempotency record: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = i.table.DynamoDBPutItem(ctx, i.tableName, &item)
+		if err == nil || !completeBackoff.wait(ctx, attempt) {
+			return err
+		}
+	}
+}
+
+// errAlreadyCompleted is returned by lock when the unit of work has already completed.
+var errAlreadyCompleted = errors.New("already completed")
+
+// lock takes the lock for the key, taking over a lock that has expired.  It returns the
+// lockExpiresAt value of the lock, which identifies this invocation as its holder.
+func (i *Idempotency) lock(ctx context.Context, key IdempotencyKey) (dynamodbTypes.AttributeValue, error) {
+	existing, err := i.table.DynamoDBGetItem(ctx, i.tableName, i.itemKey(key))
+	if err != nil {
+		return nil, fmt.Errorf("error getting idempotency record for %s: %w", key, err)
+	}
+
+	now := i.now()
+	item, err := attributevalue.MarshalMap(idempotencyRecord{
+		ID:            key.String(),
+		Status:        idempotencyInProgress,
+		LockExpiresAt: now.Add(i.LockTimeout).Unix(),
+		TTL:           now.Add(i.TTL).Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling idempotency record: %w", err)
+	}
+
+	if existing == nil {
+		err = i.table.DynamoDBPutItemIfNotExists(ctx, i.tableName, idempotencyKeyAttribute, item)
+	} else {
+		var record idempotencyRecord
+		if err := attributevalue.UnmarshalMap(existing, &record); err != nil {
+			return nil, fmt.Errorf("error unmarshalling idempotency record for %s: %w", key, err)
+		}
+		if record.Status == idempotencyCompleted {
+			return nil, errAlreadyCompleted
+		}
+		if record.LockExpiresAt > now.Unix() {
+			return nil, fmt.Errorf("%s: %w", key, ErrInProgress)
+		}
+
+		// The lock has expired, so the invocation holding it must have been killed
+		err = i.table.DynamoDBPutItemIfMatches(ctx, i.tableName, item, "lockExpiresAt", existing["lockExpiresAt"])
+	}
+
+	if errors.Is(err, ErrConditionFailed) {
+		return nil, fmt.Errorf("%s: %w", key, ErrInProgress)
+	} else if err != nil {
+		return nil, fmt.Errorf("error locking idempotency record for %s: %w", key, err)
+	}
+	return item["lockExpiresAt"], nil
+}
+
+func (i *Idempotency) itemKey(key IdempotencyKey) map[string]dynamodbTypes.AttributeValue {
+	return map[string]dynamodbTypes.AttributeValue{
+		idempotencyKeyAttribute: &dynamodbTypes.AttributeValueMemberS{Value: key.String()},
+	}
+}
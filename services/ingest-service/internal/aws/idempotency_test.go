@@ -0,0 +1,218 @@
+package aws_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws/awstest"
+)
+
+const idempotencyTable = "idempotency"
+
+func TestIdempotencyDo(t *testing.T) {
+	key := aws.IdempotencyKey{MessageID: "message", Stage: "stage", Attachment: "0"}
+	workErr := errors.New("work failed")
+
+	tests := []struct {
+		name        string
+		lockTimeout time.Duration
+		setup       func(t *testing.T, idempotency *aws.Idempotency)
+		workErr     error
+		expectRuns  int
+		expectErr   error
+	}{
+		{
+			name:        "First delivery runs",
+			lockTimeout: time.Minute,
+			expectRuns:  1,
+		},
+		{
+			name:        "Completed work is skipped",
+			lockTimeout: time.Minute,
+			setup: func(t *testing.T, idempotency *aws.Idempotency) {
+				if err := idempotency.Do(context.Background(), key, func(context.Context) error { return nil }); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:        "Failed work is retried",
+			lockTimeout: time.Minute,
+			setup: func(t *testing.T, idempotency *aws.Idempotency) {
+				if err := idempotency.Do(context.Background(), key, func(context.Context) error { return workErr }); !errors.Is(err, workErr) {
+					t.Fatalf("expected %v, got %v", workErr, err)
+				}
+			},
+			expectRuns: 1,
+		},
+		{
+			name:        "Locked work is in progress",
+			lockTimeout: time.Minute,
+			setup: func(t *testing.T, idempotency *aws.Idempotency) {
+				idempotency.Do(context.Background(), key, func(ctx context.Context) error {
+					// Leave the lock held by returning from within the nested call
+					err := idempotency.Do(ctx, key, func(context.Context) error { return nil })
+					if !errors.Is(err, aws.ErrInProgress) {
+						t.Errorf("expected %v, got %v", aws.ErrInProgress, err)
+					}
+					return nil
+				})
+			},
+		},
+		{
+			name:        "Work error is returned",
+			lockTimeout: time.Minute,
+			workErr:     workErr,
+			expectRuns:  1,
+			expectErr:   workErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := awstest.NewTable()
+			idempotency := aws.NewIdempotency(table, idempotencyTable)
+			idempotency.LockTimeout = tt.lockTimeout
+
+			if tt.setup != nil {
+				tt.setup(t, idempotency)
+			}
+
+			runs := 0
+			err := idempotency.Do(context.Background(), key, func(context.Context) error {
+				runs++
+				return tt.workErr
+			})
+			if !errors.Is(err, tt.expectErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectErr, err)
+			}
+			if runs != tt.expectRuns {
+				t.Errorf("expected %d runs, got %d", tt.expectRuns, runs)
+			}
+		})
+	}
+}
+
+func TestIdempotencyExpiredLock(t *testing.T) {
+	table := awstest.NewTable()
+	idempotency := aws.NewIdempotency(table, idempotencyTable)
+	key := aws.IdempotencyKey{MessageID: "message", Stage: "stage"}
+
+	// A negative timeout leaves the lock expired as soon as it is taken, as if the invocation
+	// holding it had been killed
+	idempotency.LockTimeout = -time.Minute
+	runs := 0
+	err := idempotency.Do(context.Background(), key, func(ctx context.Context) error {
+		return idempotency.Do(ctx, key, func(context.Context) error {
+			runs++
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs != 1 {
+		t.Errorf("expected expired lock to be taken over, got %d runs", runs)
+	}
+	if items := table.Items(idempotencyTable); len(items) != 1 {
+		t.Errorf("expected 1 idempotency record, got %d", len(items))
+	}
+}
+
+func TestIdempotencyCompletionFails(t *testing.T) {
+	tests := []struct {
+		name            string
+		failures        int
+		expectCompleted bool
+	}{
+		{name: "Completion is retried", failures: 2, expectCompleted: true},
+		{name: "Completion gives up", failures: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &failingPutTable{Table: awstest.NewTable(), failures: tt.failures}
+			idempotency := aws.NewIdempotency(table, idempotencyTable)
+			key := aws.IdempotencyKey{MessageID: "message", Stage: "stage"}
+
+			// The work has been done, so the message must not be redelivered to do it again
+			runs := 0
+			work := func(context.Context) error {
+				runs++
+				return nil
+			}
+			if err := idempotency.Do(context.Background(), key, work); err != nil {
+				t.Fatalf("Do() error = %v, expected nil once the work has succeeded", err)
+			}
+
+			// A completed record skips the work, and a lock left in place holds it off
+			err := idempotency.Do(context.Background(), key, work)
+			if tt.expectCompleted && err != nil {
+				t.Errorf("Do() error = %v after completing, expected the work to be skipped", err)
+			}
+			if !tt.expectCompleted && !errors.Is(err, aws.ErrInProgress) {
+				t.Errorf("Do() error = %v, expected %v while the lock is held", err, aws.ErrInProgress)
+			}
+			if runs != 1 {
+				t.Errorf("expected 1 run, got %d", runs)
+			}
+		})
+	}
+}
+
+func TestIdempotencyReleaseKeepsTakenOverLock(t *testing.T) {
+	table := awstest.NewTable()
+	idempotency := aws.NewIdempotency(table, idempotencyTable)
+	key := aws.IdempotencyKey{MessageID: "message", Stage: "stage"}
+	workErr := errors.New("work failed")
+
+	// The outer invocation's lock expires and another invocation takes it over and completes
+	// the work, so the outer invocation failing afterwards must not remove its record
+	idempotency.LockTimeout = -time.Minute
+	err := idempotency.Do(context.Background(), key, func(ctx context.Context) error {
+		if err := idempotency.Do(ctx, key, func(context.Context) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		return workErr
+	})
+	if !errors.Is(err, workErr) {
+		t.Fatalf("expected %v, got %v", workErr, err)
+	}
+
+	runs := 0
+	if err := idempotency.Do(context.Background(), key, func(context.Context) error {
+		runs++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 0 {
+		t.Errorf("expected the completed work to be skipped, got %d runs", runs)
+	}
+}
+
+// failingPutTable fails the first failures unconditional puts, which Idempotency only uses to
+// mark work completed.
+type failingPutTable struct {
+	*awstest.Table
+	failures int
+}
+
+func (t *failingPutTable) DynamoDBPutItem(ctx context.Context, tableName string, item *map[string]dynamodbTypes.AttributeValue) error {
+	if t.failures > 0 {
+		t.failures--
+		return errors.New("service unavailable")
+	}
+	return t.Table.DynamoDBPutItem(ctx, tableName, item)
+}
+
+func TestIdempotencyKeyString(t *testing.T) {
+	key := aws.IdempotencyKey{MessageID: "message", Stage: "parse-report", Attachment: "reports/a.xml.gz"}
+	if got, want := key.String(), "parse-report#message#reports/a.xml.gz"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,85 @@
+package aws
+
+import (
+	"context"
+	"io"
+	"time"
+
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ObjectStore stores and retrieves objects such as raw emails and extracted reports.  It is
+// satisfied by AWSClient, and by awstest.ObjectStore in tests.
+type ObjectStore interface {
+	S3ObjectExists(ctx context.Context, bucket, key string) (bool, error)
+	S3GetObject(ctx context.Context, bucket, key string) ([]byte, error)
+	S3GetObjectReader(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	S3PutObject(ctx context.Context, bucket, key string, opts S3ObjectOptions, body []byte) error
+	S3UploadObject(ctx context.Context, bucket, key string, opts S3ObjectOptions, body io.Reader) error
+	S3PutGzipObject(ctx context.Context, bucket, key string, opts S3ObjectOptions, body io.Reader) error
+	S3ListObjects(ctx context.Context, bucket, prefix string, fn func(key string) error) error
+	S3DeleteObject(ctx context.Context, bucket, key string) error
+}
+
+// Queue publishes messages to the next stage of the pipeline.  It is satisfied by AWSClient,
+// and by awstest.Queue in tests.
+type Queue interface {
+	SQSPublishMessage(ctx context.Context, queueURL, message string) error
+	SQSPublishMessageBatch(ctx context.Context, queueURL string, messages []SQSMessage) error
+}
+
+// DeadLetterQueue receives messages from a dead-letter queue so they can be inspected and
+// redriven.  It is satisfied by AWSClient, and by awstest.Queue in tests.
+type DeadLetterQueue interface {
+	SQSDeadLetterQueueURL(ctx context.Context, queueURL string) (string, error)
+	SQSReceiveMessages(ctx context.Context, queueURL string, maxMessages int, visibilityTimeout time.Duration) ([]SQSReceivedMessage, error)
+	SQSDeleteMessage(ctx context.Context, queueURL, receiptHandle string) error
+}
+
+// Table stores items in a DynamoDB table.  It is satisfied by AWSClient, and by awstest.Table
+// in tests.
+type Table interface {
+	DynamoDBGetItem(ctx context.Context, tableName string, key map[string]dynamodbTypes.AttributeValue) (map[string]dynamodbTypes.AttributeValue, error)
+	DynamoDBPutItem(ctx context.Context, tableName string, item *map[string]dynamodbTypes.AttributeValue) error
+	DynamoDBPutItemIfNotExists(ctx context.Context, tableName string, keyAttribute string, item map[string]dynamodbTypes.AttributeValue) error
+	DynamoDBPutItemIfMatches(ctx context.Context, tableName string, item map[string]dynamodbTypes.AttributeValue, attribute string, expected dynamodbTypes.AttributeValue) error
+	DynamoDBDeleteItem(ctx context.Context, tableName string, key map[string]dynamodbTypes.AttributeValue) error
+	DynamoDBDeleteItemIfMatches(ctx context.Context, tableName string, key map[string]dynamodbTypes.AttributeValue, attribute string, expected dynamodbTypes.AttributeValue) error
+	DynamoDBPutBatchItems(ctx context.Context, tableName string, items []map[string]dynamodbTypes.AttributeValue) error
+}
+
+// TableReader reads items from a DynamoDB table and its indexes.  It is satisfied by
+// AWSClient.
+type TableReader interface {
+	DynamoDBGetItem(ctx context.Context, tableName string, key map[string]dynamodbTypes.AttributeValue) (map[string]dynamodbTypes.AttributeValue, error)
+	DynamoDBQuery(ctx context.Context, input QueryInput) ([]map[string]dynamodbTypes.AttributeValue, map[string]dynamodbTypes.AttributeValue, error)
+}
+
+// TableScanner reads every item in a DynamoDB table, such as for a migration.  It is satisfied
+// by AWSClient, and by awstest.Table in tests.
+type TableScanner interface {
+	DynamoDBScan(ctx context.Context, tableName string, fn func(item map[string]dynamodbTypes.AttributeValue) error) error
+}
+
+// TableCounter updates counters of items in a DynamoDB table, such as rollups of reports.  It
+// is satisfied by AWSClient, and by awstest.Table in tests.
+type TableCounter interface {
+	DynamoDBAddCounters(ctx context.Context, tableName string, update CounterUpdate) error
+}
+
+// EventPublisher publishes domain events for other parts of the system to react to.  It is
+// satisfied by AWSClient, and by awstest.EventBus in tests.
+type EventPublisher interface {
+	EventBridgePublishEvents(ctx context.Context, busName string, events ...Event) error
+}
+
+var (
+	_ ObjectStore     = (*AWSClient)(nil)
+	_ Queue           = (*AWSClient)(nil)
+	_ DeadLetterQueue = (*AWSClient)(nil)
+	_ Table           = (*AWSClient)(nil)
+	_ TableReader     = (*AWSClient)(nil)
+	_ TableScanner    = (*AWSClient)(nil)
+	_ TableCounter    = (*AWSClient)(nil)
+	_ EventPublisher  = (*AWSClient)(nil)
+)
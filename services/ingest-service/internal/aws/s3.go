@@ -2,11 +2,14 @@ package aws
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
@@ -27,6 +30,13 @@ func (c *AWSClient) S3ObjectExists(ctx context.Context, bucket, key string) (boo
 	return true, nil
 }
 
+// IsObjectNotFound reports whether err was returned because an S3 object does not exist.
+func IsObjectNotFound(err error) bool {
+	var noSuchKey *s3Types.NoSuchKey
+	var notFound *s3Types.NotFound
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound)
+}
+
 // S3GetObject retrieves an object from an S3 bucket. The object is returned as a byte slice.
 func (c *AWSClient) S3GetObject(ctx context.Context, bucket, key string) ([]byte, error) {
 	obj, err := c.S3.GetObject(ctx, &s3.GetObjectInput{
@@ -41,15 +51,199 @@ func (c *AWSClient) S3GetObject(ctx context.Context, bucket, key string) ([]byte
 	return io.ReadAll(obj.Body)
 }
 
+// S3GetObjectReader retrieves an object from an S3 bucket as a stream, so it can be processed
+// without holding the whole object in memory.  The caller must close the returned reader.
+func (c *AWSClient) S3GetObjectReader(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	obj, err := c.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting object from S3: %w", err)
+	}
+
+	return obj.Body, nil
+}
+
+// Object tag keys applied to stored objects, for lifecycle rules, cost allocation and
+// per-tenant purges.
+const (
+	TagTenantID  = "tenant-id"
+	TagMessageID = "message-id"
+	TagStage     = "stage"
+)
+
+// S3ObjectOptions are the optional properties of an object stored in S3.
+type S3ObjectOptions struct {
+	ContentType     string
+	ContentEncoding string
+
+	// Metadata is stored as x-amz-meta-* user metadata, and must be US-ASCII
+	Metadata map[string]string
+
+	// Tags are stored as object tags, which can drive lifecycle rules and IAM conditions
+	Tags map[string]string
+}
+
+// apply sets the options on a PutObject request.
+func (o S3ObjectOptions) apply(input *s3.PutObjectInput) {
+	if o.ContentType != "" {
+		input.ContentType = &o.ContentType
+	}
+	if o.ContentEncoding != "" {
+		input.ContentEncoding = &o.ContentEncoding
+	}
+	if len(o.Metadata) > 0 {
+		input.Metadata = o.Metadata
+	}
+	if len(o.Tags) > 0 {
+		tags := url.Values{}
+		for key, value := range o.Tags {
+			tags.Set(key, value)
+		}
+		tagging := tags.Encode()
+		input.Tagging = &tagging
+	}
+}
+
+// S3GetObjectOptions retrieves the content type, metadata and tags of an object in an S3
+// bucket, so the object can be rewritten without losing them.
+func (c *AWSClient) S3GetObjectOptions(ctx context.Context, bucket, key string) (S3ObjectOptions, error) {
+	head, err := c.S3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return S3ObjectOptions{}, fmt.Errorf("error getting object from S3: %w", err)
+	}
+
+	tagging, err := c.S3.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return S3ObjectOptions{}, fmt.Errorf("error getting object tags from S3: %w", err)
+	}
+
+	return objectOptions(head, tagging.TagSet), nil
+}
+
+// objectOptions returns the options of an object from its HeadObject response and tags.
+func objectOptions(head *s3.HeadObjectOutput, tagSet []s3Types.Tag) S3ObjectOptions {
+	var opts S3ObjectOptions
+	if head.ContentType != nil {
+		opts.ContentType = *head.ContentType
+	}
+	if head.ContentEncoding != nil {
+		opts.ContentEncoding = *head.ContentEncoding
+	}
+	if len(head.Metadata) > 0 {
+		opts.Metadata = head.Metadata
+	}
+	if len(tagSet) > 0 {
+		opts.Tags = make(map[string]string, len(tagSet))
+		for _, tag := range tagSet {
+			opts.Tags[*tag.Key] = *tag.Value
+		}
+	}
+	return opts
+}
+
 // S3PutObject puts a single object into an S3 bucket.
-func (c *AWSClient) S3PutObject(ctx context.Context, bucket, key string, contentType string, body []byte) error {
-	_, err := c.S3.PutObject(ctx, &s3.PutObjectInput{
+func (c *AWSClient) S3PutObject(ctx context.Context, bucket, key string, opts S3ObjectOptions, body []byte) error {
+	input := &s3.PutObjectInput{
 		Bucket: &bucket,
 		Key:    &key,
 		Body:   bytes.NewReader(body),
-	})
+	}
+	opts.apply(input)
+
+	_, err := c.S3.PutObject(ctx, input)
 	if err != nil {
 		return fmt.Errorf("error putting object to S3: %w", err)
 	}
 	return nil
 }
+
+// S3UploadObject streams an object of unknown length into an S3 bucket.  The body is sent
+// as a multipart upload, so memory use is bounded by the part size rather than the size of
+// the object.
+func (c *AWSClient) S3UploadObject(ctx context.Context, bucket, key string, opts S3ObjectOptions, body io.Reader) error {
+	input := &s3.PutObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+		Body:   body,
+	}
+	opts.apply(input)
+
+	uploader := manager.NewUploader(c.S3)
+	_, err := uploader.Upload(ctx, input)
+	if err != nil {
+		return fmt.Errorf("error uploading object to S3: %w", err)
+	}
+	return nil
+}
+
+// S3PutGzipObject gzip-compresses the body as it is streamed into an S3 bucket, storing the
+// object with a Content-Encoding of gzip in place of any given in the options.
+func (c *AWSClient) S3PutGzipObject(ctx context.Context, bucket, key string, opts S3ObjectOptions, body io.Reader) error {
+	pr, pw := io.Pipe()
+	// Closing the reader unblocks the compressing goroutine if the upload fails early
+	defer pr.Close()
+
+	go func() {
+		gzipWriter := gzip.NewWriter(pw)
+		_, err := io.Copy(gzipWriter, body)
+		if closeErr := gzipWriter.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	input := &s3.PutObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+		Body:   pr,
+	}
+	opts.ContentEncoding = "gzip"
+	opts.apply(input)
+
+	uploader := manager.NewUploader(c.S3)
+	_, err := uploader.Upload(ctx, input)
+	if err != nil {
+		return fmt.Errorf("error uploading compressed object to S3: %w", err)
+	}
+	return nil
+}
+
+// S3ListObjects calls fn with the key of every object in an S3 bucket under the given prefix.
+func (c *AWSClient) S3ListObjects(ctx context.Context, bucket, prefix string, fn func(key string) error) error {
+	paginator := s3.NewListObjectsV2Paginator(c.S3, &s3.ListObjectsV2Input{
+		Bucket: &bucket,
+		Prefix: &prefix,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing objects in S3: %w", err)
+		}
+		for _, obj := range page.Contents {
+			if err := fn(*obj.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// S3DeleteObject deletes a single object from an S3 bucket.
+func (c *AWSClient) S3DeleteObject(ctx context.Context, bucket, key string) error {
+	_, err := c.S3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting object from S3: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package aws
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestS3ObjectOptionsApply(t *testing.T) {
+	var input s3.PutObjectInput
+	S3ObjectOptions{
+		ContentType:     "application/xml",
+		ContentEncoding: "gzip",
+		Metadata:        map[string]string{"attachment-format": "zip"},
+		Tags:            map[string]string{TagTenantID: "tenant a", TagMessageID: "abc&123"},
+	}.apply(&input)
+
+	if input.ContentType == nil || *input.ContentType != "application/xml" {
+		t.Errorf("ContentType = %v, expected application/xml", input.ContentType)
+	}
+	if input.ContentEncoding == nil || *input.ContentEncoding != "gzip" {
+		t.Errorf("ContentEncoding = %v, expected gzip", input.ContentEncoding)
+	}
+	if input.Metadata["attachment-format"] != "zip" {
+		t.Errorf("Metadata = %v, expected attachment-format=zip", input.Metadata)
+	}
+
+	if input.Tagging == nil {
+		t.Fatalf("Tagging is not set")
+	}
+	tags, err := url.ParseQuery(*input.Tagging)
+	if err != nil {
+		t.Fatalf("error parsing Tagging %q: %v", *input.Tagging, err)
+	}
+	if tags.Get(TagTenantID) != "tenant a" || tags.Get(TagMessageID) != "abc&123" {
+		t.Errorf("Tagging = %q, expected the tags to be URL encoded", *input.Tagging)
+	}
+}
+
+func TestS3ObjectOptionsApplyEmpty(t *testing.T) {
+	var input s3.PutObjectInput
+	S3ObjectOptions{}.apply(&input)
+
+	if input.ContentType != nil || input.ContentEncoding != nil || input.Metadata != nil || input.Tagging != nil {
+		t.Errorf("expected no properties to be set, got %+v", input)
+	}
+}
+
+func TestObjectOptions(t *testing.T) {
+	contentType := "application/xml"
+	head := &s3.HeadObjectOutput{
+		ContentType: &contentType,
+		Metadata:    map[string]string{"attachment-format": "zip"},
+	}
+	tenantKey, tenantValue := TagTenantID, "tenant-a"
+	stageKey, stageValue := TagStage, "extract-attachment"
+	tagSet := []s3Types.Tag{{Key: &tenantKey, Value: &tenantValue}, {Key: &stageKey, Value: &stageValue}}
+
+	expected := S3ObjectOptions{
+		ContentType: "application/xml",
+		Metadata:    map[string]string{"attachment-format": "zip"},
+		Tags:        map[string]string{TagTenantID: "tenant-a", TagStage: "extract-attachment"},
+	}
+	if opts := objectOptions(head, tagSet); !reflect.DeepEqual(opts, expected) {
+		t.Errorf("objectOptions() = %+v, expected %+v", opts, expected)
+	}
+
+	// Round-tripping an object with no properties sets none on the rewritten object
+	var input s3.PutObjectInput
+	objectOptions(&s3.HeadObjectOutput{}, nil).apply(&input)
+	if input.ContentType != nil || input.ContentEncoding != nil || input.Metadata != nil || input.Tagging != nil {
+		t.Errorf("expected no properties to be set, got %+v", input)
+	}
+}
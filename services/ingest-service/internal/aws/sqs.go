@@ -4,13 +4,76 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
 
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// MessageTypeAttribute is the message attribute naming the type of the message body
+	MessageTypeAttribute = "MessageType"
+
+	// TraceContextAttribute is the message attribute carrying the X-Ray trace header of the
+	// invocation that published the message
+	TraceContextAttribute = "TraceContext"
 )
 
+// SQS limits a batch to 10 entries, and the total size of a batch to 256 KiB
+const (
+	sqsMaxBatchEntries = 10
+	sqsMaxBatchBytes   = 256 * 1024
+)
+
+// sqsBatchBackoff retries entries which failed through no fault of the sender.
+var sqsBatchBackoff = backoff{base: 50 * time.Millisecond, max: 2 * time.Second, attempts: 5}
+
+// SQSMessage is a message to be published with SQSPublishMessageBatch.
+type SQSMessage struct {
+	Body string
+
+	// Attributes are string message attributes, such as MessageTypeAttribute
+	Attributes map[string]string
+}
+
+// size returns the size of the message as SQS counts it towards the batch size limit.
+func (m SQSMessage) size() int {
+	n := len(m.Body)
+	for name, value := range m.Attributes {
+		n += len(name) + len("String") + len(value)
+	}
+	return n
+}
+
+// SQSBatchFailure is a message which could not be published.
+type SQSBatchFailure struct {
+	Message     SQSMessage
+	Code        string
+	Reason      string
+	SenderFault bool
+}
+
+// SQSBatchError is returned when messages could not be published, either because SQS
+// rejected them or because they still failed after every retry.
+type SQSBatchError struct {
+	QueueURL string
+	Failed   []SQSBatchFailure
+}
+
+// Error returns the error message.
+func (e *SQSBatchError) Error() string {
+	return fmt.Sprintf("%d messages could not be sent to SQS queue %s (first failure: %s: %s)", len(e.Failed), e.QueueURL, e.Failed[0].Code, e.Failed[0].Reason)
+}
+
 // SQSPublishMessage sends a message to an SQS queue.
 func (c *AWSClient) SQSPublishMessage(ctx context.Context, queueURL, message string) error {
-	msg, err := c.SQS.SendMessage(ctx, &sqs.SendMessageInput{
+	_, err := c.SQS.SendMessage(ctx, &sqs.SendMessageInput{
 		MessageBody: &message,
 		QueueUrl:    &queueURL,
 	})
@@ -18,10 +81,284 @@ func (c *AWSClient) SQSPublishMessage(ctx context.Context, queueURL, message str
 		return fmt.Errorf("error sending message to SQS queue %s: %w", queueURL, err)
 	}
 
-	fmt.Printf("Message sent with ID %s\n", *msg.MessageId)
 	return nil
 }
 
+// SQSPublishMessageBatch sends messages to an SQS queue in as few batches as the SQS entry
+// count and size limits allow.  Entries which fail through no fault of the sender are retried
+// with backoff; any that could not be sent are returned in an SQSBatchError.
+func (c *AWSClient) SQSPublishMessageBatch(ctx context.Context, queueURL string, messages []SQSMessage) error {
+	batches, err := splitSQSBatches(messages)
+	if err != nil {
+		return fmt.Errorf("error sending message batch to SQS queue %s: %w", queueURL, err)
+	}
+
+	send := func(ctx context.Context, entries []sqsTypes.SendMessageBatchRequestEntry) ([]sqsTypes.BatchResultErrorEntry, error) {
+		output, err := c.SQS.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: &queueURL,
+			Entries:  entries,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return output.Failed, nil
+	}
+
+	var failed []SQSBatchFailure
+	for _, batch := range batches {
+		batchFailed, err := sendBatch(ctx, batch, sqsBatchBackoff, send)
+		if err != nil {
+			return fmt.Errorf("error sending message batch to SQS queue %s: %w", queueURL, err)
+		}
+		failed = append(failed, batchFailed...)
+	}
+
+	if len(failed) > 0 {
+		return &SQSBatchError{QueueURL: queueURL, Failed: failed}
+	}
+	return nil
+}
+
+// splitSQSBatches splits messages into batches within the SQS entry count and size limits.
+func splitSQSBatches(messages []SQSMessage) ([][]SQSMessage, error) {
+	var batches [][]SQSMessage
+	var batch []SQSMessage
+	batchSize := 0
+	for i, message := range messages {
+		size := message.size()
+		if size > sqsMaxBatchBytes {
+			return nil, fmt.Errorf("message %d is %d bytes, more than the maximum of %d", i, size, sqsMaxBatchBytes)
+		}
+
+		if len(batch) == sqsMaxBatchEntries || batchSize+size > sqsMaxBatchBytes {
+			batches = append(batches, batch)
+			batch, batchSize = nil, 0
+		}
+		batch = append(batch, message)
+		batchSize += size
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches, nil
+}
+
+// sendBatch sends a single batch of messages, retrying entries which fail through no fault
+// of the sender according to the backoff policy.  It returns the messages which could not be
+// sent.
+func sendBatch(ctx context.Context, messages []SQSMessage, b backoff, send func(context.Context, []sqsTypes.SendMessageBatchRequestEntry) ([]sqsTypes.BatchResultErrorEntry, error)) ([]SQSBatchFailure, error) {
+	entries := make([]sqsTypes.SendMessageBatchRequestEntry, len(messages))
+	for i, message := range messages {
+		entries[i] = sqsBatchEntry(strconv.Itoa(i), message)
+	}
+
+	var rejected []SQSBatchFailure
+	for attempt := 0; ; attempt++ {
+		failedEntries, err := send(ctx, entries)
+		if err != nil {
+			return nil, err
+		}
+
+		var retry []sqsTypes.SendMessageBatchRequestEntry
+		var retryFailures []SQSBatchFailure
+		for _, failedEntry := range failedEntries {
+			i, err := strconv.Atoi(stringValue(failedEntry.Id))
+			if err != nil || i < 0 || i >= len(messages) {
+				return nil, fmt.Errorf("unexpected failed entry ID %q", stringValue(failedEntry.Id))
+			}
+
+			failure := SQSBatchFailure{
+				Message:     messages[i],
+				Code:        stringValue(failedEntry.Code),
+				Reason:      stringValue(failedEntry.Message),
+				SenderFault: failedEntry.SenderFault,
+			}
+			if failedEntry.SenderFault {
+				// The entry is invalid, so retrying it will never succeed
+				rejected = append(rejected, failure)
+				continue
+			}
+			retry = append(retry, sqsBatchEntry(strconv.Itoa(i), messages[i]))
+			retryFailures = append(retryFailures, failure)
+		}
+
+		if len(retry) == 0 {
+			return rejected, nil
+		}
+		if !b.wait(ctx, attempt) {
+			return append(rejected, retryFailures...), nil
+		}
+		entries = retry
+	}
+}
+
+func sqsBatchEntry(id string, message SQSMessage) sqsTypes.SendMessageBatchRequestEntry {
+	entry := sqsTypes.SendMessageBatchRequestEntry{
+		Id:          &id,
+		MessageBody: &message.Body,
+	}
+	if len(message.Attributes) > 0 {
+		entry.MessageAttributes = make(map[string]sqsTypes.MessageAttributeValue, len(message.Attributes))
+		for name, value := range message.Attributes {
+			entry.MessageAttributes[name] = sqsTypes.MessageAttributeValue{
+				DataType:    stringPtr("String"),
+				StringValue: stringPtr(value),
+			}
+		}
+	}
+	return entry
+}
+
+// SQSReceivedMessage is a message received from an SQS queue with SQSReceiveMessages.
+type SQSReceivedMessage struct {
+	SQSMessage
+
+	// MessageID is the ID SQS assigned to the message when it was sent.  It is kept when the
+	// message is moved to a dead-letter queue.
+	MessageID string
+
+	// ReceiptHandle identifies this receipt of the message, for deleting it
+	ReceiptHandle string
+
+	// ReceiveCount is the approximate number of times the message has been received,
+	// including the receives from the source queue of a dead-letter queue
+	ReceiveCount int
+}
+
+// sqsReceiveWaitTime is how long SQSReceiveMessages waits for messages to arrive.  Waiting
+// queries every SQS server, so an empty response means the queue has no visible messages.
+const sqsReceiveWaitTime = 2
+
+// SQSReceiveMessages receives up to maxMessages messages from an SQS queue, hiding them from
+// other consumers for the visibility timeout.  It returns no messages once the queue has no
+// visible messages left.
+func (c *AWSClient) SQSReceiveMessages(ctx context.Context, queueURL string, maxMessages int, visibilityTimeout time.Duration) ([]SQSReceivedMessage, error) {
+	output, err := c.SQS.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:              &queueURL,
+		MaxNumberOfMessages:   int32(min(maxMessages, sqsMaxBatchEntries)),
+		VisibilityTimeout:     int32(visibilityTimeout.Seconds()),
+		WaitTimeSeconds:       sqsReceiveWaitTime,
+		MessageAttributeNames: []string{"All"},
+		MessageSystemAttributeNames: []sqsTypes.MessageSystemAttributeName{
+			sqsTypes.MessageSystemAttributeNameApproximateReceiveCount,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error receiving messages from SQS queue %s: %w", queueURL, err)
+	}
+
+	messages := make([]SQSReceivedMessage, len(output.Messages))
+	for i, message := range output.Messages {
+		messages[i] = SQSReceivedMessage{
+			SQSMessage:    SQSMessage{Body: stringValue(message.Body)},
+			MessageID:     stringValue(message.MessageId),
+			ReceiptHandle: stringValue(message.ReceiptHandle),
+		}
+		if count, err := strconv.Atoi(message.Attributes[string(sqsTypes.MessageSystemAttributeNameApproximateReceiveCount)]); err == nil {
+			messages[i].ReceiveCount = count
+		}
+		for name, value := range message.MessageAttributes {
+			if value.StringValue == nil {
+				continue
+			}
+			if messages[i].Attributes == nil {
+				messages[i].Attributes = map[string]string{}
+			}
+			messages[i].Attributes[name] = *value.StringValue
+		}
+	}
+	return messages, nil
+}
+
+// SQSDeleteMessage deletes a received message from an SQS queue.
+func (c *AWSClient) SQSDeleteMessage(ctx context.Context, queueURL, receiptHandle string) error {
+	_, err := c.SQS.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      &queueURL,
+		ReceiptHandle: &receiptHandle,
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting message from SQS queue %s: %w", queueURL, err)
+	}
+	return nil
+}
+
+// SQSDeadLetterQueueURL returns the URL of the dead-letter queue named in the redrive policy
+// of an SQS queue.
+func (c *AWSClient) SQSDeadLetterQueueURL(ctx context.Context, queueURL string) (string, error) {
+	output, err := c.SQS.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       &queueURL,
+		AttributeNames: []sqsTypes.QueueAttributeName{sqsTypes.QueueAttributeNameRedrivePolicy},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error getting redrive policy of SQS queue %s: %w", queueURL, err)
+	}
+
+	name, account, err := parseRedrivePolicy(output.Attributes[string(sqsTypes.QueueAttributeNameRedrivePolicy)])
+	if err != nil {
+		return "", fmt.Errorf("error getting dead-letter queue of SQS queue %s: %w", queueURL, err)
+	}
+
+	urlOutput, err := c.SQS.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName:              &name,
+		QueueOwnerAWSAccountId: &account,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error getting URL of SQS queue %s: %w", name, err)
+	}
+	return stringValue(urlOutput.QueueUrl), nil
+}
+
+// parseRedrivePolicy returns the name and owning account of the dead-letter queue in an SQS
+// redrive policy.
+func parseRedrivePolicy(policy string) (string, string, error) {
+	if policy == "" {
+		return "", "", fmt.Errorf("queue has no redrive policy")
+	}
+
+	var redrivePolicy struct {
+		DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+	}
+	if err := json.Unmarshal([]byte(policy), &redrivePolicy); err != nil {
+		return "", "", fmt.Errorf("error unmarshalling redrive policy: %w", err)
+	}
+
+	// arn:aws:sqs:<region>:<account>:<name>
+	parts := strings.Split(redrivePolicy.DeadLetterTargetArn, ":")
+	if len(parts) != 6 || parts[2] != "sqs" {
+		return "", "", fmt.Errorf("invalid dead-letter queue ARN %q", redrivePolicy.DeadLetterTargetArn)
+	}
+	return parts[5], parts[4], nil
+}
+
+// TraceContext returns the X-Ray trace header of the current Lambda invocation, for
+// propagating to downstream stages in the TraceContextAttribute.
+func TraceContext(ctx context.Context) string {
+	if traceID, ok := ctx.Value("x-amzn-trace-id").(string); ok && traceID != "" {
+		return traceID
+	}
+	return os.Getenv("_X_AMZN_TRACE_ID")
+}
+
+// ReceiveCount returns the number of times an SQS record has been received, counting the
+// current delivery, or 1 if the attribute is missing.
+func ReceiveCount(record events.SQSMessage) int {
+	if count, err := strconv.Atoi(record.Attributes[string(sqsTypes.MessageSystemAttributeNameApproximateReceiveCount)]); err == nil {
+		return count
+	}
+	return 1
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
 // ParseSQSMessage parses an SQS message into a given struct.
 func ParseSQSMessage(body string, v interface{}) error {
 	if err := json.Unmarshal([]byte(body), v); err != nil {
@@ -29,3 +366,19 @@ func ParseSQSMessage(body string, v interface{}) error {
 	}
 	return nil
 }
+
+// ProcessSQSRecords calls fn for every record in the SQS event, returning a response that
+// lists the records which failed.  With ReportBatchItemFailures enabled on the event source
+// mapping, only the failed records are returned to the queue to be retried.
+func ProcessSQSRecords(ctx context.Context, sqsEvent events.SQSEvent, fn func(context.Context, events.SQSMessage) error) events.SQSEventResponse {
+	var response events.SQSEventResponse
+	for _, record := range sqsEvent.Records {
+		if err := fn(ctx, record); err != nil {
+			log.Printf("Error processing SQS message with MessageID %s: %v", record.MessageId, err)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
+		}
+	}
+	return response
+}
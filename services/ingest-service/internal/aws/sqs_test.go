@@ -0,0 +1,205 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestProcessSQSRecords(t *testing.T) {
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "1", Body: "ok"},
+		{MessageId: "2", Body: "fail"},
+		{MessageId: "3", Body: "ok"},
+		{MessageId: "4", Body: "fail"},
+	}}
+
+	var processed []string
+	response := ProcessSQSRecords(context.Background(), event, func(ctx context.Context, record events.SQSMessage) error {
+		processed = append(processed, record.MessageId)
+		if record.Body == "fail" {
+			return errors.New("processing failed")
+		}
+		return nil
+	})
+
+	if len(processed) != 4 {
+		t.Errorf("processed %v, expected every record to be processed", processed)
+	}
+	if len(response.BatchItemFailures) != 2 ||
+		response.BatchItemFailures[0].ItemIdentifier != "2" || response.BatchItemFailures[1].ItemIdentifier != "4" {
+		t.Errorf("BatchItemFailures = %v, expected messages 2 and 4", response.BatchItemFailures)
+	}
+}
+
+func TestSplitSQSBatches(t *testing.T) {
+	messages := func(n, size int) []SQSMessage {
+		m := make([]SQSMessage, n)
+		for i := range m {
+			m[i] = SQSMessage{Body: strings.Repeat("x", size)}
+		}
+		return m
+	}
+
+	tests := []struct {
+		name      string
+		messages  []SQSMessage
+		expected  []int
+		expectErr bool
+	}{
+		{
+			name:     "Single batch",
+			messages: messages(3, 100),
+			expected: []int{3},
+		},
+		{
+			name:     "Split by entry count",
+			messages: messages(23, 100),
+			expected: []int{10, 10, 3},
+		},
+		{
+			name:     "Split by size",
+			messages: messages(4, 100*1024),
+			expected: []int{2, 2},
+		},
+		{
+			name: "Attributes count towards size",
+			messages: []SQSMessage{
+				{Body: strings.Repeat("x", 128*1024)},
+				{Body: strings.Repeat("x", 128*1024-10), Attributes: map[string]string{"MessageType": "Report"}},
+			},
+			expected: []int{1, 1},
+		},
+		{
+			name:      "Message too large",
+			messages:  messages(1, 257*1024),
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches, err := splitSQSBatches(tt.messages)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("splitSQSBatches() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if len(batches) != len(tt.expected) {
+				t.Fatalf("splitSQSBatches() returned %d batches, expected %d", len(batches), len(tt.expected))
+			}
+			for i, batch := range batches {
+				if len(batch) != tt.expected[i] {
+					t.Errorf("batch %d has %d messages, expected %d", i, len(batch), tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	testBackoff := backoff{base: time.Millisecond, max: 5 * time.Millisecond, attempts: 3}
+	failure := func(id string, senderFault bool) sqsTypes.BatchResultErrorEntry {
+		return sqsTypes.BatchResultErrorEntry{Id: &id, Code: stringPtr("InternalError"), SenderFault: senderFault}
+	}
+
+	tests := []struct {
+		name     string
+		failures [][]sqsTypes.BatchResultErrorEntry
+		calls    int
+		failed   []string
+	}{
+		{
+			name:  "All sent",
+			calls: 1,
+		},
+		{
+			name:     "Failed entries retried",
+			failures: [][]sqsTypes.BatchResultErrorEntry{{failure("1", false), failure("2", false)}, {failure("2", false)}},
+			calls:    3,
+		},
+		{
+			name:     "Sender fault not retried",
+			failures: [][]sqsTypes.BatchResultErrorEntry{{failure("0", true), failure("1", false)}},
+			calls:    2,
+			failed:   []string{"a"},
+		},
+		{
+			name:     "Attempts exhausted",
+			failures: [][]sqsTypes.BatchResultErrorEntry{{failure("2", false)}, {failure("2", false)}, {failure("2", false)}},
+			calls:    3,
+			failed:   []string{"c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			send := func(ctx context.Context, entries []sqsTypes.SendMessageBatchRequestEntry) ([]sqsTypes.BatchResultErrorEntry, error) {
+				calls++
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1], nil
+				}
+				return nil, nil
+			}
+
+			failed, err := sendBatch(context.Background(), []SQSMessage{{Body: "a"}, {Body: "b"}, {Body: "c"}}, testBackoff, send)
+			if err != nil {
+				t.Fatalf("sendBatch() error = %v", err)
+			}
+			if calls != tt.calls {
+				t.Errorf("sendBatch() made %d calls, expected %d", calls, tt.calls)
+			}
+			if len(failed) != len(tt.failed) {
+				t.Fatalf("sendBatch() failed %v, expected %v", failed, tt.failed)
+			}
+			for i, f := range failed {
+				if f.Message.Body != tt.failed[i] {
+					t.Errorf("failed message %d = %s, expected %s", i, f.Message.Body, tt.failed[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseRedrivePolicy(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          string
+		expectedName    string
+		expectedAccount string
+		expectErr       bool
+	}{
+		{
+			name:            "Valid policy",
+			policy:          `{"deadLetterTargetArn":"arn:aws:sqs:eu-west-2:123456789012:ParseReportQueueDLQ","maxReceiveCount":3}`,
+			expectedName:    "ParseReportQueueDLQ",
+			expectedAccount: "123456789012",
+		},
+		{
+			name:      "No redrive policy",
+			policy:    "",
+			expectErr: true,
+		},
+		{
+			name:      "Invalid ARN",
+			policy:    `{"deadLetterTargetArn":"ParseReportQueueDLQ"}`,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, account, err := parseRedrivePolicy(tt.policy)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("parseRedrivePolicy() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if name != tt.expectedName || account != tt.expectedAccount {
+				t.Errorf("parseRedrivePolicy() = %s, %s, expected %s, %s", name, account, tt.expectedName, tt.expectedAccount)
+			}
+		})
+	}
+}
@@ -6,47 +6,183 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
+	"mime"
+	"strings"
 )
 
-// Decompress decompresses the given data based on the MIME type, returning the uncompressed byte slice.
-func Decompress(data []byte, mimeType string) ([]byte, error) {
-	switch mimeType {
-	case "application/gzip":
-		return decompressGzip(data)
-	case "application/zip":
-		return decompressZip(data)
+// Format is the detected format of an attachment.
+type Format string
+
+const (
+	FormatXML  Format = "xml"
+	FormatGzip Format = "gzip"
+	FormatZip  Format = "zip"
+	FormatTar  Format = "tar"
+)
+
+var (
+	gzipMagic     = []byte{0x1f, 0x8b}
+	zipMagic      = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+	utf8BOM       = []byte("\xef\xbb\xbf")
+
+	// tarMagic is found at tarMagicOffset in both POSIX and GNU tar headers
+	tarMagic = []byte("ustar")
+)
+
+const tarMagicOffset = 257
+
+// DetectFormat detects the format of the data from its magic bytes, falling back to the
+// filename extension and finally the declared MIME type.  Reporters routinely send reports
+// with generic or incorrect content types, so the declared type is trusted last.
+func DetectFormat(data []byte, filename string, mimeType string) (Format, error) {
+	if format, ok := detectFromContent(data); ok {
+		return format, nil
+	}
+	if format, ok := detectFromFilename(filename); ok {
+		return format, nil
+	}
+	if format, ok := detectFromMimeType(mimeType); ok {
+		return format, nil
+	}
+
+	return "", fmt.Errorf("unable to detect format of %q with MIME type %s", filename, mimeType)
+}
+
+// Decompress decompresses a single layer of gzip or zip compression, returning the uncompressed
+// byte slice.  Only the first file of a zip archive is returned; use Extract to find every
+// report in an attachment.  A LimitError is returned if decompression would exceed any of the
+// limits.
+func Decompress(data []byte, format Format, limits Limits) ([]byte, error) {
+	return decompress(data, format, limits, 1)
+}
+
+func decompress(data []byte, format Format, limits Limits, depth int) ([]byte, error) {
+	if err := limits.checkDepth(depth); err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatXML:
+		return data, nil
+	case FormatGzip:
+		return decompressGzip(data, limits)
+	case FormatZip:
+		return decompressZip(data, limits)
 	default:
-		return nil, fmt.Errorf("unsupported MIME type: %s", mimeType)
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+func detectFromContent(data []byte) (Format, bool) {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		return FormatGzip, true
+	case bytes.HasPrefix(data, zipMagic), bytes.HasPrefix(data, zipEmptyMagic):
+		return FormatZip, true
+	case len(data) > tarMagicOffset+len(tarMagic) && bytes.Equal(data[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return FormatTar, true
+	}
+
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(data, utf8BOM), " \t\r\n")
+	if bytes.HasPrefix(trimmed, []byte("<")) {
+		return FormatXML, true
 	}
+
+	return "", false
 }
 
-func decompressGzip(data []byte) ([]byte, error) {
+func detectFromFilename(filename string) (Format, bool) {
+	filename = strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(filename, ".gz"), strings.HasSuffix(filename, ".tgz"):
+		return FormatGzip, true
+	case strings.HasSuffix(filename, ".tar"):
+		return FormatTar, true
+	case strings.HasSuffix(filename, ".zip"):
+		return FormatZip, true
+	case strings.HasSuffix(filename, ".xml"):
+		return FormatXML, true
+	default:
+		return "", false
+	}
+}
+
+func detectFromMimeType(mimeType string) (Format, bool) {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return "", false
+	}
+
+	switch mediaType {
+	case "application/gzip", "application/x-gzip":
+		return FormatGzip, true
+	case "application/zip", "application/x-zip-compressed":
+		return FormatZip, true
+	case "application/x-tar":
+		return FormatTar, true
+	case "text/xml", "application/xml":
+		return FormatXML, true
+	default:
+		return "", false
+	}
+}
+
+func decompressGzip(data []byte, limits Limits) ([]byte, error) {
 	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("error creating gzip reader: %w", err)
 	}
 	defer gzipReader.Close()
 
-	return io.ReadAll(gzipReader)
+	return io.ReadAll(newLimitedReader(gzipReader, int64(len(data)), limits))
 }
 
-func decompressZip(data []byte) ([]byte, error) {
+func decompressZip(data []byte, limits Limits) ([]byte, error) {
 	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
 		return nil, fmt.Errorf("error creating zip reader: %w", err)
 	}
 
+	if err := limits.checkZipEntries(len(zipReader.File)); err != nil {
+		return nil, err
+	}
+
 	for _, f := range zipReader.File {
 		if !f.FileInfo().IsDir() {
-			file, err := f.Open()
-			if err != nil {
-				return nil, fmt.Errorf("error opening file: %w", err)
-			}
-			defer file.Close()
-
-			return io.ReadAll(file)
+			return readZipFile(f, newLimitedReader(nil, int64(f.CompressedSize64), limits))
 		}
 	}
 
 	return nil, fmt.Errorf("no files found in zip archive")
 }
+
+// readZipFile reads a zip archive entry through the limited reader lr, which has not yet
+// been given an underlying reader.
+func readZipFile(f *zip.File, lr *limitedReader) ([]byte, error) {
+	rc, err := openZipFile(f, lr)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// openZipFile opens a zip archive entry for reading through the limited reader lr, which
+// has not yet been given an underlying reader.
+func openZipFile(f *zip.File, lr *limitedReader) (io.ReadCloser, error) {
+	// The declared size can be forged, so it is only used to reject early; the limited
+	// reader enforces the limits on the actual data
+	if limit := lr.limits.MaxDecompressedBytes; limit > 0 && lr.base+int64(f.UncompressedSize64) > limit {
+		return nil, &LimitError{Limit: "decompressed bytes", Value: lr.base + int64(f.UncompressedSize64), Max: limit}
+	}
+
+	file, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("error opening file %s: %w", f.Name, err)
+	}
+
+	lr.r = file
+	return readCloser{Reader: lr, Closer: file}, nil
+}
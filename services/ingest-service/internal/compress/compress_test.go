@@ -0,0 +1,509 @@
+package compress
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/compress/compresstest"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/config"
+)
+
+const testReport = `<?xml version="1.0" encoding="UTF-8" ?><feedback></feedback>`
+
+func zipData(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("error creating zip entry: %v", err)
+		}
+		if _, err := f.Write(data); err != nil {
+			t.Fatalf("error writing zip entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing zip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func tarData(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for name, data := range files {
+		if err := w.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data))}); err != nil {
+			t.Fatalf("error writing tar header: %v", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("error writing tar entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectFormat(t *testing.T) {
+	gzipped := compresstest.Gzip(t, []byte(testReport))
+	zipped := zipData(t, map[string][]byte{"report.xml": []byte(testReport)})
+
+	tests := []struct {
+		name      string
+		data      []byte
+		filename  string
+		mimeType  string
+		expected  Format
+		expectErr bool
+	}{
+		{
+			name:     "Gzip with octet-stream type",
+			data:     gzipped,
+			filename: "report",
+			mimeType: "application/octet-stream",
+			expected: FormatGzip,
+		},
+		{
+			name:     "Zip with x-zip-compressed type",
+			data:     zipped,
+			filename: "report.zip",
+			mimeType: "application/x-zip-compressed",
+			expected: FormatZip,
+		},
+		{
+			name:     "Uncompressed XML declared as gzip",
+			data:     []byte(testReport),
+			filename: "report.xml.gz",
+			mimeType: "application/gzip",
+			expected: FormatXML,
+		},
+		{
+			name:     "Uncompressed XML with byte order mark",
+			data:     append([]byte("\xef\xbb\xbf\r\n"), testReport...),
+			filename: "report",
+			mimeType: "application/octet-stream",
+			expected: FormatXML,
+		},
+		{
+			name:     "Unrecognised content falls back to filename",
+			data:     []byte("garbage"),
+			filename: "REPORT.XML.GZ",
+			mimeType: "application/octet-stream",
+			expected: FormatGzip,
+		},
+		{
+			name:     "Unrecognised content and filename falls back to MIME type",
+			data:     []byte("garbage"),
+			filename: "report",
+			mimeType: "application/x-gzip; name=report",
+			expected: FormatGzip,
+		},
+		{
+			name:     "Tar",
+			data:     tarData(t, map[string][]byte{"report.xml": []byte(testReport)}),
+			filename: "reports",
+			mimeType: "application/octet-stream",
+			expected: FormatTar,
+		},
+		{
+			name:      "Nothing recognised",
+			data:      []byte("garbage"),
+			filename:  "report.pdf",
+			mimeType:  "application/pdf",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := DetectFormat(tt.data, tt.filename, tt.mimeType)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("DetectFormat() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if format != tt.expected {
+				t.Errorf("DetectFormat() = %s, expected %s", format, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		format    Format
+		expectErr bool
+	}{
+		{
+			name:   "XML",
+			data:   []byte(testReport),
+			format: FormatXML,
+		},
+		{
+			name:   "Gzip",
+			data:   compresstest.Gzip(t, []byte(testReport)),
+			format: FormatGzip,
+		},
+		{
+			name:   "Zip",
+			data:   zipData(t, map[string][]byte{"report.xml": []byte(testReport)}),
+			format: FormatZip,
+		},
+		{
+			name:      "Invalid gzip",
+			data:      []byte(testReport),
+			format:    FormatGzip,
+			expectErr: true,
+		},
+		{
+			name:      "Unknown format",
+			data:      []byte(testReport),
+			format:    Format("rar"),
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Decompress(tt.data, tt.format, DefaultLimits)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("Decompress() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if !tt.expectErr && string(data) != testReport {
+				t.Errorf("Decompress() = %q, expected %q", data, testReport)
+			}
+		})
+	}
+}
+
+func TestDecompressLimits(t *testing.T) {
+	bomb := compresstest.Gzip(t, make([]byte, 4<<20))
+
+	tests := []struct {
+		name   string
+		data   []byte
+		format Format
+		limits Limits
+		limit  string
+	}{
+		{
+			name:   "Decompressed bytes",
+			data:   compresstest.Gzip(t, []byte(testReport)),
+			format: FormatGzip,
+			limits: Limits{MaxDecompressedBytes: 16},
+			limit:  "decompressed bytes",
+		},
+		{
+			name:   "Compression ratio",
+			data:   bomb,
+			format: FormatGzip,
+			limits: Limits{MaxRatio: 200},
+			limit:  "compression ratio",
+		},
+		{
+			name:   "Declared zip entry size",
+			data:   zipData(t, map[string][]byte{"report.xml": make([]byte, 1024)}),
+			format: FormatZip,
+			limits: Limits{MaxDecompressedBytes: 512},
+			limit:  "decompressed bytes",
+		},
+		{
+			name: "Zip entries",
+			data: zipData(t, map[string][]byte{
+				"1.xml": []byte(testReport),
+				"2.xml": []byte(testReport),
+				"3.xml": []byte(testReport),
+			}),
+			format: FormatZip,
+			limits: Limits{MaxZipEntries: 2},
+			limit:  "zip entries",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decompress(tt.data, tt.format, tt.limits)
+
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("Decompress() error = %v, expected a LimitError", err)
+			}
+			if limitErr.Limit != tt.limit {
+				t.Errorf("Decompress() limit = %s, expected %s", limitErr.Limit, tt.limit)
+			}
+			if !IsLimitError(err) {
+				t.Errorf("IsLimitError() = false, expected true")
+			}
+		})
+	}
+}
+
+func TestDecompressWithinLimits(t *testing.T) {
+	data, err := Decompress(compresstest.Gzip(t, make([]byte, 4<<20)), FormatGzip, Limits{MaxDecompressedBytes: 8 << 20})
+	if err != nil {
+		t.Fatalf("Decompress() error = %v", err)
+	}
+	if len(data) != 4<<20 {
+		t.Errorf("Decompress() returned %d bytes, expected %d", len(data), 4<<20)
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		format    Format
+		limits    Limits
+		expectErr bool
+		limit     string
+	}{
+		{
+			name:   "XML",
+			data:   []byte(testReport),
+			format: FormatXML,
+			limits: DefaultLimits,
+		},
+		{
+			name:   "Gzip",
+			data:   compresstest.Gzip(t, []byte(testReport)),
+			format: FormatGzip,
+			limits: DefaultLimits,
+		},
+		{
+			name:   "Zip",
+			data:   zipData(t, map[string][]byte{"report.xml": []byte(testReport)}),
+			format: FormatZip,
+			limits: DefaultLimits,
+		},
+		{
+			name:      "Invalid gzip",
+			data:      []byte(testReport),
+			format:    FormatGzip,
+			limits:    DefaultLimits,
+			expectErr: true,
+		},
+		{
+			name:   "Decompressed bytes",
+			data:   []byte(testReport),
+			format: FormatXML,
+			limits: Limits{MaxDecompressedBytes: 16},
+			limit:  "decompressed bytes",
+		},
+		{
+			name:   "Compression ratio",
+			data:   compresstest.Gzip(t, make([]byte, 4<<20)),
+			format: FormatGzip,
+			limits: Limits{MaxRatio: 200},
+			limit:  "compression ratio",
+		},
+		{
+			name:   "Declared zip entry size",
+			data:   zipData(t, map[string][]byte{"report.xml": make([]byte, 1024)}),
+			format: FormatZip,
+			limits: Limits{MaxDecompressedBytes: 512},
+			limit:  "decompressed bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(tt.data), tt.format, tt.limits)
+			if err == nil {
+				defer r.Close()
+				var data []byte
+				data, err = io.ReadAll(r)
+				if err == nil && string(data) != testReport {
+					t.Errorf("NewReader() read %q, expected %q", data, testReport)
+				}
+			}
+
+			if tt.limit != "" {
+				var limitErr *LimitError
+				if !errors.As(err, &limitErr) || limitErr.Limit != tt.limit {
+					t.Fatalf("NewReader() error = %v, expected a %s LimitError", err, tt.limit)
+				}
+				return
+			}
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("NewReader() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func TestExtract(t *testing.T) {
+	report := []byte(testReport)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		filename  string
+		format    Format
+		expected  []string
+		expectErr error
+	}{
+		{
+			name:     "XML",
+			data:     report,
+			filename: "report.xml",
+			format:   FormatXML,
+			expected: []string{"report.xml"},
+		},
+		{
+			name:     "Gzip takes name from filename",
+			data:     compresstest.Gzip(t, report),
+			filename: "google.com!example.com!1722470400!1722556799.xml.gz",
+			format:   FormatGzip,
+			expected: []string{"google.com!example.com!1722470400!1722556799.xml"},
+		},
+		{
+			name: "Zip with multiple reports",
+			data: zipData(t, map[string][]byte{
+				"a.xml":     report,
+				"dir/b.xml": report,
+			}),
+			filename: "reports.zip",
+			format:   FormatZip,
+			expected: []string{"a.xml", "b.xml"},
+		},
+		{
+			name: "Zip skips non-report entries",
+			data: zipData(t, map[string][]byte{
+				"report.xml":          report,
+				"README.txt":          []byte("these are your reports"),
+				"__MACOSX/report.xml": report,
+			}),
+			filename: "reports.zip",
+			format:   FormatZip,
+			expected: []string{"report.xml"},
+		},
+		{
+			name: "Gzipped report inside zip",
+			data: zipData(t, map[string][]byte{
+				"inner.xml.gz": compresstest.Gzip(t, report),
+				"plain.xml":    report,
+			}),
+			filename: "reports.zip",
+			format:   FormatZip,
+			expected: []string{"inner.xml", "plain.xml"},
+		},
+		{
+			name: "Tar.gz",
+			data: compresstest.Gzip(t, tarData(t, map[string][]byte{
+				"a.xml": report,
+				"b.xml": report,
+			})),
+			filename: "reports.tgz",
+			format:   FormatGzip,
+			expected: []string{"a.xml", "b.xml"},
+		},
+		{
+			name: "Tar.gz inside zip",
+			data: zipData(t, map[string][]byte{
+				"reports.tar.gz": compresstest.Gzip(t, tarData(t, map[string][]byte{"nested.xml": report})),
+			}),
+			filename: "reports.zip",
+			format:   FormatZip,
+			expected: []string{"nested.xml"},
+		},
+		{
+			name:      "Archive without reports",
+			data:      zipData(t, map[string][]byte{"README.txt": []byte("nothing to see")}),
+			filename:  "reports.zip",
+			format:    FormatZip,
+			expectErr: ErrNoReports,
+		},
+		{
+			name: "Nested beyond maximum depth",
+			data: zipData(t, map[string][]byte{
+				"a.zip": zipData(t, map[string][]byte{
+					"b.tar.gz": compresstest.Gzip(t, tarData(t, map[string][]byte{"report.xml": report})),
+				}),
+			}),
+			filename:  "reports.zip",
+			format:    FormatZip,
+			expectErr: ErrLimitExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reports, err := Extract(tt.data, tt.filename, tt.format, DefaultLimits)
+			if tt.expectErr != nil {
+				if !errors.Is(err, tt.expectErr) {
+					t.Fatalf("Extract() error = %v, expected %v", err, tt.expectErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+
+			names := make([]string, 0, len(reports))
+			for _, r := range reports {
+				if string(r.Data) != testReport {
+					t.Errorf("Extract() report %s = %q, expected %q", r.Name, r.Data, testReport)
+				}
+				names = append(names, r.Name)
+			}
+			sort.Strings(names)
+			if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Extract() names = %v, expected %v", names, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtractTotalLimit(t *testing.T) {
+	// Each entry is within the limit on its own, but together they exceed it
+	data := zipData(t, map[string][]byte{
+		"a.xml": append([]byte(testReport), make([]byte, 600)...),
+		"b.xml": append([]byte(testReport), make([]byte, 600)...),
+	})
+
+	_, err := Extract(data, "reports.zip", FormatZip, Limits{MaxDecompressedBytes: 1024})
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "decompressed bytes" {
+		t.Fatalf("Extract() error = %v, expected a decompressed bytes LimitError", err)
+	}
+}
+
+func TestLimitsConfig(t *testing.T) {
+	type testConfig struct {
+		Limits Limits `prefix:"DECOMPRESSION_"`
+	}
+
+	loaded, err := config.Load[testConfig](context.Background(), config.NewLoader(nil, 0))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	expected := Limits{MaxDecompressedBytes: 100 << 20, MaxRatio: 200, MaxZipEntries: 32, MaxDepth: 3}
+	if DefaultLimits != expected {
+		t.Errorf("DefaultLimits = %+v, expected %+v", DefaultLimits, expected)
+	}
+	if loaded.Limits != DefaultLimits {
+		t.Errorf("default Limits = %+v, expected DefaultLimits %+v", loaded.Limits, DefaultLimits)
+	}
+
+	t.Setenv("DECOMPRESSION_MAX_BYTES", "1024")
+	t.Setenv("DECOMPRESSION_MAX_DEPTH", "0")
+	loaded, err = config.Load[testConfig](context.Background(), config.NewLoader(nil, 0))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Limits.MaxDecompressedBytes != 1024 || loaded.Limits.MaxDepth != 0 || loaded.Limits.MaxRatio != DefaultLimits.MaxRatio {
+		t.Errorf("Limits = %+v, expected MaxDecompressedBytes 1024 and MaxDepth 0", loaded.Limits)
+	}
+}
@@ -0,0 +1,42 @@
+// Package compresstest provides fixtures for testing code which reads and writes compressed
+// reports.
+package compresstest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+// Gzip returns data gzip-compressed, failing the test on error.
+func Gzip(t testing.TB, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("error writing gzip data: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing gzip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Gunzip returns gzip-compressed data decompressed, failing the test on error.
+func Gunzip(t testing.TB, data []byte) []byte {
+	t.Helper()
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error creating gzip reader: %v", err)
+	}
+	defer r.Close()
+
+	uncompressed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading gzip data: %v", err)
+	}
+	return uncompressed
+}
@@ -0,0 +1,195 @@
+package compress
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrNoReports is returned by Extract when an attachment contains nothing that looks like a report.
+var ErrNoReports = errors.New("no reports found in attachment")
+
+// Report is a single report file extracted from an attachment.
+type Report struct {
+	// Name is the filename of the report within the innermost archive
+	Name string
+	Data []byte
+}
+
+// Extract returns every report found in an attachment, recursing through nested gzip, zip
+// and tar archives up to the nesting depth allowed by the limits.  Archive entries that do
+// not look like reports are skipped.  The decompressed size limit applies to the total of
+// every entry, so an attachment cannot bypass it by splitting data over many entries.
+func Extract(data []byte, name string, format Format, limits Limits) ([]Report, error) {
+	e := &extractor{limits: limits}
+	if err := e.extract(data, name, format, 0); err != nil {
+		return nil, err
+	}
+
+	if len(e.reports) == 0 {
+		return nil, ErrNoReports
+	}
+	return e.reports, nil
+}
+
+type extractor struct {
+	limits  Limits
+	total   int64
+	reports []Report
+}
+
+// extract extracts the reports from data of a known format.  The depth is the number of
+// archives the data is nested within.
+func (e *extractor) extract(data []byte, name string, format Format, depth int) error {
+	if format == FormatXML {
+		e.reports = append(e.reports, Report{Name: name, Data: data})
+		return nil
+	}
+
+	if err := e.limits.checkDepth(depth + 1); err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatGzip:
+		return e.extractGzip(data, name, depth+1)
+	case FormatZip:
+		return e.extractZip(data, depth+1)
+	case FormatTar:
+		return e.extractTar(data, depth+1)
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+// extractEntry detects the format of a decompressed archive entry and extracts it, skipping
+// entries that do not look like reports or archives.
+func (e *extractor) extractEntry(data []byte, name string, depth int) error {
+	format, ok := detectFromContent(data)
+	if !ok {
+		format, ok = detectFromFilename(name)
+	}
+	if !ok {
+		return nil
+	}
+
+	return e.extract(data, name, format, depth)
+}
+
+func (e *extractor) extractGzip(data []byte, name string, depth int) error {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error creating gzip reader: %w", err)
+	}
+	defer gzipReader.Close()
+
+	inner, err := e.read(gzipReader, int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	return e.extractEntry(inner, gunzippedName(name, gzipReader.Name), depth)
+}
+
+func (e *extractor) extractZip(data []byte, depth int) error {
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("error creating zip reader: %w", err)
+	}
+
+	if err := e.limits.checkZipEntries(len(zipReader.File)); err != nil {
+		return err
+	}
+
+	for _, f := range zipReader.File {
+		if f.FileInfo().IsDir() || isMetadataEntry(f.Name) {
+			continue
+		}
+
+		lr := newLimitedReader(nil, int64(f.CompressedSize64), e.limits)
+		lr.base = e.total
+		inner, err := readZipFile(f, lr)
+		e.total += int64(len(inner))
+		if err != nil {
+			return err
+		}
+
+		if err := e.extractEntry(inner, path.Base(f.Name), depth); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *extractor) extractTar(data []byte, depth int) error {
+	tarReader := tar.NewReader(bytes.NewReader(data))
+
+	entries := 0
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error reading tar archive: %w", err)
+		}
+
+		entries++
+		if err := e.limits.checkZipEntries(entries); err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg || isMetadataEntry(header.Name) {
+			continue
+		}
+
+		// Tar archives are not compressed, so the ratio limit does not apply
+		inner, err := e.read(tarReader, 0)
+		if err != nil {
+			return err
+		}
+
+		if err := e.extractEntry(inner, path.Base(header.Name), depth); err != nil {
+			return err
+		}
+	}
+}
+
+// read reads all data from r, counting it towards the total decompressed size.
+func (e *extractor) read(r io.Reader, compressedSize int64) ([]byte, error) {
+	lr := newLimitedReader(r, compressedSize, e.limits)
+	lr.base = e.total
+
+	data, err := io.ReadAll(lr)
+	e.total += int64(len(data))
+	return data, err
+}
+
+// gunzippedName returns the name of the file within a gzip stream, preferring the name
+// stored in the gzip header over the name of the compressed file.
+func gunzippedName(name, headerName string) string {
+	if headerName != "" {
+		return path.Base(headerName)
+	}
+
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tgz"):
+		return name[:len(name)-len(".tgz")] + ".tar"
+	case strings.HasSuffix(lower, ".gz"):
+		return name[:len(name)-len(".gz")]
+	default:
+		return name
+	}
+}
+
+// isMetadataEntry reports whether an archive entry is metadata added by the archiving tool,
+// such as the resource forks macOS stores under __MACOSX/.
+func isMetadataEntry(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), "._")
+}
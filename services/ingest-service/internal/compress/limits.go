@@ -0,0 +1,139 @@
+package compress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/config"
+)
+
+// ErrLimitExceeded is wrapped by every LimitError, so callers can check for any limit
+// violation with errors.Is.
+var ErrLimitExceeded = errors.New("decompression limit exceeded")
+
+// ratioThreshold is the decompressed size below which the compression ratio is not
+// enforced.  Small XML reports compress extremely well, so the ratio is only a meaningful
+// signal once a significant amount of data has been produced.
+const ratioThreshold = 1 << 20
+
+// Limits bounds the resources decompression may use, protecting against decompression bombs.
+// A zero value for any field disables that limit.
+//
+// Limits can be loaded as a nested config, see config.NewConfig.  The defaults in the tags of
+// the fields are the DefaultLimits.
+type Limits struct {
+	// MaxDecompressedBytes is the maximum number of bytes decompression may produce
+	MaxDecompressedBytes int64 `env:"MAX_BYTES" default:"104857600" validate:"min=0"`
+
+	// MaxRatio is the maximum ratio of decompressed to compressed bytes
+	MaxRatio int64 `env:"MAX_RATIO" default:"200" validate:"min=0"`
+
+	// MaxZipEntries is the maximum number of entries a zip (or tar) archive may contain
+	MaxZipEntries int `env:"MAX_ZIP_ENTRIES" default:"32" validate:"min=0"`
+
+	// MaxDepth is the maximum nesting depth of archives within archives
+	MaxDepth int `env:"MAX_DEPTH" default:"3" validate:"min=0"`
+}
+
+// DefaultLimits are generous enough for the largest legitimate aggregate reports, while
+// keeping a single attachment well within the memory of the extract-attachment Lambda.
+var DefaultLimits = defaultLimits()
+
+// defaultLimits returns the Limits set by the defaults in the tags of its fields.
+func defaultLimits() Limits {
+	limits, err := config.Defaults[Limits]()
+	if err != nil {
+		panic(fmt.Sprintf("invalid default decompression limits: %v", err))
+	}
+	return *limits
+}
+
+// LimitError is returned when decompression exceeds one of the configured Limits.  It
+// indicates a malicious or corrupt attachment, so retrying will never succeed.
+type LimitError struct {
+	Limit string
+	Value int64
+	Max   int64
+}
+
+// Error returns the error message.
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s of %d exceeds maximum of %d", ErrLimitExceeded, e.Limit, e.Value, e.Max)
+}
+
+// Unwrap returns ErrLimitExceeded.
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// IsLimitError reports whether err was caused by exceeding a decompression limit.
+func IsLimitError(err error) bool {
+	return errors.Is(err, ErrLimitExceeded)
+}
+
+// checkDepth returns a LimitError if the nesting depth exceeds the limit.
+func (l Limits) checkDepth(depth int) error {
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		return &LimitError{Limit: "nesting depth", Value: int64(depth), Max: int64(l.MaxDepth)}
+	}
+	return nil
+}
+
+// checkZipEntries returns a LimitError if a zip archive has too many entries.
+func (l Limits) checkZipEntries(entries int) error {
+	if l.MaxZipEntries > 0 && entries > l.MaxZipEntries {
+		return &LimitError{Limit: "zip entries", Value: int64(entries), Max: int64(l.MaxZipEntries)}
+	}
+	return nil
+}
+
+// limitedReader reads from r until either the decompressed size or ratio limit is exceeded,
+// at which point it returns a LimitError instead of more data.  The base is the number of
+// bytes already decompressed from other entries of the same attachment, which count towards
+// the decompressed size limit.  When streaming, the compressed size is not known up front,
+// so the ratio is measured against the bytes counted so far by compressed.
+type limitedReader struct {
+	r              io.Reader
+	limits         Limits
+	compressedSize int64
+	compressed     *countingReader
+	base           int64
+	read           int64
+}
+
+func newLimitedReader(r io.Reader, compressedSize int64, limits Limits) *limitedReader {
+	return &limitedReader{r: r, limits: limits, compressedSize: compressedSize}
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.read += int64(n)
+
+	if limit := lr.limits.MaxDecompressedBytes; limit > 0 && lr.base+lr.read > limit {
+		return n, &LimitError{Limit: "decompressed bytes", Value: lr.base + lr.read, Max: limit}
+	}
+	compressedSize := lr.compressedSize
+	if lr.compressed != nil {
+		compressedSize = lr.compressed.n
+	}
+	if limit := lr.limits.MaxRatio; limit > 0 && lr.read > ratioThreshold && compressedSize > 0 {
+		if ratio := lr.read / compressedSize; ratio > limit {
+			return n, &LimitError{Limit: "compression ratio", Value: ratio, Max: limit}
+		}
+	}
+
+	return n, err
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
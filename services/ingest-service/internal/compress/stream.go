@@ -0,0 +1,69 @@
+package compress
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// NewReader is the streaming counterpart of Decompress.  It returns a reader of the
+// uncompressed data of r, which fails with a LimitError once any of the limits is exceeded.
+// Gzip and XML are streamed with constant memory; zip archives need random access, so the
+// compressed archive is read into memory but its first file is still streamed.  The caller
+// must close the returned reader.
+func NewReader(r io.Reader, format Format, limits Limits) (io.ReadCloser, error) {
+	switch format {
+	case FormatXML:
+		return io.NopCloser(newLimitedReader(r, 0, limits)), nil
+	case FormatGzip:
+		return newGzipReader(r, limits)
+	case FormatZip:
+		return newZipReader(r, limits)
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+// readCloser combines a reader with the closer of the underlying stream.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func newGzipReader(r io.Reader, limits Limits) (io.ReadCloser, error) {
+	compressed := &countingReader{r: r}
+	gzipReader, err := gzip.NewReader(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("error creating gzip reader: %w", err)
+	}
+
+	lr := newLimitedReader(gzipReader, 0, limits)
+	lr.compressed = compressed
+	return readCloser{Reader: lr, Closer: gzipReader}, nil
+}
+
+func newZipReader(r io.Reader, limits Limits) (io.ReadCloser, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading zip archive: %w", err)
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error creating zip reader: %w", err)
+	}
+
+	if err := limits.checkZipEntries(len(zipReader.File)); err != nil {
+		return nil, err
+	}
+
+	for _, f := range zipReader.File {
+		if !f.FileInfo().IsDir() {
+			return openZipFile(f, newLimitedReader(nil, int64(f.CompressedSize64), limits))
+		}
+	}
+
+	return nil, fmt.Errorf("no files found in zip archive")
+}
@@ -2,75 +2,235 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"reflect"
-	"sync"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// NewConfig retrieves all configuration values and returns a Config struct.  Fields are read
+// from the environment variable named by their `env` tag, the SSM parameter named by their
+// `ssm` tag, or the Secrets Manager secret named by their `secret` tag.  A secret tag of the
+// form "name#key" reads a single key of a secret holding a JSON object.  A missing value is an
+// error unless the field has a `default` tag, which is used instead, or an `optional:"true"`
+// tag, which leaves the field at its zero value.  Any other error reading a value, or failing
+// to fetch parameters and secrets, is returned whatever the field's tags.
+//
+// Fields may be strings, bools, ints, uints, floats, time.Durations, []strings (comma
+// separated) or url.URLs.  A struct field without a source tag is loaded as a nested config,
+// with its `prefix` tag prepended to the environment variable names of its fields.
+//
+// Values are checked against the rules in their `validate` tag, see validateField.  Every
+// field is loaded and validated before returning, so the error lists every problem at once.
+//
+// SSM parameters and secrets are cached for the life of the Lambda execution environment, see
+// CacheTTLEnv.
+func NewConfig[T any]() (*T, error) {
+	return Load[T](context.TODO(), defaultLoader())
+}
+
+// Load is NewConfig with parameters and secrets resolved by the loader.
+func Load[T any](ctx context.Context, loader *Loader) (*T, error) {
+	config := new(T)
+	if err := loader.load(ctx, reflect.ValueOf(config).Elem()); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
 var (
-	ssmClient     *ssm.Client
-	ssmClientOnce sync.Once
+	durationType = reflect.TypeOf(time.Duration(0))
+	urlType      = reflect.TypeOf(url.URL{})
 )
 
-// NewConfig retrieves all required environment variables and returns a Config struct.
-func NewConfig[T any]() (*T, error) {
-	config := new(T)
-	v := reflect.ValueOf(config).Elem()
-	typeOfConfig := v.Type()
+// fieldSpec describes a single configuration value, read from the source into the field at
+// index.
+type fieldSpec struct {
+	index      []int
+	typ        reflect.Type
+	name       string
+	source     Source
+	defaultVal string
+	hasDefault bool
+	optional   bool
+	rules      []string
+}
 
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
-		envVarName := typeOfConfig.Field(i).Tag.Get("env")
-		ssmVarName := typeOfConfig.Field(i).Tag.Get("ssm")
+// fieldSpecs returns the configuration values of the struct type t and its nested configs,
+// prepending prefix to environment variable names.
+func fieldSpecs(t reflect.Type, prefix string, index []int) []fieldSpec {
+	var specs []fieldSpec
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		if !structField.IsExported() {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
 
-		var value string
-		var err error
-		switch {
+		spec := fieldSpec{
+			index:    fieldIndex,
+			typ:      structField.Type,
+			optional: structField.Tag.Get("optional") == "true",
+		}
+		spec.defaultVal, spec.hasDefault = structField.Tag.Lookup("default")
+		if rules := structField.Tag.Get("validate"); rules != "" {
+			for _, rule := range strings.Split(rules, ",") {
+				spec.rules = append(spec.rules, strings.TrimSpace(rule))
+			}
+		}
+
+		switch envVarName, ssmVarName, secretName := structField.Tag.Get("env"), structField.Tag.Get("ssm"), structField.Tag.Get("secret"); {
+		case secretName != "":
+			spec.name, spec.source = secretName, SourceSecret
 		case ssmVarName != "":
-			value, err = getSSMParameter(ssmVarName)
+			spec.name, spec.source = ssmVarName, SourceSSM
 		case envVarName != "":
-			value, err = getEnvironmentVariable(envVarName)
+			spec.name, spec.source = prefix+envVarName, SourceEnv
+		case isNestedConfig(structField.Type):
+			specs = append(specs, fieldSpecs(structField.Type, prefix+structField.Tag.Get("prefix"), fieldIndex)...)
+			continue
 		default:
 			continue
 		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// load sets every configuration value of the struct v, returning all errors joined.
+func (l *Loader) load(ctx context.Context, v reflect.Value) error {
+	specs := fieldSpecs(v.Type(), "", nil)
+	if err := l.resolve(ctx, specs); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, spec := range specs {
+		if err := l.loadField(v.FieldByIndex(spec.index), spec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// loadField reads, parses and validates a single configuration value.
+func (l *Loader) loadField(field reflect.Value, spec fieldSpec) error {
+	var value string
+	var err error
+	switch spec.source {
+	case SourceSSM:
+		value, err = l.parameter(spec.name)
+	case SourceSecret:
+		value, err = l.secret(spec.name)
+	default:
+		value, err = getEnvironmentVariable(spec.name)
+	}
+	if err != nil {
+		// Only a value which is not set falls back, so a secret which cannot be read fails the
+		// load rather than starting with the default
+		switch {
+		case !errors.Is(err, ErrNotFound):
+			return err
+		case spec.hasDefault:
+			value = spec.defaultVal
+		case spec.optional:
+			return validateField(spec.name, field, spec.rules, false)
+		default:
+			return err
+		}
+	}
+
+	if err := setField(field, value); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", spec.name, err)
+	}
+
+	return validateField(spec.name, field, spec.rules, true)
+}
+
+// isNestedConfig reports whether a field of type t is loaded as a nested config.
+func isNestedConfig(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != urlType
+}
+
+// setField parses value into the field according to its type.
+func setField(field reflect.Value, value string) error {
+	switch field.Type() {
+	case durationType:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	case urlType, reflect.PointerTo(urlType):
+		parsed, err := url.Parse(value)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		if field.Kind() == reflect.Pointer {
+			field.Set(reflect.ValueOf(parsed))
+		} else {
+			field.Set(reflect.ValueOf(*parsed))
+		}
+		return nil
+	}
 
+	switch field.Kind() {
+	case reflect.String:
 		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(parsed)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", field.Type())
+		}
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		field.Set(reflect.ValueOf(values).Convert(field.Type()))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
 	}
 
-	return config, nil
+	return nil
 }
 
 // getEnvironmentVariable fetches the value of an environment variable.
 func getEnvironmentVariable(key string) (string, error) {
 	value := os.Getenv(key)
 	if value == "" {
-		return "", fmt.Errorf("missing required environment variable: %s", key)
+		return "", notFound("missing required environment variable: %s", key)
 	}
 	return value, nil
 }
-
-// getSSMParameter fetches the value of an SSM parameter, decrypting it if necessary.
-func getSSMParameter(key string) (string, error) {
-	// Initialize the SSM client only once
-	ssmClientOnce.Do(func() {
-		ssmClient = ssm.NewFromConfig(aws.Config{})
-	})
-
-	param, err := ssmClient.GetParameter(context.TODO(), &ssm.GetParameterInput{
-		Name:           aws.String(key),
-		WithDecryption: aws.Bool(true),
-	})
-
-	if err != nil {
-		return "", fmt.Errorf("failed to get parameter %s from SSM: %v", key, err)
-	}
-
-	return *param.Parameter.Value, nil
-}
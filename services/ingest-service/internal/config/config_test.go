@@ -1,8 +1,12 @@
 package config
 
 import (
+	"net/url"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetEnvironmentVariable(t *testing.T) {
@@ -51,3 +55,300 @@ func TestGetEnvironmentVariable(t *testing.T) {
 		})
 	}
 }
+
+type testDatabaseConfig struct {
+	Host string `env:"HOST"`
+	Port int    `env:"PORT" default:"5432"`
+}
+
+type testConfig struct {
+	Name     string             `env:"TEST_NAME"`
+	Count    int                `env:"TEST_COUNT" default:"3"`
+	Enabled  bool               `env:"TEST_ENABLED" optional:"true"`
+	Timeout  time.Duration      `env:"TEST_TIMEOUT" default:"30s"`
+	Ratio    float64            `env:"TEST_RATIO" optional:"true"`
+	Size     uint16             `env:"TEST_SIZE" optional:"true"`
+	Tags     []string           `env:"TEST_TAGS" optional:"true"`
+	Endpoint url.URL            `env:"TEST_ENDPOINT" default:"http://localhost:4566"`
+	Callback *url.URL           `env:"TEST_CALLBACK" optional:"true"`
+	Database testDatabaseConfig `prefix:"TEST_DB_"`
+
+	untagged string
+}
+
+// testConfigEnv is every environment variable read by testConfig
+var testConfigEnv = []string{
+	"TEST_NAME", "TEST_COUNT", "TEST_ENABLED", "TEST_TIMEOUT", "TEST_RATIO", "TEST_SIZE",
+	"TEST_TAGS", "TEST_ENDPOINT", "TEST_CALLBACK", "TEST_DB_HOST", "TEST_DB_PORT",
+}
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("error parsing URL: %v", err)
+	}
+	return parsed
+}
+
+func TestNewConfig(t *testing.T) {
+	required := map[string]string{"TEST_NAME": "ingest", "TEST_DB_HOST": "db.internal"}
+	with := func(env map[string]string) map[string]string {
+		merged := map[string]string{}
+		for k, v := range required {
+			merged[k] = v
+		}
+		for k, v := range env {
+			merged[k] = v
+		}
+		return merged
+	}
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		expected  testConfig
+		expectErr bool
+	}{
+		{
+			name: "Defaults and optional fields",
+			env:  required,
+			expected: testConfig{
+				Name:     "ingest",
+				Count:    3,
+				Timeout:  30 * time.Second,
+				Endpoint: *mustParseURL(t, "http://localhost:4566"),
+				Database: testDatabaseConfig{Host: "db.internal", Port: 5432},
+			},
+		},
+		{
+			name: "Every field set",
+			env: with(map[string]string{
+				"TEST_COUNT":    "-7",
+				"TEST_ENABLED":  "true",
+				"TEST_TIMEOUT":  "1m30s",
+				"TEST_RATIO":    "0.25",
+				"TEST_SIZE":     "512",
+				"TEST_TAGS":     "a, b,,c ",
+				"TEST_ENDPOINT": "https://s3.eu-west-2.amazonaws.com",
+				"TEST_CALLBACK": "https://example.com/hook?x=1",
+				"TEST_DB_PORT":  "6543",
+			}),
+			expected: testConfig{
+				Name:     "ingest",
+				Count:    -7,
+				Enabled:  true,
+				Timeout:  90 * time.Second,
+				Ratio:    0.25,
+				Size:     512,
+				Tags:     []string{"a", "b", "c"},
+				Endpoint: *mustParseURL(t, "https://s3.eu-west-2.amazonaws.com"),
+				Callback: mustParseURL(t, "https://example.com/hook?x=1"),
+				Database: testDatabaseConfig{Host: "db.internal", Port: 6543},
+			},
+		},
+		{
+			name:      "Required field missing",
+			env:       map[string]string{"TEST_DB_HOST": "db.internal"},
+			expectErr: true,
+		},
+		{
+			name:      "Required nested field missing",
+			env:       map[string]string{"TEST_NAME": "ingest"},
+			expectErr: true,
+		},
+		{
+			name:      "Invalid int",
+			env:       with(map[string]string{"TEST_COUNT": "three"}),
+			expectErr: true,
+		},
+		{
+			name:      "Invalid bool",
+			env:       with(map[string]string{"TEST_ENABLED": "maybe"}),
+			expectErr: true,
+		},
+		{
+			name:      "Invalid duration",
+			env:       with(map[string]string{"TEST_TIMEOUT": "30"}),
+			expectErr: true,
+		},
+		{
+			name:      "Invalid float",
+			env:       with(map[string]string{"TEST_RATIO": "half"}),
+			expectErr: true,
+		},
+		{
+			name:      "Uint overflow",
+			env:       with(map[string]string{"TEST_SIZE": "70000"}),
+			expectErr: true,
+		},
+		{
+			name:      "Invalid URL",
+			env:       with(map[string]string{"TEST_CALLBACK": "http://[::1"}),
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range testConfigEnv {
+				t.Setenv(key, tt.env[key])
+			}
+
+			config, err := NewConfig[testConfig]()
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("NewConfig() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if !tt.expectErr && !reflect.DeepEqual(*config, tt.expected) {
+				t.Errorf("NewConfig() = %+v, expected %+v", *config, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewConfigUnsupportedType(t *testing.T) {
+	t.Setenv("TEST_LABELS", "a=b")
+
+	_, err := NewConfig[struct {
+		Labels map[string]string `env:"TEST_LABELS"`
+	}]()
+	if err == nil {
+		t.Error("NewConfig() expected an error for an unsupported field type")
+	}
+}
+
+func TestNewConfigAggregatesErrors(t *testing.T) {
+	for _, key := range testConfigEnv {
+		t.Setenv(key, "")
+	}
+	t.Setenv("TEST_COUNT", "three")
+
+	_, err := NewConfig[testConfig]()
+	if err == nil {
+		t.Fatal("NewConfig() expected an error")
+	}
+	for _, name := range []string{"TEST_NAME", "TEST_COUNT", "TEST_DB_HOST"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("NewConfig() error %q does not mention %s", err, name)
+		}
+	}
+}
+
+type testValidatedConfig struct {
+	QueueURL string        `env:"TEST_QUEUE_URL" validate:"required,url"`
+	LogLevel string        `env:"TEST_LOG_LEVEL" default:"info" validate:"oneof=debug info warn error"`
+	Workers  int           `env:"TEST_WORKERS" default:"4" validate:"min=1,max=16"`
+	Timeout  time.Duration `env:"TEST_TIMEOUT" default:"30s" validate:"max=15m"`
+	Tenants  []string      `env:"TEST_TENANTS" optional:"true" validate:"max=2"`
+	Region   string        `env:"TEST_REGION" optional:"true" validate:"min=9"`
+}
+
+func TestNewConfigValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		expectErr string
+	}{
+		{
+			name: "Valid",
+			env:  map[string]string{"TEST_QUEUE_URL": "https://sqs.eu-west-2.amazonaws.com/000000000000/queue"},
+		},
+		{
+			name:      "Relative URL",
+			env:       map[string]string{"TEST_QUEUE_URL": "/000000000000/queue"},
+			expectErr: "TEST_QUEUE_URL: must be an absolute URL",
+		},
+		{
+			name:      "Not one of",
+			env:       map[string]string{"TEST_QUEUE_URL": "https://sqs.test", "TEST_LOG_LEVEL": "trace"},
+			expectErr: "TEST_LOG_LEVEL: must be one of debug, info, warn, error",
+		},
+		{
+			name:      "Below min",
+			env:       map[string]string{"TEST_QUEUE_URL": "https://sqs.test", "TEST_WORKERS": "0"},
+			expectErr: "TEST_WORKERS: must be at least 1",
+		},
+		{
+			name:      "Above max",
+			env:       map[string]string{"TEST_QUEUE_URL": "https://sqs.test", "TEST_WORKERS": "32"},
+			expectErr: "TEST_WORKERS: must be at most 16",
+		},
+		{
+			name:      "Duration above max",
+			env:       map[string]string{"TEST_QUEUE_URL": "https://sqs.test", "TEST_TIMEOUT": "1h"},
+			expectErr: "TEST_TIMEOUT: must be at most 15m",
+		},
+		{
+			name:      "Too many list items",
+			env:       map[string]string{"TEST_QUEUE_URL": "https://sqs.test", "TEST_TENANTS": "a,b,c"},
+			expectErr: "TEST_TENANTS: must be at most 2",
+		},
+		{
+			name:      "String too short",
+			env:       map[string]string{"TEST_QUEUE_URL": "https://sqs.test", "TEST_REGION": "eu-west"},
+			expectErr: "TEST_REGION: must be at least 9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"TEST_QUEUE_URL", "TEST_LOG_LEVEL", "TEST_WORKERS", "TEST_TIMEOUT", "TEST_TENANTS", "TEST_REGION"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			_, err := NewConfig[testValidatedConfig]()
+			if tt.expectErr == "" {
+				if err != nil {
+					t.Fatalf("NewConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+				t.Errorf("NewConfig() error = %v, expected it to contain %q", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	variables := Describe[testValidatedConfig]()
+	if len(variables) != 6 {
+		t.Fatalf("Describe() returned %d variables, expected 6", len(variables))
+	}
+
+	expected := []Variable{
+		{Name: "TEST_QUEUE_URL", Source: SourceEnv, Type: "string", Required: true, Rules: []string{"required", "url"}},
+		{Name: "TEST_LOG_LEVEL", Source: SourceEnv, Type: "string", Default: "info", HasDefault: true, Rules: []string{"oneof=debug info warn error"}},
+		{Name: "TEST_WORKERS", Source: SourceEnv, Type: "int", Default: "4", HasDefault: true, Rules: []string{"min=1", "max=16"}},
+	}
+	for i, variable := range expected {
+		if !reflect.DeepEqual(variables[i], variable) {
+			t.Errorf("Describe()[%d] = %+v, expected %+v", i, variables[i], variable)
+		}
+	}
+
+	nested := Describe[testConfig]()
+	if name := nested[len(nested)-1].Name; name != "TEST_DB_PORT" {
+		t.Errorf("Describe() nested variable = %s, expected TEST_DB_PORT", name)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	defaults, err := Defaults[testValidatedConfig]()
+	if err != nil {
+		t.Fatalf("Defaults() error = %v", err)
+	}
+
+	expected := testValidatedConfig{LogLevel: "info", Workers: 4, Timeout: 30 * time.Second}
+	if !reflect.DeepEqual(*defaults, expected) {
+		t.Errorf("Defaults() = %+v, expected %+v", *defaults, expected)
+	}
+
+	type invalidDefaultConfig struct {
+		Workers int `env:"TEST_WORKERS" default:"four"`
+	}
+	if _, err := Defaults[invalidDefaultConfig](); err == nil {
+		t.Error("Defaults() error = nil, expected the invalid default to be reported")
+	}
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Source is where a configuration value is read from.
+type Source string
+
+const (
+	SourceEnv    Source = "env"
+	SourceSSM    Source = "ssm"
+	SourceSecret Source = "secret"
+)
+
+// Variable describes a single configuration value of a Config struct.
+type Variable struct {
+	// Name is the environment variable, SSM parameter or secret name
+	Name   string
+	Source Source
+
+	// Type is the Go type of the field the value is parsed into
+	Type string
+
+	// Default is the value used when the variable is not set, if HasDefault
+	Default    string
+	HasDefault bool
+
+	// Required is true when the variable must be set, as it has no default and is not optional
+	Required bool
+
+	// Rules are the validation rules from the field's `validate` tag
+	Rules []string
+}
+
+// Describe lists every configuration value read by NewConfig for T, in field order, such as
+// for generating deployment documentation.
+func Describe[T any]() []Variable {
+	specs := fieldSpecs(reflect.TypeOf((*T)(nil)).Elem(), "", nil)
+
+	variables := make([]Variable, len(specs))
+	for i, spec := range specs {
+		variables[i] = Variable{
+			Name:       spec.name,
+			Source:     spec.source,
+			Type:       spec.typ.String(),
+			Default:    spec.defaultVal,
+			HasDefault: spec.hasDefault,
+			Required:   !spec.hasDefault && !spec.optional,
+			Rules:      spec.rules,
+		}
+	}
+	return variables
+}
+
+// Defaults returns a T with every field that has a `default` tag set to its default, and the
+// other fields left at their zero values, so a package can export its defaults without
+// repeating the values written in the tags.
+func Defaults[T any]() (*T, error) {
+	config := new(T)
+	v := reflect.ValueOf(config).Elem()
+	for _, spec := range fieldSpecs(v.Type(), "", nil) {
+		if !spec.hasDefault {
+			continue
+		}
+		if err := setField(v.FieldByIndex(spec.index), spec.defaultVal); err != nil {
+			return nil, fmt.Errorf("invalid default for %s: %w", spec.name, err)
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,289 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	secretsmanagerTypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	internalaws "github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+)
+
+// CacheTTLEnv is the environment variable setting how long NewConfig caches SSM parameters
+// and secrets, as a duration such as "10m".  Defaults to DefaultCacheTTL.
+const CacheTTLEnv = "CONFIG_CACHE_TTL"
+
+// DefaultCacheTTL is how long NewConfig caches SSM parameters and secrets by default
+const DefaultCacheTTL = 5 * time.Minute
+
+// ssmBatchSize is the most parameters GetParameters accepts in a single request
+const ssmBatchSize = 10
+
+// ErrNotFound is returned by a ParameterStore when a secret does not exist, and matches the
+// error returned when a configuration value is not set.
+var ErrNotFound = errors.New("not found")
+
+// notFoundError is returned when a configuration value is not set, which is the only error a
+// field's default or optional tag applies to.
+type notFoundError struct {
+	msg string
+}
+
+func (e *notFoundError) Error() string { return e.msg }
+
+func (e *notFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// notFound returns a notFoundError with the formatted message.
+func notFound(format string, args ...any) error {
+	return &notFoundError{msg: fmt.Sprintf(format, args...)}
+}
+
+// ParameterStore resolves SSM parameters and Secrets Manager secrets.
+type ParameterStore interface {
+	// GetParameters returns the decrypted values of the SSM parameters.  Parameters which do
+	// not exist are left out of the map.
+	GetParameters(ctx context.Context, names []string) (map[string]string, error)
+
+	// GetSecret returns the value of a secret, or ErrNotFound.
+	GetSecret(ctx context.Context, name string) (string, error)
+}
+
+// Loader loads Config structs, caching the parameters and secrets it resolves so each is only
+// fetched once per TTL.  Parameters and secrets which do not exist are cached too, so optional
+// values left unset are not requested on every load.
+type Loader struct {
+	store ParameterStore
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   string
+	missing bool
+	expires time.Time
+}
+
+// NewLoader returns a Loader resolving parameters and secrets from the store.  Values are
+// cached for the ttl, or not at all if it is zero.
+func NewLoader(store ParameterStore, ttl time.Duration) *Loader {
+	return &Loader{
+		store: store,
+		ttl:   ttl,
+		now:   time.Now,
+		cache: map[string]cacheEntry{},
+	}
+}
+
+// defaultLoader is the Loader used by NewConfig, which lives as long as the execution
+// environment so its cache is shared by every invocation.
+var defaultLoader = sync.OnceValue(func() *Loader {
+	ttl := DefaultCacheTTL
+	if value := os.Getenv(CacheTTLEnv); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Invalid %s %q, using %s: %v", CacheTTLEnv, value, DefaultCacheTTL, err)
+		} else {
+			ttl = parsed
+		}
+	}
+
+	return NewLoader(&awsParameterStore{}, ttl)
+})
+
+// resolve fetches every SSM parameter and secret read by the specs which is not cached, with a
+// single batched request for the parameters.
+func (l *Loader) resolve(ctx context.Context, specs []fieldSpec) error {
+	var parameters []string
+	secrets := map[string]bool{}
+	for _, spec := range specs {
+		switch spec.source {
+		case SourceSSM:
+			if _, ok := l.entry(parameterKey(spec.name)); !ok && !slices.Contains(parameters, spec.name) {
+				parameters = append(parameters, spec.name)
+			}
+		case SourceSecret:
+			name, _, _ := strings.Cut(spec.name, "#")
+			if _, ok := l.entry(secretKey(name)); !ok {
+				secrets[name] = true
+			}
+		}
+	}
+
+	if len(parameters) > 0 {
+		values, err := l.store.GetParameters(ctx, parameters)
+		if err != nil {
+			return fmt.Errorf("failed to get parameters from SSM: %w", err)
+		}
+		for _, name := range parameters {
+			if value, ok := values[name]; ok {
+				l.put(parameterKey(name), cacheEntry{value: value})
+			} else {
+				l.put(parameterKey(name), cacheEntry{missing: true})
+			}
+		}
+	}
+
+	for name := range secrets {
+		value, err := l.store.GetSecret(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			l.put(secretKey(name), cacheEntry{missing: true})
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to get secret %s: %w", name, err)
+		}
+		l.put(secretKey(name), cacheEntry{value: value})
+	}
+
+	return nil
+}
+
+// parameter returns a resolved SSM parameter.
+func (l *Loader) parameter(name string) (string, error) {
+	value, ok := l.cached(parameterKey(name))
+	if !ok || value == "" {
+		return "", notFound("missing required SSM parameter: %s", name)
+	}
+	return value, nil
+}
+
+// secret returns a resolved secret, or the key of a secret holding a JSON object when the
+// name has the form "name#key".
+func (l *Loader) secret(name string) (string, error) {
+	secretName, key, hasKey := strings.Cut(name, "#")
+
+	value, ok := l.cached(secretKey(secretName))
+	if !ok {
+		return "", notFound("missing required secret: %s", secretName)
+	}
+	if !hasKey {
+		return value, nil
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object: %w", secretName, err)
+	}
+	field, ok := fields[key]
+	if !ok || field == nil {
+		return "", notFound("missing required secret key: %s", name)
+	}
+	if s, ok := field.(string); ok {
+		return s, nil
+	}
+
+	// Numbers and booleans are passed on as their JSON representation
+	encoded, err := json.Marshal(field)
+	if err != nil {
+		return "", fmt.Errorf("error encoding secret key %s: %w", name, err)
+	}
+	return string(encoded), nil
+}
+
+// cached returns a resolved value, or false if it has not been resolved or does not exist.
+func (l *Loader) cached(key string) (string, bool) {
+	entry, ok := l.entry(key)
+	if !ok || entry.missing {
+		return "", false
+	}
+	return entry.value, true
+}
+
+// entry returns the cache entry for a key, or false if it has not been resolved within the TTL.
+func (l *Loader) entry(key string) (cacheEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.cache[key]
+	if !ok || !l.now().Before(entry.expires) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (l *Loader) put(key string, entry cacheEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// A value is always kept long enough to be read by the load that resolved it
+	entry.expires = l.now().Add(max(l.ttl, time.Second))
+	l.cache[key] = entry
+}
+
+func parameterKey(name string) string { return "ssm:" + name }
+func secretKey(name string) string    { return "secret:" + name }
+
+// awsParameterStore is a ParameterStore backed by SSM and Secrets Manager.  The clients are
+// created on first use, so configs without parameters or secrets make no AWS calls.
+type awsParameterStore struct {
+	once    sync.Once
+	err     error
+	ssm     *ssm.Client
+	secrets *secretsmanager.Client
+}
+
+func (s *awsParameterStore) clients(ctx context.Context) error {
+	s.once.Do(func() {
+		opts, err := internalaws.ClientOptionsFromEnv()
+		if err != nil {
+			s.err = err
+			return
+		}
+		if s.ssm, s.err = internalaws.NewSSMClient(ctx, opts); s.err != nil {
+			return
+		}
+		s.secrets, s.err = internalaws.NewSecretsManagerClient(ctx, opts)
+	})
+	return s.err
+}
+
+func (s *awsParameterStore) GetParameters(ctx context.Context, names []string) (map[string]string, error) {
+	if err := s.clients(ctx); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(names))
+	for start := 0; start < len(names); start += ssmBatchSize {
+		output, err := s.ssm.GetParameters(ctx, &ssm.GetParametersInput{
+			Names:          names[start:min(start+ssmBatchSize, len(names))],
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, parameter := range output.Parameters {
+			values[aws.ToString(parameter.Name)] = aws.ToString(parameter.Value)
+		}
+	}
+
+	return values, nil
+}
+
+func (s *awsParameterStore) GetSecret(ctx context.Context, name string) (string, error) {
+	if err := s.clients(ctx); err != nil {
+		return "", err
+	}
+
+	output, err := s.secrets.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(name)})
+	if err != nil {
+		var notFound *secretsmanagerTypes.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+
+	return aws.ToString(output.SecretString), nil
+}
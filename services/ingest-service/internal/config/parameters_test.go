@@ -0,0 +1,272 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeParameterStore is an in-memory ParameterStore which counts the requests made to it.
+type fakeParameterStore struct {
+	parameters map[string]string
+	secrets    map[string]string
+
+	parameterRequests [][]string
+	secretRequests    []string
+
+	// err is returned by every request when set
+	err error
+}
+
+func (f *fakeParameterStore) GetParameters(ctx context.Context, names []string) (map[string]string, error) {
+	f.parameterRequests = append(f.parameterRequests, names)
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	values := map[string]string{}
+	for _, name := range names {
+		if value, ok := f.parameters[name]; ok {
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+func (f *fakeParameterStore) GetSecret(ctx context.Context, name string) (string, error) {
+	f.secretRequests = append(f.secretRequests, name)
+	if f.err != nil {
+		return "", f.err
+	}
+
+	value, ok := f.secrets[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+type testParameterConfig struct {
+	TableName string        `ssm:"/ingest/table-name"`
+	QueueURL  string        `ssm:"/ingest/queue-url"`
+	Timeout   time.Duration `ssm:"/ingest/timeout" default:"10s"`
+	Username  string        `secret:"ingest/database#username"`
+	Port      int           `secret:"ingest/database#port"`
+	APIKey    string        `secret:"ingest/api-key"`
+}
+
+func newFakeParameterStore() *fakeParameterStore {
+	return &fakeParameterStore{
+		parameters: map[string]string{
+			"/ingest/table-name": "reports",
+			"/ingest/queue-url":  "https://sqs.eu-west-2.amazonaws.com/000000000000/queue",
+		},
+		secrets: map[string]string{
+			"ingest/database": `{"username": "ingest", "port": 5432}`,
+			"ingest/api-key":  "s3cr3t",
+		},
+	}
+}
+
+func TestLoadParameters(t *testing.T) {
+	store := newFakeParameterStore()
+	loader := NewLoader(store, time.Minute)
+
+	config, err := Load[testParameterConfig](context.Background(), loader)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	expected := testParameterConfig{
+		TableName: "reports",
+		QueueURL:  "https://sqs.eu-west-2.amazonaws.com/000000000000/queue",
+		Timeout:   10 * time.Second,
+		Username:  "ingest",
+		Port:      5432,
+		APIKey:    "s3cr3t",
+	}
+	if *config != expected {
+		t.Errorf("Load() = %+v, expected %+v", *config, expected)
+	}
+
+	// Every parameter is fetched in a single request, and each secret once
+	expectedParameters := [][]string{{"/ingest/table-name", "/ingest/queue-url", "/ingest/timeout"}}
+	if !reflect.DeepEqual(store.parameterRequests, expectedParameters) {
+		t.Errorf("parameter requests = %v, expected %v", store.parameterRequests, expectedParameters)
+	}
+	if len(store.secretRequests) != 2 {
+		t.Errorf("secret requests = %v, expected 2", store.secretRequests)
+	}
+}
+
+func TestLoadCachesParameters(t *testing.T) {
+	store := newFakeParameterStore()
+	loader := NewLoader(store, time.Minute)
+	now := time.Now()
+	loader.now = func() time.Time { return now }
+
+	for range 3 {
+		if _, err := Load[testParameterConfig](context.Background(), loader); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+	}
+
+	// The missing timeout parameter is cached as missing, so it is not requested again either
+	if len(store.parameterRequests) != 1 {
+		t.Errorf("parameter requests = %v, expected cached parameters to be skipped", store.parameterRequests)
+	}
+	if len(store.secretRequests) != 2 {
+		t.Errorf("secret requests = %v, expected secrets to be cached", store.secretRequests)
+	}
+
+	// Once the TTL has passed, every value is fetched again
+	now = now.Add(2 * time.Minute)
+	if _, err := Load[testParameterConfig](context.Background(), loader); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if last := store.parameterRequests[len(store.parameterRequests)-1]; len(last) != 3 {
+		t.Errorf("parameter request after TTL = %v, expected every parameter", last)
+	}
+	if len(store.secretRequests) != 4 {
+		t.Errorf("secret requests after TTL = %v, expected secrets to be fetched again", store.secretRequests)
+	}
+}
+
+func TestLoadCachesMissingSecrets(t *testing.T) {
+	type optionalSecretConfig struct {
+		APIKey string `secret:"ingest/api-key" default:"none"`
+	}
+
+	store := newFakeParameterStore()
+	delete(store.secrets, "ingest/api-key")
+	loader := NewLoader(store, time.Minute)
+	now := time.Now()
+	loader.now = func() time.Time { return now }
+
+	for range 3 {
+		config, err := Load[optionalSecretConfig](context.Background(), loader)
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if config.APIKey != "none" {
+			t.Errorf("APIKey = %q, expected the default", config.APIKey)
+		}
+	}
+	if len(store.secretRequests) != 1 {
+		t.Errorf("secret requests = %v, expected the missing secret to be cached", store.secretRequests)
+	}
+
+	// Once the TTL has passed, a secret which has since been created is picked up
+	store.secrets["ingest/api-key"] = "s3cr3t"
+	now = now.Add(2 * time.Minute)
+	config, err := Load[optionalSecretConfig](context.Background(), loader)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if config.APIKey != "s3cr3t" || len(store.secretRequests) != 2 {
+		t.Errorf("APIKey = %q after %d requests, expected the secret to be fetched again", config.APIKey, len(store.secretRequests))
+	}
+}
+
+func TestLoadDefaultsOnlyWhenNotFound(t *testing.T) {
+	type defaultSecretConfig struct {
+		Username string `secret:"ingest/database#username" default:"ingest"`
+		Port     int    `secret:"ingest/database#port" default:"5432"`
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		expected  defaultSecretConfig
+		expectErr string
+	}{
+		{
+			name:     "Missing secret key",
+			secret:   `{"port": 6543}`,
+			expected: defaultSecretConfig{Username: "ingest", Port: 6543},
+		},
+		{
+			name:      "Secret is not JSON",
+			secret:    "ingest:6543",
+			expectErr: "secret ingest/database is not a JSON object",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeParameterStore()
+			store.secrets["ingest/database"] = tt.secret
+
+			config, err := Load[defaultSecretConfig](context.Background(), NewLoader(store, time.Minute))
+			if tt.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+					t.Errorf("Load() error = %v, expected it to contain %q", err, tt.expectErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if *config != tt.expected {
+				t.Errorf("Load() = %+v, expected %+v", *config, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadStoreUnavailable(t *testing.T) {
+	type defaultParameterConfig struct {
+		Timeout time.Duration `ssm:"/ingest/timeout" default:"10s"`
+	}
+
+	// A value that could not be fetched is not known to be unset, so the default is not used
+	store := newFakeParameterStore()
+	store.err = errors.New("ThrottlingException: rate exceeded")
+	if _, err := Load[defaultParameterConfig](context.Background(), NewLoader(store, time.Minute)); err == nil {
+		t.Errorf("Load() error = nil, expected the SSM error")
+	}
+}
+
+func TestLoadMissingParameters(t *testing.T) {
+	tests := []struct {
+		name      string
+		modify    func(store *fakeParameterStore)
+		expectErr string
+	}{
+		{
+			name:      "Missing parameter",
+			modify:    func(store *fakeParameterStore) { delete(store.parameters, "/ingest/queue-url") },
+			expectErr: "missing required SSM parameter: /ingest/queue-url",
+		},
+		{
+			name:      "Missing secret",
+			modify:    func(store *fakeParameterStore) { delete(store.secrets, "ingest/api-key") },
+			expectErr: "missing required secret: ingest/api-key",
+		},
+		{
+			name:      "Missing secret key",
+			modify:    func(store *fakeParameterStore) { store.secrets["ingest/database"] = `{"port": 5432}` },
+			expectErr: "missing required secret key: ingest/database#username",
+		},
+		{
+			name:      "Secret is not JSON",
+			modify:    func(store *fakeParameterStore) { store.secrets["ingest/database"] = "ingest:5432" },
+			expectErr: "secret ingest/database is not a JSON object",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeParameterStore()
+			tt.modify(store)
+
+			_, err := Load[testParameterConfig](context.Background(), NewLoader(store, time.Minute))
+			if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+				t.Errorf("Load() error = %v, expected it to contain %q", err, tt.expectErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validateField checks the loaded value of a field against the rules from its `validate` tag:
+//
+//   - required: the value must not be the zero value, such as an empty list
+//   - url: the value must be an absolute URL
+//   - oneof=a b c: the value must be one of the space separated options
+//   - min=n, max=n: numbers and durations must be within the bound, and strings and lists must
+//     have a length within it
+//
+// Only the required rule applies when an optional field is not set.
+func validateField(name string, field reflect.Value, rules []string, set bool) error {
+	for _, rule := range rules {
+		rule, param, _ := strings.Cut(rule, "=")
+		if !set && rule != "required" {
+			continue
+		}
+
+		var err error
+		switch rule {
+		case "required":
+			if field.IsZero() {
+				err = fmt.Errorf("is required")
+			}
+		case "url":
+			err = validateURL(field)
+		case "oneof":
+			if value := fmt.Sprint(field.Interface()); !slices.Contains(strings.Fields(param), value) {
+				err = fmt.Errorf("must be one of %s", strings.Join(strings.Fields(param), ", "))
+			}
+		case "min", "max":
+			err = validateBound(field, rule, param)
+		default:
+			err = fmt.Errorf("unknown validation rule %q", rule)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// validateURL checks the field holds an absolute URL, with a scheme and host.
+func validateURL(field reflect.Value) error {
+	var parsed *url.URL
+	switch value := field.Interface().(type) {
+	case url.URL:
+		parsed = &value
+	case *url.URL:
+		parsed = value
+	case string:
+		var err error
+		if parsed, err = url.Parse(value); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("url rule does not apply to %s", field.Type())
+	}
+
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return fmt.Errorf("must be an absolute URL")
+	}
+	return nil
+}
+
+// validateBound checks the field against a min or max rule.
+func validateBound(field reflect.Value, rule string, param string) error {
+	var value, bound float64
+	var err error
+	switch {
+	case field.Type() == durationType:
+		var duration time.Duration
+		duration, err = time.ParseDuration(param)
+		value, bound = float64(field.Int()), float64(duration)
+	case field.Kind() == reflect.String || field.Kind() == reflect.Slice:
+		value = float64(field.Len())
+		bound, err = strconv.ParseFloat(param, 64)
+	case field.CanInt():
+		value = float64(field.Int())
+		bound, err = strconv.ParseFloat(param, 64)
+	case field.CanUint():
+		value = float64(field.Uint())
+		bound, err = strconv.ParseFloat(param, 64)
+	case field.CanFloat():
+		value = field.Float()
+		bound, err = strconv.ParseFloat(param, 64)
+	default:
+		return fmt.Errorf("%s rule does not apply to %s", rule, field.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s rule %q: %w", rule, param, err)
+	}
+
+	if rule == "min" && value < bound {
+		return fmt.Errorf("must be at least %s", param)
+	}
+	if rule == "max" && value > bound {
+		return fmt.Errorf("must be at most %s", param)
+	}
+	return nil
+}
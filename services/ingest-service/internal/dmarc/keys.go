@@ -0,0 +1,128 @@
+package dmarc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+// Reports and records share the DMARC table.  Both are partitioned on pk, which is the tenant
+// and policy domain, so a tenant's reports for a domain are read from a single partition.
+// Their sort keys are prefixed with the item type, then the zero-padded beginning of the
+// report's date range, so each type sorts by time and a date range is a single key range:
+//
+//	report: pk = <tenant>#<domain>  sk = REPORT#<begin>#<reportId>
+//	record: pk = <tenant>#<domain>  sk = RECORD#<begin>#<reportId>#<index>
+//
+// Daily rollups of the records are stored in the same partition, keyed on the UTC day the
+// report's date range begins, formatted as YYYY-MM-DD so days also sort in order:
+//
+//	source rollup:   pk = <tenant>#<domain>  sk = DAILY#SOURCE#<day>#<sourceIp>
+//	reporter rollup: pk = <tenant>#<domain>  sk = DAILY#REPORTER#<day>#<orgName>
+//
+// Each report added to a rollup is recorded by a marker item, written in the same transaction
+// as the rollup's counters, so a redelivered report is not counted twice:
+//
+//	rollup marker:   pk = <tenant>#<domain>  sk = COUNTED#<rollup sk>#<report sk>
+//
+// Reports are also indexed on tenantId and reporterKey, and records on sourceIpKey, with sk
+// as the sort key of every index.  Records have no tenantId, so the tenant index only
+// contains reports.  Rollups and their markers have none of the index keys, so are only in the table.
+const (
+	reportSortKeyPrefix         = "REPORT#"
+	recordSortKeyPrefix         = "RECORD#"
+	sourceRollupSortKeyPrefix   = "DAILY#SOURCE#"
+	reporterRollupSortKeyPrefix = "DAILY#REPORTER#"
+	rollupMarkerSortKeyPrefix   = "COUNTED#"
+)
+
+// ReportKey identifies a report in the DMARC table.
+type ReportKey struct {
+	TenantID       string
+	Domain         string
+	DateRangeBegin int64
+	ReportID       string
+}
+
+// KeyOf returns the key of a report item.
+func KeyOf(report models.DmarcReportMetadataItem) ReportKey {
+	return ReportKey{
+		TenantID:       report.TenantID,
+		Domain:         report.Domain,
+		DateRangeBegin: report.DateRangeBegin,
+		ReportID:       report.ReportId,
+	}
+}
+
+// partitionKey returns the partition key of the report and its records.
+func (k ReportKey) partitionKey() string {
+	return partitionKey(k.TenantID, k.Domain)
+}
+
+// sortKey returns the sort key of the report.
+func (k ReportKey) sortKey() string {
+	return fmt.Sprintf("%s%s#%s", reportSortKeyPrefix, timeKey(k.DateRangeBegin), k.ReportID)
+}
+
+// recordSortKeyPrefix returns the prefix shared by the sort keys of the report's records.
+func (k ReportKey) recordSortKeyPrefix() string {
+	return fmt.Sprintf("%s%s#%s#", recordSortKeyPrefix, timeKey(k.DateRangeBegin), k.ReportID)
+}
+
+// recordSortKey returns the sort key of the report's record at index, padded so records sort
+// in the order they appeared in the report.
+func (k ReportKey) recordSortKey(index int) string {
+	return fmt.Sprintf("%s%06d", k.recordSortKeyPrefix(), index)
+}
+
+// partitionKey returns the partition key of a tenant's reports and records for a domain.
+func partitionKey(tenantID string, domain string) string {
+	return fmt.Sprintf("%s#%s", tenantID, domain)
+}
+
+// reporterKey returns the partition key of the reporter index.
+func reporterKey(tenantID string, orgName string) string {
+	return fmt.Sprintf("%s#%s", tenantID, orgName)
+}
+
+// sourceIPKey returns the partition key of the source IP index.
+func sourceIPKey(tenantID string, sourceIP string) string {
+	return fmt.Sprintf("%s#%s", tenantID, sourceIP)
+}
+
+// dayKey formats the UTC day of a Unix time so that days sort in order as strings.
+func dayKey(t int64) string {
+	return time.Unix(t, 0).UTC().Format(time.DateOnly)
+}
+
+// timeKey formats a Unix time so that times sort in order as strings.
+func timeKey(t int64) string {
+	return fmt.Sprintf("%010d", t)
+}
+
+// timeRange returns the bounds of a BETWEEN condition on sk matching the items of a type whose
+// report's date range begins between from and to inclusive.  Every sort key continues past
+// the time, so the upper bound is the prefix of the second after to.
+func timeRange(prefix string, from int64, to int64) (string, string) {
+	return prefix + timeKey(from), prefix + timeKey(to+1)
+}
+
+// SetReportKeys sets the table and index keys of a report item from its tenant, domain,
+// reporter, date range and report ID.
+func SetReportKeys(report *models.DmarcReportMetadataItem) {
+	key := KeyOf(*report)
+	report.PK = key.partitionKey()
+	report.SK = key.sortKey()
+	report.ReporterKey = reporterKey(report.TenantID, report.OrgName)
+}
+
+// SetRecordKeys sets the table and index keys of a record item of a report from its index and
+// source IP.
+func SetRecordKeys(report models.DmarcReportMetadataItem, record *models.DmarcRecordItem) {
+	key := KeyOf(report)
+	record.PK = key.partitionKey()
+	record.SK = key.recordSortKey(record.RecordIndex)
+	record.ReportId = report.ReportId
+	record.SourceIPKey = sourceIPKey(report.TenantID, record.SourceIp)
+}
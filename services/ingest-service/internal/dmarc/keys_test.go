@@ -0,0 +1,72 @@
+package dmarc
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+func TestSetReportKeys(t *testing.T) {
+	report := models.DmarcReportMetadataItem{
+		ReportId:       "1111",
+		TenantID:       "tenant-a",
+		OrgName:        "google.com",
+		Domain:         "example.com",
+		DateRangeBegin: 1722470400,
+	}
+	SetReportKeys(&report)
+
+	if report.PK != "tenant-a#example.com" {
+		t.Errorf("PK = %s, expected tenant-a#example.com", report.PK)
+	}
+	if report.SK != "REPORT#1722470400#1111" {
+		t.Errorf("SK = %s, expected REPORT#1722470400#1111", report.SK)
+	}
+	if report.ReporterKey != "tenant-a#google.com" {
+		t.Errorf("ReporterKey = %s, expected tenant-a#google.com", report.ReporterKey)
+	}
+
+	record := models.DmarcRecordItem{RecordIndex: 7, SourceIp: "192.0.2.1"}
+	SetRecordKeys(report, &record)
+
+	if record.PK != report.PK {
+		t.Errorf("record PK = %s, expected %s", record.PK, report.PK)
+	}
+	if record.SK != "RECORD#1722470400#1111#000007" {
+		t.Errorf("record SK = %s, expected RECORD#1722470400#1111#000007", record.SK)
+	}
+	if record.SourceIPKey != "tenant-a#192.0.2.1" || record.ReportId != "1111" {
+		t.Errorf("record = %+v, expected source IP key tenant-a#192.0.2.1 and report ID 1111", record)
+	}
+}
+
+func TestSortKeysSortByTime(t *testing.T) {
+	var keys []string
+	for _, begin := range []int64{999999999, 1722470400, 86400} {
+		keys = append(keys, ReportKey{DateRangeBegin: begin, ReportID: "1"}.sortKey())
+	}
+	for _, index := range []int{10, 2} {
+		keys = append(keys, ReportKey{DateRangeBegin: 86400, ReportID: "1"}.recordSortKey(index))
+	}
+	slices.Sort(keys)
+
+	expected := []string{
+		"RECORD#0000086400#1#000002",
+		"RECORD#0000086400#1#000010",
+		"REPORT#0000086400#1",
+		"REPORT#0999999999#1",
+		"REPORT#1722470400#1",
+	}
+	if !slices.Equal(keys, expected) {
+		t.Errorf("sorted keys = %v, expected %v", keys, expected)
+	}
+
+	// Sort keys of reports beginning at the upper bound fall within the range
+	lower, upper := timeRange(reportSortKeyPrefix, 86400, 1722470400)
+	for _, key := range keys[2:] {
+		if key < lower || key > upper {
+			t.Errorf("%s is outside %s to %s", key, lower, upper)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package dmarc
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+// defaultPageSize is the page size of MemoryReportRepository when no limit is given
+const defaultPageSize = 100
+
+// MemoryReportRepository is an in-memory ReportRepository for tests.
+type MemoryReportRepository struct {
+	mu      sync.Mutex
+	reports map[ReportKey]models.DmarcReportMetadataItem
+	records map[ReportKey][]models.DmarcRecordItem
+}
+
+var _ ReportRepository = (*MemoryReportRepository)(nil)
+
+// NewMemoryReportRepository returns a MemoryReportRepository with no reports.
+func NewMemoryReportRepository() *MemoryReportRepository {
+	return &MemoryReportRepository{
+		reports: map[ReportKey]models.DmarcReportMetadataItem{},
+		records: map[ReportKey][]models.DmarcRecordItem{},
+	}
+}
+
+// PutReport stores a report and its records, replacing any report with the same key.  The
+// keys of the items are set as CreateDmarcReportItem and CreateDmarcRecordItems set them.
+func (r *MemoryReportRepository) PutReport(report models.DmarcReportMetadataItem, records []models.DmarcRecordItem) {
+	SetReportKeys(&report)
+	records = slices.Clone(records)
+	for i := range records {
+		SetRecordKeys(report, &records[i])
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := KeyOf(report)
+	r.reports[key] = report
+	r.records[key] = records
+}
+
+func (r *MemoryReportRepository) ListReports(ctx context.Context, tenantID string, domain string, from time.Time, to time.Time, opts ListOptions) (Page[models.DmarcReportMetadataItem], error) {
+	return r.listReports(tenantID, from, to, opts, func(report models.DmarcReportMetadataItem) bool {
+		return domain == "" || report.Domain == domain
+	})
+}
+
+func (r *MemoryReportRepository) ListReportsByReporter(ctx context.Context, tenantID string, orgName string, from time.Time, to time.Time, opts ListOptions) (Page[models.DmarcReportMetadataItem], error) {
+	return r.listReports(tenantID, from, to, opts, func(report models.DmarcReportMetadataItem) bool {
+		return report.OrgName == orgName
+	})
+}
+
+// listReports returns a page of the tenant's reports in the time range for which match
+// returns true.
+func (r *MemoryReportRepository) listReports(tenantID string, from time.Time, to time.Time, opts ListOptions, match func(models.DmarcReportMetadataItem) bool) (Page[models.DmarcReportMetadataItem], error) {
+	r.mu.Lock()
+	var reports []models.DmarcReportMetadataItem
+	for _, report := range r.reports {
+		if report.TenantID != tenantID || !inRange(report.DateRangeBegin, from, to) || !match(report) {
+			continue
+		}
+		reports = append(reports, report)
+	}
+	r.mu.Unlock()
+
+	slices.SortFunc(reports, func(a, b models.DmarcReportMetadataItem) int {
+		return cmp.Or(cmp.Compare(a.SK, b.SK), cmp.Compare(a.PK, b.PK))
+	})
+	return paginate(reports, opts)
+}
+
+func (r *MemoryReportRepository) GetReport(ctx context.Context, key ReportKey) (*models.DmarcReportMetadataItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report, ok := r.reports[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key.ReportID, ErrReportNotFound)
+	}
+	return &report, nil
+}
+
+func (r *MemoryReportRepository) ListRecords(ctx context.Context, key ReportKey, opts ListOptions) (Page[models.DmarcRecordItem], error) {
+	r.mu.Lock()
+	records := slices.Clone(r.records[key])
+	r.mu.Unlock()
+
+	slices.SortFunc(records, func(a, b models.DmarcRecordItem) int {
+		return cmp.Compare(a.SK, b.SK)
+	})
+	return paginate(records, opts)
+}
+
+func (r *MemoryReportRepository) ListRecordsBySourceIP(ctx context.Context, tenantID string, sourceIP string, from time.Time, to time.Time, opts ListOptions) (Page[models.DmarcRecordItem], error) {
+	r.mu.Lock()
+	var records []models.DmarcRecordItem
+	for key, reportRecords := range r.records {
+		if key.TenantID != tenantID || !inRange(key.DateRangeBegin, from, to) {
+			continue
+		}
+		for _, record := range reportRecords {
+			if record.SourceIp == sourceIP {
+				records = append(records, record)
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	slices.SortFunc(records, func(a, b models.DmarcRecordItem) int {
+		return cmp.Or(cmp.Compare(a.SK, b.SK), cmp.Compare(a.PK, b.PK))
+	})
+	return paginate(records, opts)
+}
+
+// inRange reports whether a report's date range begins between from and to inclusive.
+func inRange(begin int64, from time.Time, to time.Time) bool {
+	return begin >= from.Unix() && begin <= to.Unix()
+}
+
+// paginate returns a page of items.  The cursor is the offset of the next page.
+func paginate[T any](items []T, opts ListOptions) (Page[T], error) {
+	start := 0
+	if opts.Cursor != "" {
+		offset, err := strconv.Atoi(opts.Cursor)
+		if err != nil || offset < 0 || offset > len(items) {
+			return Page[T]{}, ErrInvalidCursor
+		}
+		start = offset
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	end := min(start+limit, len(items))
+
+	page := Page[T]{Items: items[start:end]}
+	if end < len(items) {
+		page.Cursor = strconv.Itoa(end)
+	}
+	return page, nil
+}
@@ -16,11 +16,12 @@ func ParseRUAReport(body []byte) (*rua.RUA, error) {
 	return &ruaReport, nil
 }
 
-// CreateDmarcReportItem creates a DMARC report item from the SQS message and RUA report
+// CreateDmarcReportItem creates a DMARC report item, with its table and index keys, from the
+// SQS message and RUA report
 func CreateDmarcReportItem(tenantId string, ruaReport *rua.RUA) models.DmarcReportMetadataItem {
-	return models.DmarcReportMetadataItem{
-		ID:               fmt.Sprintf("%s#%s", tenantId, ruaReport.ReportMetadata.ReportID),
+	item := models.DmarcReportMetadataItem{
 		ReportId:         ruaReport.ReportMetadata.ReportID,
+		TenantID:         tenantId,
 		OrgName:          ruaReport.ReportMetadata.OrgName,
 		Email:            ruaReport.ReportMetadata.Email,
 		ExtraContactInfo: ruaReport.ReportMetadata.ExtraContactInfo,
@@ -34,9 +35,12 @@ func CreateDmarcReportItem(tenantId string, ruaReport *rua.RUA) models.DmarcRepo
 		Pct:              ruaReport.PolicyPublished.Pct,
 		Np:               ruaReport.PolicyPublished.Np,
 	}
+	SetReportKeys(&item)
+	return item
 }
 
-// CreateDmarcRecordItems creates DMARC record items from the RUA report
+// CreateDmarcRecordItems creates DMARC record items, in the partition of their report, from
+// the RUA report
 func CreateDmarcRecordItems(dmarcReportItem models.DmarcReportMetadataItem, ruaReport *rua.RUA) []models.DmarcRecordItem {
 	var dmarcRecordItems []models.DmarcRecordItem
 	for i, record := range ruaReport.Records {
@@ -49,9 +53,8 @@ func CreateDmarcRecordItems(dmarcReportItem models.DmarcReportMetadataItem, ruaR
 			})
 		}
 
-		dmarcRecordItems = append(dmarcRecordItems, models.DmarcRecordItem{
-			ID:                         fmt.Sprintf("%s#%d", dmarcReportItem.ID, i),
-			ReportId:                   dmarcReportItem.ReportId,
+		item := models.DmarcRecordItem{
+			RecordIndex:                i,
 			SourceIp:                   record.Row.SourceIp.String(),
 			Count:                      record.Row.Count,
 			PolicyEvaluatedDisposition: record.Row.PolicyEvaluated.Disposition,
@@ -63,7 +66,9 @@ func CreateDmarcRecordItems(dmarcReportItem models.DmarcReportMetadataItem, ruaR
 				Domain: record.AuthResults.Spf.Domain,
 				Result: record.AuthResults.Spf.Result,
 			},
-		})
+		}
+		SetRecordKeys(dmarcReportItem, &item)
+		dmarcRecordItems = append(dmarcRecordItems, item)
 	}
 	return dmarcRecordItems
 }
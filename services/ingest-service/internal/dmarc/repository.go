@@ -0,0 +1,237 @@
+package dmarc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+// Global secondary indexes of the DMARC table.  Every index is sorted on sk.
+const (
+	// TenantIndex is partitioned on tenantId, and only contains reports
+	TenantIndex = "TenantIndex"
+
+	// ReporterIndex is partitioned on reporterKey, and only contains reports
+	ReporterIndex = "ReporterIndex"
+
+	// SourceIPIndex is partitioned on sourceIpKey, and only contains records
+	SourceIPIndex = "SourceIPIndex"
+)
+
+// ErrReportNotFound is returned by GetReport when the tenant has no report with the key.
+var ErrReportNotFound = errors.New("report not found")
+
+// ErrInvalidCursor is returned when a cursor was not returned by the same query.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions controls the pages returned by a list query.
+type ListOptions struct {
+	// Limit is the most items to return in the page, or zero for the default page size
+	Limit int
+
+	// Cursor continues from the end of a previous page
+	Cursor string
+}
+
+// Page is a single page of a list query.
+type Page[T any] struct {
+	Items []T
+
+	// Cursor returns the next page when passed in ListOptions, and is empty on the last page
+	Cursor string
+}
+
+// ReportRepository reads the stored DMARC reports and their records.  Every list query
+// returns the items whose report's date range begins between from and to inclusive, oldest
+// first.
+type ReportRepository interface {
+	// ListReports returns the tenant's reports for a domain.  An empty domain lists the
+	// reports for every domain.
+	ListReports(ctx context.Context, tenantID string, domain string, from time.Time, to time.Time, opts ListOptions) (Page[models.DmarcReportMetadataItem], error)
+
+	// ListReportsByReporter returns the tenant's reports from a reporting organization.
+	ListReportsByReporter(ctx context.Context, tenantID string, orgName string, from time.Time, to time.Time, opts ListOptions) (Page[models.DmarcReportMetadataItem], error)
+
+	// GetReport returns a single report, or ErrReportNotFound.
+	GetReport(ctx context.Context, key ReportKey) (*models.DmarcReportMetadataItem, error)
+
+	// ListRecords returns the records of a report in the order they appeared in it.
+	ListRecords(ctx context.Context, key ReportKey, opts ListOptions) (Page[models.DmarcRecordItem], error)
+
+	// ListRecordsBySourceIP returns the tenant's records for mail sent from a source IP.
+	ListRecordsBySourceIP(ctx context.Context, tenantID string, sourceIP string, from time.Time, to time.Time, opts ListOptions) (Page[models.DmarcRecordItem], error)
+}
+
+// DynamoDBReportRepository is a ReportRepository backed by the DMARC table.
+type DynamoDBReportRepository struct {
+	client    aws.TableReader
+	tableName string
+}
+
+var _ ReportRepository = (*DynamoDBReportRepository)(nil)
+
+// NewDynamoDBReportRepository returns a ReportRepository reading from the DMARC table.
+func NewDynamoDBReportRepository(client aws.TableReader, tableName string) *DynamoDBReportRepository {
+	return &DynamoDBReportRepository{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+func (r *DynamoDBReportRepository) ListReports(ctx context.Context, tenantID string, domain string, from time.Time, to time.Time, opts ListOptions) (Page[models.DmarcReportMetadataItem], error) {
+	if domain == "" {
+		return query[models.DmarcReportMetadataItem](ctx, r.client, r.timeRangeQuery(TenantIndex, "tenantId", tenantID, reportSortKeyPrefix, from, to), opts)
+	}
+	return query[models.DmarcReportMetadataItem](ctx, r.client, r.timeRangeQuery("", "pk", partitionKey(tenantID, domain), reportSortKeyPrefix, from, to), opts)
+}
+
+func (r *DynamoDBReportRepository) ListReportsByReporter(ctx context.Context, tenantID string, orgName string, from time.Time, to time.Time, opts ListOptions) (Page[models.DmarcReportMetadataItem], error) {
+	return query[models.DmarcReportMetadataItem](ctx, r.client, r.timeRangeQuery(ReporterIndex, "reporterKey", reporterKey(tenantID, orgName), reportSortKeyPrefix, from, to), opts)
+}
+
+func (r *DynamoDBReportRepository) GetReport(ctx context.Context, key ReportKey) (*models.DmarcReportMetadataItem, error) {
+	item, err := r.client.DynamoDBGetItem(ctx, r.tableName, map[string]dynamodbTypes.AttributeValue{
+		"pk": &dynamodbTypes.AttributeValueMemberS{Value: key.partitionKey()},
+		"sk": &dynamodbTypes.AttributeValueMemberS{Value: key.sortKey()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, fmt.Errorf("%s: %w", key.ReportID, ErrReportNotFound)
+	}
+
+	var report models.DmarcReportMetadataItem
+	if err := attributevalue.UnmarshalMap(item, &report); err != nil {
+		return nil, fmt.Errorf("error unmarshalling DmarcReportItem: %w", err)
+	}
+	return &report, nil
+}
+
+func (r *DynamoDBReportRepository) ListRecords(ctx context.Context, key ReportKey, opts ListOptions) (Page[models.DmarcRecordItem], error) {
+	return query[models.DmarcRecordItem](ctx, r.client, aws.QueryInput{
+		TableName:                r.tableName,
+		KeyConditionExpression:   "#partition = :partition AND begins_with(#sort, :prefix)",
+		ExpressionAttributeNames: map[string]string{"#partition": "pk", "#sort": "sk"},
+		ExpressionAttributeValues: map[string]dynamodbTypes.AttributeValue{
+			":partition": &dynamodbTypes.AttributeValueMemberS{Value: key.partitionKey()},
+			":prefix":    &dynamodbTypes.AttributeValueMemberS{Value: key.recordSortKeyPrefix()},
+		},
+	}, opts)
+}
+
+func (r *DynamoDBReportRepository) ListRecordsBySourceIP(ctx context.Context, tenantID string, sourceIP string, from time.Time, to time.Time, opts ListOptions) (Page[models.DmarcRecordItem], error) {
+	return query[models.DmarcRecordItem](ctx, r.client, r.timeRangeQuery(SourceIPIndex, "sourceIpKey", sourceIPKey(tenantID, sourceIP), recordSortKeyPrefix, from, to), opts)
+}
+
+// timeRangeQuery returns a query of the table, or of an index when indexName is not empty,
+// for the items in a partition with a sort key prefix whose report's date range begins
+// between from and to inclusive.
+func (r *DynamoDBReportRepository) timeRangeQuery(indexName string, partitionAttribute string, partition string, prefix string, from time.Time, to time.Time) aws.QueryInput {
+	lower, upper := timeRange(prefix, from.Unix(), to.Unix())
+	return aws.QueryInput{
+		TableName:                r.tableName,
+		IndexName:                indexName,
+		KeyConditionExpression:   "#partition = :partition AND #sort BETWEEN :from AND :to",
+		ExpressionAttributeNames: map[string]string{"#partition": partitionAttribute, "#sort": "sk"},
+		ExpressionAttributeValues: map[string]dynamodbTypes.AttributeValue{
+			":partition": &dynamodbTypes.AttributeValueMemberS{Value: partition},
+			":from":      &dynamodbTypes.AttributeValueMemberS{Value: lower},
+			":to":        &dynamodbTypes.AttributeValueMemberS{Value: upper},
+		},
+	}
+}
+
+// query runs a single page of a query, unmarshalling the items into T.
+func query[T any](ctx context.Context, client aws.TableReader, input aws.QueryInput, opts ListOptions) (Page[T], error) {
+	startKey, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	input.ExclusiveStartKey = startKey
+	input.Limit = int32(opts.Limit)
+
+	items, lastKey, err := client.DynamoDBQuery(ctx, input)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	page := Page[T]{Items: make([]T, 0, len(items))}
+	if err := attributevalue.UnmarshalListOfMaps(items, &page.Items); err != nil {
+		return Page[T]{}, fmt.Errorf("error unmarshalling items: %w", err)
+	}
+	if page.Cursor, err = encodeCursor(lastKey); err != nil {
+		return Page[T]{}, err
+	}
+
+	return page, nil
+}
+
+// cursorValue is a single attribute of the key encoded in a cursor.  Keys only contain string
+// and number attributes.
+type cursorValue struct {
+	S *string `json:"s,omitempty"`
+	N *string `json:"n,omitempty"`
+}
+
+// encodeCursor encodes the key a query continues from as an opaque cursor.
+func encodeCursor(key map[string]dynamodbTypes.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+
+	values := make(map[string]cursorValue, len(key))
+	for name, value := range key {
+		switch v := value.(type) {
+		case *dynamodbTypes.AttributeValueMemberS:
+			values[name] = cursorValue{S: &v.Value}
+		case *dynamodbTypes.AttributeValueMemberN:
+			values[name] = cursorValue{N: &v.Value}
+		default:
+			return "", fmt.Errorf("unsupported key attribute %s of type %T", name, value)
+		}
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("error encoding cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor decodes a cursor into the key a query continues from.
+func decodeCursor(cursor string) (map[string]dynamodbTypes.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var values map[string]cursorValue
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	key := make(map[string]dynamodbTypes.AttributeValue, len(values))
+	for name, value := range values {
+		switch {
+		case value.S != nil:
+			key[name] = &dynamodbTypes.AttributeValueMemberS{Value: *value.S}
+		case value.N != nil:
+			key[name] = &dynamodbTypes.AttributeValueMemberN{Value: *value.N}
+		default:
+			return nil, ErrInvalidCursor
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,273 @@
+package dmarc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+// fakeTableReader returns the configured items and records the last query.
+type fakeTableReader struct {
+	items   []map[string]dynamodbTypes.AttributeValue
+	lastKey map[string]dynamodbTypes.AttributeValue
+	query   aws.QueryInput
+}
+
+func (f *fakeTableReader) DynamoDBGetItem(ctx context.Context, tableName string, key map[string]dynamodbTypes.AttributeValue) (map[string]dynamodbTypes.AttributeValue, error) {
+	if len(f.items) == 0 {
+		return nil, nil
+	}
+	return f.items[0], nil
+}
+
+func (f *fakeTableReader) DynamoDBQuery(ctx context.Context, input aws.QueryInput) ([]map[string]dynamodbTypes.AttributeValue, map[string]dynamodbTypes.AttributeValue, error) {
+	f.query = input
+	return f.items, f.lastKey, nil
+}
+
+func testReport(t *testing.T, tenantID, domain, reportID string, begin int64) (models.DmarcReportMetadataItem, map[string]dynamodbTypes.AttributeValue) {
+	t.Helper()
+
+	report := models.DmarcReportMetadataItem{
+		ReportId:       reportID,
+		TenantID:       tenantID,
+		OrgName:        "google.com",
+		Domain:         domain,
+		DateRangeBegin: begin,
+		DateRangeEnd:   begin + 86399,
+	}
+	SetReportKeys(&report)
+	item, err := attributevalue.MarshalMap(report)
+	if err != nil {
+		t.Fatalf("error marshalling report: %v", err)
+	}
+	return report, item
+}
+
+func TestDynamoDBReportRepositoryListReports(t *testing.T) {
+	from, to := time.Unix(1722470400, 0), time.Unix(1722556800, 0)
+	_, item := testReport(t, "tenant-a", "example.com", "1", 1722470400)
+	lastKey := map[string]dynamodbTypes.AttributeValue{
+		"pk":       &dynamodbTypes.AttributeValueMemberS{Value: "tenant-a#example.com"},
+		"sk":       &dynamodbTypes.AttributeValueMemberS{Value: "REPORT#1722470400#1"},
+		"tenantId": &dynamodbTypes.AttributeValueMemberS{Value: "tenant-a"},
+	}
+
+	tests := []struct {
+		name      string
+		list      func(*DynamoDBReportRepository, ListOptions) (Page[models.DmarcReportMetadataItem], error)
+		index     string
+		partition string
+	}{
+		{
+			name: "All domains",
+			list: func(r *DynamoDBReportRepository, opts ListOptions) (Page[models.DmarcReportMetadataItem], error) {
+				return r.ListReports(context.Background(), "tenant-a", "", from, to, opts)
+			},
+			index:     TenantIndex,
+			partition: "tenant-a",
+		},
+		{
+			name: "Single domain",
+			list: func(r *DynamoDBReportRepository, opts ListOptions) (Page[models.DmarcReportMetadataItem], error) {
+				return r.ListReports(context.Background(), "tenant-a", "example.com", from, to, opts)
+			},
+			partition: "tenant-a#example.com",
+		},
+		{
+			name: "Reporter",
+			list: func(r *DynamoDBReportRepository, opts ListOptions) (Page[models.DmarcReportMetadataItem], error) {
+				return r.ListReportsByReporter(context.Background(), "tenant-a", "google.com", from, to, opts)
+			},
+			index:     ReporterIndex,
+			partition: "tenant-a#google.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeTableReader{items: []map[string]dynamodbTypes.AttributeValue{item}, lastKey: lastKey}
+			repository := NewDynamoDBReportRepository(client, "dmarc")
+
+			page, err := tt.list(repository, ListOptions{Limit: 1})
+			if err != nil {
+				t.Fatalf("list error = %v", err)
+			}
+
+			if client.query.IndexName != tt.index || client.query.Limit != 1 {
+				t.Errorf("query index = %q limit = %d, expected %q limit 1", client.query.IndexName, client.query.Limit, tt.index)
+			}
+			if partition := client.query.ExpressionAttributeValues[":partition"].(*dynamodbTypes.AttributeValueMemberS).Value; partition != tt.partition {
+				t.Errorf("query partition = %s, expected %s", partition, tt.partition)
+			}
+			lower := client.query.ExpressionAttributeValues[":from"].(*dynamodbTypes.AttributeValueMemberS).Value
+			upper := client.query.ExpressionAttributeValues[":to"].(*dynamodbTypes.AttributeValueMemberS).Value
+			if lower != "REPORT#1722470400" || upper != "REPORT#1722556801" {
+				t.Errorf("query sort key range = %s to %s, expected REPORT#1722470400 to REPORT#1722556801", lower, upper)
+			}
+			if len(page.Items) != 1 || page.Items[0].ReportId != "1" {
+				t.Errorf("page items = %+v, expected report 1", page.Items)
+			}
+
+			// The cursor continues the query from the last key
+			if _, err := tt.list(repository, ListOptions{Cursor: page.Cursor}); err != nil {
+				t.Fatalf("list with cursor error = %v", err)
+			}
+			if !reflect.DeepEqual(client.query.ExclusiveStartKey, lastKey) {
+				t.Errorf("query start key = %v, expected %v", client.query.ExclusiveStartKey, lastKey)
+			}
+		})
+	}
+}
+
+func TestDynamoDBReportRepositoryListRecords(t *testing.T) {
+	client := &fakeTableReader{}
+	repository := NewDynamoDBReportRepository(client, "dmarc")
+
+	key := ReportKey{TenantID: "tenant-a", Domain: "example.com", DateRangeBegin: 1722470400, ReportID: "1"}
+	if _, err := repository.ListRecords(context.Background(), key, ListOptions{}); err != nil {
+		t.Fatalf("ListRecords() error = %v", err)
+	}
+	if prefix := client.query.ExpressionAttributeValues[":prefix"].(*dynamodbTypes.AttributeValueMemberS).Value; prefix != "RECORD#1722470400#1#" {
+		t.Errorf("query prefix = %s, expected RECORD#1722470400#1#", prefix)
+	}
+
+	from, to := time.Unix(1722470400, 0), time.Unix(1722556800, 0)
+	if _, err := repository.ListRecordsBySourceIP(context.Background(), "tenant-a", "192.0.2.1", from, to, ListOptions{}); err != nil {
+		t.Fatalf("ListRecordsBySourceIP() error = %v", err)
+	}
+	if client.query.IndexName != SourceIPIndex {
+		t.Errorf("query index = %q, expected %q", client.query.IndexName, SourceIPIndex)
+	}
+	if partition := client.query.ExpressionAttributeValues[":partition"].(*dynamodbTypes.AttributeValueMemberS).Value; partition != "tenant-a#192.0.2.1" {
+		t.Errorf("query partition = %s, expected tenant-a#192.0.2.1", partition)
+	}
+	if lower := client.query.ExpressionAttributeValues[":from"].(*dynamodbTypes.AttributeValueMemberS).Value; lower != "RECORD#1722470400" {
+		t.Errorf("query lower bound = %s, expected RECORD#1722470400", lower)
+	}
+}
+
+func TestDynamoDBReportRepositoryGetReport(t *testing.T) {
+	report, item := testReport(t, "tenant-a", "example.com", "1", 1722470400)
+
+	repository := NewDynamoDBReportRepository(&fakeTableReader{items: []map[string]dynamodbTypes.AttributeValue{item}}, "dmarc")
+	got, err := repository.GetReport(context.Background(), KeyOf(report))
+	if err != nil {
+		t.Fatalf("GetReport() error = %v", err)
+	}
+	if got.PK != report.PK || got.SK != report.SK {
+		t.Errorf("GetReport() = %+v, expected %+v", got, report)
+	}
+
+	repository = NewDynamoDBReportRepository(&fakeTableReader{}, "dmarc")
+	if _, err := repository.GetReport(context.Background(), KeyOf(report)); !errors.Is(err, ErrReportNotFound) {
+		t.Errorf("GetReport() error = %v, expected %v", err, ErrReportNotFound)
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	tests := []struct {
+		name      string
+		cursor    string
+		expectErr bool
+	}{
+		{name: "Empty cursor"},
+		{name: "Not base64", cursor: "not a cursor!", expectErr: true},
+		{name: "Not JSON", cursor: "bm90IGpzb24", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := decodeCursor(tt.cursor)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("decodeCursor() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if key != nil {
+				t.Errorf("decodeCursor() = %v, expected nil", key)
+			}
+		})
+	}
+}
+
+func TestMemoryReportRepository(t *testing.T) {
+	repository := NewMemoryReportRepository()
+	for i, begin := range []int64{1722556800, 1722470400, 1722643200} {
+		report, _ := testReport(t, "tenant-a", "example.com", string(rune('a'+i)), begin)
+		repository.PutReport(report, nil)
+	}
+	other, _ := testReport(t, "tenant-a", "example.org", "d", 1722470400)
+	other.OrgName = "yahoo.com"
+	repository.PutReport(other, []models.DmarcRecordItem{
+		{RecordIndex: 1, SourceIp: "192.0.2.1"},
+		{RecordIndex: 0, SourceIp: "192.0.2.2"},
+	})
+	foreign, _ := testReport(t, "tenant-b", "example.com", "e", 1722470400)
+	repository.PutReport(foreign, []models.DmarcRecordItem{{RecordIndex: 0, SourceIp: "192.0.2.1"}})
+
+	ctx := context.Background()
+	from, to := time.Unix(1722470400, 0), time.Unix(1722556800, 0)
+
+	var ids []string
+	opts := ListOptions{Limit: 1}
+	for {
+		page, err := repository.ListReports(ctx, "tenant-a", "example.com", from, to, opts)
+		if err != nil {
+			t.Fatalf("ListReports() error = %v", err)
+		}
+		for _, report := range page.Items {
+			ids = append(ids, report.ReportId)
+		}
+		if page.Cursor == "" {
+			break
+		}
+		opts.Cursor = page.Cursor
+	}
+	if expected := []string{"b", "a"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("ListReports() = %v, expected %v", ids, expected)
+	}
+
+	page, err := repository.ListReports(ctx, "tenant-a", "", from, to, ListOptions{})
+	if err != nil {
+		t.Fatalf("ListReports() error = %v", err)
+	}
+	if len(page.Items) != 3 {
+		t.Errorf("ListReports() for every domain returned %d reports, expected 3", len(page.Items))
+	}
+
+	page, err = repository.ListReportsByReporter(ctx, "tenant-a", "yahoo.com", from, to, ListOptions{})
+	if err != nil {
+		t.Fatalf("ListReportsByReporter() error = %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].ReportId != "d" {
+		t.Errorf("ListReportsByReporter() = %+v, expected report d", page.Items)
+	}
+
+	records, err := repository.ListRecords(ctx, KeyOf(other), ListOptions{})
+	if err != nil {
+		t.Fatalf("ListRecords() error = %v", err)
+	}
+	if len(records.Items) != 2 || records.Items[0].RecordIndex != 0 {
+		t.Errorf("ListRecords() = %+v, expected records in order", records.Items)
+	}
+
+	records, err = repository.ListRecordsBySourceIP(ctx, "tenant-a", "192.0.2.1", from, to, ListOptions{})
+	if err != nil {
+		t.Fatalf("ListRecordsBySourceIP() error = %v", err)
+	}
+	if len(records.Items) != 1 || records.Items[0].ReportId != "d" {
+		t.Errorf("ListRecordsBySourceIP() = %+v, expected the record of report d", records.Items)
+	}
+
+	key := KeyOf(foreign)
+	key.TenantID = "tenant-a"
+	if _, err := repository.GetReport(ctx, key); !errors.Is(err, ErrReportNotFound) {
+		t.Errorf("GetReport() for another tenant error = %v, expected %v", err, ErrReportNotFound)
+	}
+}
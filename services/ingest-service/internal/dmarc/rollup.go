@@ -0,0 +1,123 @@
+package dmarc
+
+import (
+	"fmt"
+
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+// Results and dispositions of the policy evaluated for a record, as they appear in RUA reports
+const (
+	resultPass            = "pass"
+	dispositionNone       = "none"
+	dispositionQuarantine = "quarantine"
+	dispositionReject     = "reject"
+)
+
+// CreateDmarcRollupItems totals the records of a report into daily rollups, with their table
+// keys: one for each source IP in the report, followed by one for the reporter.  The records
+// are counted on the day their report's date range begins, and the rollups record the
+// report's sort key as the report they are added from.
+func CreateDmarcRollupItems(report models.DmarcReportMetadataItem, records []models.DmarcRecordItem) []models.DmarcRollupItem {
+	day := dayKey(report.DateRangeBegin)
+	newRollup := func(sortKey string) *models.DmarcRollupItem {
+		return &models.DmarcRollupItem{
+			PK:     partitionKey(report.TenantID, report.Domain),
+			SK:     sortKey,
+			Domain: report.Domain,
+			Day:    day,
+			Report: report.SK,
+		}
+	}
+
+	reporter := newRollup(fmt.Sprintf("%s%s#%s", reporterRollupSortKeyPrefix, day, report.OrgName))
+	reporter.OrgName = report.OrgName
+
+	var sourceIPs []string
+	sources := map[string]*models.DmarcRollupItem{}
+	for _, record := range records {
+		source, ok := sources[record.SourceIp]
+		if !ok {
+			source = newRollup(fmt.Sprintf("%s%s#%s", sourceRollupSortKeyPrefix, day, record.SourceIp))
+			source.SourceIp = record.SourceIp
+			sources[record.SourceIp] = source
+			sourceIPs = append(sourceIPs, record.SourceIp)
+		}
+		addToRollup(source, record)
+		addToRollup(reporter, record)
+	}
+
+	rollups := make([]models.DmarcRollupItem, 0, len(sourceIPs)+1)
+	for _, sourceIP := range sourceIPs {
+		rollups = append(rollups, *sources[sourceIP])
+	}
+	return append(rollups, *reporter)
+}
+
+// RollupUpdate returns the update adding the counters of a rollup item, which counts a single
+// report as created by CreateDmarcRollupItems, to the rollup stored in the table.  The report
+// is recorded by a marker item written with the update, so the update is not applied again
+// when the report is redelivered.
+func RollupUpdate(rollup models.DmarcRollupItem) aws.CounterUpdate {
+	attributes := map[string]dynamodbTypes.AttributeValue{
+		"domain": &dynamodbTypes.AttributeValueMemberS{Value: rollup.Domain},
+		"day":    &dynamodbTypes.AttributeValueMemberS{Value: rollup.Day},
+	}
+	if rollup.SourceIp != "" {
+		attributes["sourceIp"] = &dynamodbTypes.AttributeValueMemberS{Value: rollup.SourceIp}
+	}
+	if rollup.OrgName != "" {
+		attributes["orgName"] = &dynamodbTypes.AttributeValueMemberS{Value: rollup.OrgName}
+	}
+
+	return aws.CounterUpdate{
+		Key: map[string]dynamodbTypes.AttributeValue{
+			"pk": &dynamodbTypes.AttributeValueMemberS{Value: rollup.PK},
+			"sk": &dynamodbTypes.AttributeValueMemberS{Value: rollup.SK},
+		},
+		Attributes: attributes,
+		Counters: map[string]int64{
+			"messages":              rollup.Messages,
+			"dkimPass":              rollup.DKIMPass,
+			"spfPass":               rollup.SPFPass,
+			"fail":                  rollup.Fail,
+			"dispositionNone":       rollup.DispositionNone,
+			"dispositionQuarantine": rollup.DispositionQuarantine,
+			"dispositionReject":     rollup.DispositionReject,
+		},
+		Marker: map[string]dynamodbTypes.AttributeValue{
+			"pk": &dynamodbTypes.AttributeValueMemberS{Value: rollup.PK},
+			"sk": &dynamodbTypes.AttributeValueMemberS{Value: fmt.Sprintf("%s%s#%s", rollupMarkerSortKeyPrefix, rollup.SK, rollup.Report)},
+		},
+	}
+}
+
+// addToRollup adds the messages of a record to the counters of a rollup.  Messages pass DMARC
+// when either DKIM or SPF passes aligned, so they fail only when neither does.
+func addToRollup(rollup *models.DmarcRollupItem, record models.DmarcRecordItem) {
+	count := int64(record.Count)
+	dkimPass := record.PolicyEvaluatedDkim == resultPass
+	spfPass := record.PolicyEvaluatedSpf == resultPass
+
+	rollup.Messages += count
+	if dkimPass {
+		rollup.DKIMPass += count
+	}
+	if spfPass {
+		rollup.SPFPass += count
+	}
+	if !dkimPass && !spfPass {
+		rollup.Fail += count
+	}
+
+	switch record.PolicyEvaluatedDisposition {
+	case dispositionNone:
+		rollup.DispositionNone += count
+	case dispositionQuarantine:
+		rollup.DispositionQuarantine += count
+	case dispositionReject:
+		rollup.DispositionReject += count
+	}
+}
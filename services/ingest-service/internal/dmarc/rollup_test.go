@@ -0,0 +1,70 @@
+package dmarc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+func TestCreateDmarcRollupItems(t *testing.T) {
+	report := models.DmarcReportMetadataItem{
+		ReportId:       "1111",
+		TenantID:       "tenant-a",
+		OrgName:        "google.com",
+		Domain:         "example.com",
+		DateRangeBegin: 1722470400,
+	}
+	SetReportKeys(&report)
+
+	records := []models.DmarcRecordItem{
+		{SourceIp: "192.0.2.1", Count: 5, PolicyEvaluatedDkim: "pass", PolicyEvaluatedSpf: "pass", PolicyEvaluatedDisposition: "none"},
+		{SourceIp: "192.0.2.2", Count: 2, PolicyEvaluatedDkim: "fail", PolicyEvaluatedSpf: "fail", PolicyEvaluatedDisposition: "reject"},
+		{SourceIp: "192.0.2.1", Count: 3, PolicyEvaluatedDkim: "fail", PolicyEvaluatedSpf: "pass", PolicyEvaluatedDisposition: "none"},
+		{SourceIp: "192.0.2.2", Count: 1, PolicyEvaluatedDkim: "fail", PolicyEvaluatedSpf: "fail", PolicyEvaluatedDisposition: "quarantine"},
+	}
+
+	expected := []models.DmarcRollupItem{
+		{
+			SK:              "DAILY#SOURCE#2024-08-01#192.0.2.1",
+			SourceIp:        "192.0.2.1",
+			Messages:        8,
+			DKIMPass:        5,
+			SPFPass:         8,
+			DispositionNone: 8,
+		},
+		{
+			SK:                    "DAILY#SOURCE#2024-08-01#192.0.2.2",
+			SourceIp:              "192.0.2.2",
+			Messages:              3,
+			Fail:                  3,
+			DispositionQuarantine: 1,
+			DispositionReject:     2,
+		},
+		{
+			SK:                    "DAILY#REPORTER#2024-08-01#google.com",
+			OrgName:               "google.com",
+			Messages:              11,
+			DKIMPass:              5,
+			SPFPass:               8,
+			Fail:                  3,
+			DispositionNone:       8,
+			DispositionQuarantine: 1,
+			DispositionReject:     2,
+		},
+	}
+
+	rollups := CreateDmarcRollupItems(report, records)
+	if len(rollups) != len(expected) {
+		t.Fatalf("expected %d rollups, got %d", len(expected), len(rollups))
+	}
+	for i := range expected {
+		expected[i].PK = "tenant-a#example.com"
+		expected[i].Domain = "example.com"
+		expected[i].Day = "2024-08-01"
+		expected[i].Report = report.SK
+		if !reflect.DeepEqual(rollups[i], expected[i]) {
+			t.Errorf("rollup %d = %+v, expected %+v", i, rollups[i], expected[i])
+		}
+	}
+}
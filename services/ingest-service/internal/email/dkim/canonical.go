@@ -0,0 +1,98 @@
+package dkim
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Canonicalization is a DKIM canonicalization algorithm, as defined in RFC 6376 section 3.4.
+type Canonicalization string
+
+const (
+	CanonicalizationSimple  Canonicalization = "simple"
+	CanonicalizationRelaxed Canonicalization = "relaxed"
+)
+
+// parseCanonicalization parses the c= tag into its header and body algorithms.  Both default
+// to simple, and a missing body algorithm defaults to simple.
+func parseCanonicalization(s string) (header, body Canonicalization, err error) {
+	header, body = CanonicalizationSimple, CanonicalizationSimple
+	if s == "" {
+		return header, body, nil
+	}
+
+	h, b, found := strings.Cut(s, "/")
+	header = Canonicalization(strings.ToLower(h))
+	if found {
+		body = Canonicalization(strings.ToLower(b))
+	}
+
+	for _, c := range []Canonicalization{header, body} {
+		if c != CanonicalizationSimple && c != CanonicalizationRelaxed {
+			return "", "", fmt.Errorf("%w: unknown canonicalization %q", ErrMalformedSignature, c)
+		}
+	}
+	return header, body, nil
+}
+
+// CanonicalizeHeader canonicalizes a raw header field, including its terminating CRLF.
+func CanonicalizeHeader(raw string, c Canonicalization) string {
+	if c != CanonicalizationRelaxed {
+		return raw
+	}
+
+	name, value, _ := strings.Cut(raw, ":")
+	name = strings.ToLower(strings.TrimRight(name, " \t"))
+
+	// Unfold, then reduce every whitespace sequence to a single space
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+
+	return name + ":" + value + "\r\n"
+}
+
+// CanonicalizeBody canonicalizes a message body.
+func CanonicalizeBody(body []byte, c Canonicalization) []byte {
+	var canonical []byte
+	if c == CanonicalizationRelaxed {
+		lines := bytes.SplitAfter(body, []byte("\r\n"))
+		var buf bytes.Buffer
+		for _, line := range lines {
+			hasCRLF := bytes.HasSuffix(line, []byte("\r\n"))
+			line = bytes.TrimSuffix(line, []byte("\r\n"))
+			fields := bytes.FieldsFunc(line, isWSP)
+			if len(fields) > 0 && isWSP(rune(line[0])) {
+				buf.WriteByte(' ')
+			}
+			buf.Write(bytes.Join(fields, []byte(" ")))
+			if hasCRLF {
+				buf.WriteString("\r\n")
+			}
+		}
+		canonical = buf.Bytes()
+	} else {
+		canonical = append([]byte(nil), body...)
+	}
+
+	// Ignore all empty lines at the end of the body
+	for bytes.HasSuffix(canonical, []byte("\r\n\r\n")) {
+		canonical = canonical[:len(canonical)-2]
+	}
+	if len(canonical) > 0 && !bytes.HasSuffix(canonical, []byte("\r\n")) {
+		canonical = append(canonical, '\r', '\n')
+	}
+
+	// The simple algorithm treats an empty body as a single CRLF, the relaxed one as empty
+	if c == CanonicalizationSimple && len(canonical) == 0 {
+		canonical = []byte("\r\n")
+	}
+	if c == CanonicalizationRelaxed && bytes.Equal(canonical, []byte("\r\n")) {
+		canonical = nil
+	}
+	return canonical
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
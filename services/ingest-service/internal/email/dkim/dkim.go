@@ -0,0 +1,290 @@
+// Package dkim verifies DKIM signatures (RFC 6376) on raw email messages.  Both the
+// rsa-sha256 and ed25519-sha256 (RFC 8463) algorithms are supported.
+package dkim
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AlgorithmRSASHA256     = "rsa-sha256"
+	AlgorithmEd25519SHA256 = "ed25519-sha256"
+)
+
+// maxSignatures is the maximum number of signatures verified on a single message, to
+// bound the work (and DNS lookups) a single message can cause.
+const maxSignatures = 10
+
+var (
+	ErrMalformedSignature   = errors.New("malformed signature")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrMalformedKey         = errors.New("malformed key record")
+	ErrKeyNotFound          = errors.New("key not found")
+	ErrKeyRevoked           = errors.New("key revoked")
+	ErrSignatureExpired     = errors.New("signature expired")
+	ErrBodyHashMismatch     = errors.New("body hash mismatch")
+	ErrSignatureMismatch    = errors.New("signature mismatch")
+)
+
+// now returns the current time, and is overridden in tests.
+var now = time.Now
+
+// Status is the outcome of verifying a signature, using the result names of RFC 8601.
+type Status string
+
+const (
+	StatusPass      Status = "pass"
+	StatusFail      Status = "fail"
+	StatusPermError Status = "permerror"
+	StatusTempError Status = "temperror"
+)
+
+// Result is the outcome of verifying a single DKIM-Signature header field.
+type Result struct {
+	Status    Status
+	Domain    string
+	Selector  string
+	Algorithm string
+	Err       error
+}
+
+// Signature is a parsed DKIM-Signature header field.  ARC-Message-Signature fields share
+// the same format, so the type is also used to verify them.
+type Signature struct {
+	Tags map[string]string
+
+	Version                string
+	Algorithm              string
+	Domain                 string
+	Selector               string
+	HeaderCanonicalization Canonicalization
+	BodyCanonicalization   Canonicalization
+	Headers                []string
+	BodyHash               []byte
+	Signature              []byte
+	BodyLength             int64
+	Expiration             time.Time
+
+	field HeaderField
+}
+
+// Verify verifies every DKIM-Signature header field on the raw message, returning one
+// result per signature in the order they appear.  A message without signatures returns
+// no results.  An error is only returned if the message itself cannot be split into a
+// header and body.
+func Verify(ctx context.Context, raw []byte, resolver Resolver) ([]Result, error) {
+	header, body, err := SplitMessage(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error reading message header: %w", err)
+	}
+
+	fields := header.Fields("DKIM-Signature")
+	if len(fields) > maxSignatures {
+		fields = fields[:maxSignatures]
+	}
+
+	results := make([]Result, 0, len(fields))
+	for _, field := range fields {
+		results = append(results, verifyField(ctx, resolver, header, body, field))
+	}
+	return results, nil
+}
+
+func verifyField(ctx context.Context, resolver Resolver, header Header, body []byte, field HeaderField) Result {
+	sig, err := ParseSignature(field)
+	if err != nil {
+		return Result{Status: StatusPermError, Err: err}
+	}
+
+	result := Result{Domain: sig.Domain, Selector: sig.Selector, Algorithm: sig.Algorithm}
+	if sig.Version != "1" {
+		err = fmt.Errorf("%w: unsupported version %q", ErrMalformedSignature, sig.Version)
+	} else if !sig.signsFrom() {
+		err = fmt.Errorf("%w: From header field is not signed", ErrMalformedSignature)
+	} else {
+		err = sig.Verify(ctx, resolver, header, body)
+	}
+
+	result.Status = StatusFromError(err)
+	result.Err = err
+	return result
+}
+
+// ParseSignature parses a DKIM-Signature or ARC-Message-Signature header field.  The v=
+// tag is not required, as ARC-Message-Signature fields do not carry one.
+func ParseSignature(field HeaderField) (*Signature, error) {
+	tags, err := ParseTagList(field.Value())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+	}
+
+	for _, required := range []string{"a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[required]; !ok {
+			return nil, fmt.Errorf("%w: missing %s= tag", ErrMalformedSignature, required)
+		}
+	}
+
+	sig := &Signature{
+		Tags:       tags,
+		Version:    tags["v"],
+		Algorithm:  strings.ToLower(tags["a"]),
+		Domain:     strings.ToLower(tags["d"]),
+		Selector:   tags["s"],
+		BodyLength: -1,
+		field:      field,
+	}
+
+	sig.HeaderCanonicalization, sig.BodyCanonicalization, err = parseCanonicalization(tags["c"])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range strings.Split(tags["h"], ":") {
+		if name = strings.TrimSpace(name); name != "" {
+			sig.Headers = append(sig.Headers, name)
+		}
+	}
+
+	if sig.BodyHash, err = base64.StdEncoding.DecodeString(removeWhitespace(tags["bh"])); err != nil {
+		return nil, fmt.Errorf("%w: invalid bh= tag: %v", ErrMalformedSignature, err)
+	}
+	if sig.Signature, err = base64.StdEncoding.DecodeString(removeWhitespace(tags["b"])); err != nil {
+		return nil, fmt.Errorf("%w: invalid b= tag: %v", ErrMalformedSignature, err)
+	}
+
+	if l, ok := tags["l"]; ok {
+		if sig.BodyLength, err = strconv.ParseInt(l, 10, 64); err != nil || sig.BodyLength < 0 {
+			return nil, fmt.Errorf("%w: invalid l= tag %q", ErrMalformedSignature, l)
+		}
+	}
+
+	if x, ok := tags["x"]; ok {
+		expiration, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid x= tag %q", ErrMalformedSignature, x)
+		}
+		sig.Expiration = time.Unix(expiration, 0)
+	}
+
+	return sig, nil
+}
+
+// Verify checks the body hash and signature against the message header and body, looking
+// up the signing key through the resolver.
+func (s *Signature) Verify(ctx context.Context, resolver Resolver, header Header, body []byte) error {
+	if s.Algorithm != AlgorithmRSASHA256 && s.Algorithm != AlgorithmEd25519SHA256 {
+		return fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, s.Algorithm)
+	}
+	if !s.Expiration.IsZero() && now().After(s.Expiration) {
+		return ErrSignatureExpired
+	}
+
+	canonicalBody := CanonicalizeBody(body, s.BodyCanonicalization)
+	if s.BodyLength >= 0 {
+		if s.BodyLength > int64(len(canonicalBody)) {
+			return fmt.Errorf("%w: l= tag exceeds body length", ErrMalformedSignature)
+		}
+		canonicalBody = canonicalBody[:s.BodyLength]
+	}
+	bodyHash := sha256.Sum256(canonicalBody)
+	if !bytes.Equal(bodyHash[:], s.BodyHash) {
+		return ErrBodyHashMismatch
+	}
+
+	key, err := resolver.LookupKey(ctx, s.Domain, s.Selector)
+	if err != nil {
+		return err
+	}
+
+	data := SignedHeaderData(header, s.Headers, s.field, s.HeaderCanonicalization)
+	return VerifyData(key, s.Algorithm, data, s.Signature)
+}
+
+// signsFrom reports whether the From header field is included in the signature, which
+// RFC 6376 requires.
+func (s *Signature) signsFrom() bool {
+	for _, name := range s.Headers {
+		if strings.EqualFold(name, "From") {
+			return true
+		}
+	}
+	return false
+}
+
+// SignedHeaderData returns the canonicalized header data covered by a signature: the
+// fields listed in names, selected from the bottom of the header up, followed by the
+// signature field itself with its b= value removed and no trailing CRLF.
+func SignedHeaderData(header Header, names []string, sigField HeaderField, c Canonicalization) []byte {
+	var buf bytes.Buffer
+
+	used := map[int]bool{}
+	for _, name := range names {
+		for i := len(header) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(header[i].Name, name) {
+				continue
+			}
+			used[i] = true
+			buf.WriteString(CanonicalizeHeader(header[i].Raw, c))
+			break
+		}
+	}
+
+	stripped := CanonicalizeHeader(StripSignature(sigField.Raw), c)
+	buf.WriteString(strings.TrimSuffix(stripped, "\r\n"))
+	return buf.Bytes()
+}
+
+// VerifyData verifies a signature over the SHA-256 hash of data with the given algorithm.
+func VerifyData(key crypto.PublicKey, algorithm string, data, signature []byte) error {
+	hashed := sha256.Sum256(data)
+
+	switch algorithm {
+	case AlgorithmRSASHA256:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key is not an RSA key", ErrMalformedKey)
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, hashed[:], signature); err != nil {
+			return ErrSignatureMismatch
+		}
+	case AlgorithmEd25519SHA256:
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key is not an Ed25519 key", ErrMalformedKey)
+		}
+		if !ed25519.Verify(edKey, hashed[:], signature) {
+			return ErrSignatureMismatch
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+
+	return nil
+}
+
+// StatusFromError maps a verification error to its result status.
+func StatusFromError(err error) Status {
+	switch {
+	case err == nil:
+		return StatusPass
+	case errors.Is(err, ErrBodyHashMismatch), errors.Is(err, ErrSignatureMismatch):
+		return StatusFail
+	case errors.Is(err, ErrMalformedSignature), errors.Is(err, ErrUnsupportedAlgorithm),
+		errors.Is(err, ErrMalformedKey), errors.Is(err, ErrKeyNotFound),
+		errors.Is(err, ErrKeyRevoked), errors.Is(err, ErrSignatureExpired):
+		return StatusPermError
+	default:
+		return StatusTempError
+	}
+}
@@ -0,0 +1,360 @@
+package dkim
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = strings.ReplaceAll(`From: Joe SixPack <joe@football.example.com>
+To: Suzie Q <suzie@shopping.example.net>
+Subject: Is dinner ready?
+Date: Fri, 11 Jul 2003 21:00:37 -0700
+Message-ID: <20030712040037.46341.5F8J@football.example.com>
+
+Hi.
+
+We lost the game. Are you hungry yet?
+
+Joe.
+`, "\n", "\r\n")
+
+func TestCanonicalizeHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		c        Canonicalization
+		expected string
+	}{
+		{
+			name:     "Simple is unchanged",
+			raw:      "B : Y\t\r\n\tZ  \r\n",
+			c:        CanonicalizationSimple,
+			expected: "B : Y\t\r\n\tZ  \r\n",
+		},
+		{
+			name:     "Relaxed lowercases the name",
+			raw:      "A: X\r\n",
+			c:        CanonicalizationRelaxed,
+			expected: "a:X\r\n",
+		},
+		{
+			name:     "Relaxed unfolds and compresses whitespace",
+			raw:      "B : Y\t\r\n\tZ  \r\n",
+			c:        CanonicalizationRelaxed,
+			expected: "b:Y Z\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := CanonicalizeHeader(tt.raw, tt.c); result != tt.expected {
+				t.Errorf("CanonicalizeHeader() = %q, expected %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		c        Canonicalization
+		expected string
+	}{
+		{
+			name:     "Simple strips trailing empty lines",
+			body:     " C \r\nD \t E\r\n\r\n\r\n",
+			c:        CanonicalizationSimple,
+			expected: " C \r\nD \t E\r\n",
+		},
+		{
+			name:     "Relaxed compresses whitespace",
+			body:     " C \r\nD \t E\r\n\r\n\r\n",
+			c:        CanonicalizationRelaxed,
+			expected: " C\r\nD E\r\n",
+		},
+		{
+			name:     "Simple empty body",
+			body:     "",
+			c:        CanonicalizationSimple,
+			expected: "\r\n",
+		},
+		{
+			name:     "Relaxed empty body",
+			body:     "\r\n\r\n",
+			c:        CanonicalizationRelaxed,
+			expected: "",
+		},
+		{
+			name:     "Missing final CRLF is added",
+			body:     "Hi.",
+			c:        CanonicalizationRelaxed,
+			expected: "Hi.\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := string(CanonicalizeBody([]byte(tt.body), tt.c)); result != tt.expected {
+				t.Errorf("CanonicalizeBody() = %q, expected %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating RSA key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating Ed25519 key: %v", err)
+	}
+
+	resolver := StaticResolver{
+		"rsa._domainkey.football.example.com":      rsaKey.Public(),
+		"brisbane._domainkey.football.example.com": edKey.Public(),
+	}
+
+	tests := []struct {
+		name     string
+		opts     SignOptions
+		tamper   func(string) string
+		expected Status
+		err      error
+	}{
+		{
+			name:     "RSA relaxed",
+			opts:     SignOptions{Domain: "football.example.com", Selector: "rsa", Signer: rsaKey},
+			expected: StatusPass,
+		},
+		{
+			name:     "Ed25519 relaxed",
+			opts:     SignOptions{Domain: "football.example.com", Selector: "brisbane", Signer: edKey},
+			expected: StatusPass,
+		},
+		{
+			name: "RSA simple",
+			opts: SignOptions{Domain: "football.example.com", Selector: "rsa", Signer: rsaKey,
+				HeaderCanonicalization: CanonicalizationSimple, BodyCanonicalization: CanonicalizationSimple},
+			expected: StatusPass,
+		},
+		{
+			name: "Relaxed tolerates whitespace changes",
+			opts: SignOptions{Domain: "football.example.com", Selector: "brisbane", Signer: edKey},
+			tamper: func(m string) string {
+				m = strings.Replace(m, "Subject: Is dinner ready?", "subject:   Is dinner\r\n ready?", 1)
+				return strings.Replace(m, "We lost the game.", "We lost the game.  \t", 1) + "\r\n\r\n"
+			},
+			expected: StatusPass,
+		},
+		{
+			name: "Simple rejects whitespace changes",
+			opts: SignOptions{Domain: "football.example.com", Selector: "rsa", Signer: rsaKey,
+				HeaderCanonicalization: CanonicalizationSimple, BodyCanonicalization: CanonicalizationSimple},
+			tamper: func(m string) string {
+				return strings.Replace(m, "We lost the game.", "We lost the game. ", 1)
+			},
+			expected: StatusFail,
+			err:      ErrBodyHashMismatch,
+		},
+		{
+			name: "Modified body",
+			opts: SignOptions{Domain: "football.example.com", Selector: "rsa", Signer: rsaKey},
+			tamper: func(m string) string {
+				return strings.Replace(m, "We lost the game.", "We won the game.", 1)
+			},
+			expected: StatusFail,
+			err:      ErrBodyHashMismatch,
+		},
+		{
+			name: "Modified header",
+			opts: SignOptions{Domain: "football.example.com", Selector: "brisbane", Signer: edKey},
+			tamper: func(m string) string {
+				return strings.Replace(m, "Is dinner ready?", "Is lunch ready?", 1)
+			},
+			expected: StatusFail,
+			err:      ErrSignatureMismatch,
+		},
+		{
+			name:     "Unknown selector",
+			opts:     SignOptions{Domain: "football.example.com", Selector: "unknown", Signer: rsaKey},
+			expected: StatusPermError,
+			err:      ErrKeyNotFound,
+		},
+		{
+			name:     "Key does not match algorithm",
+			opts:     SignOptions{Domain: "football.example.com", Selector: "rsa", Signer: edKey},
+			expected: StatusPermError,
+			err:      ErrMalformedKey,
+		},
+		{
+			name: "Expired signature",
+			opts: SignOptions{Domain: "football.example.com", Selector: "rsa", Signer: rsaKey,
+				Expiration: time.Now().Add(-time.Hour)},
+			expected: StatusPermError,
+			err:      ErrSignatureExpired,
+		},
+		{
+			name: "From not signed",
+			opts: SignOptions{Domain: "football.example.com", Selector: "rsa", Signer: rsaKey,
+				Headers: []string{"Subject"}},
+			expected: StatusPermError,
+			err:      ErrMalformedSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, err := Sign([]byte(testMessage), tt.opts)
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+			raw := string(signed)
+			if tt.tamper != nil {
+				raw = tt.tamper(raw)
+			}
+
+			results, err := Verify(context.Background(), []byte(raw), resolver)
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("expected 1 result, got %d", len(results))
+			}
+
+			result := results[0]
+			if result.Status != tt.expected {
+				t.Errorf("Verify() status = %s, expected %s (err: %v)", result.Status, tt.expected, result.Err)
+			}
+			if tt.err != nil && !errors.Is(result.Err, tt.err) {
+				t.Errorf("Verify() err = %v, expected %v", result.Err, tt.err)
+			}
+			if result.Domain != tt.opts.Domain || result.Selector != tt.opts.Selector {
+				t.Errorf("Verify() domain/selector = %s/%s, expected %s/%s", result.Domain, result.Selector, tt.opts.Domain, tt.opts.Selector)
+			}
+		})
+	}
+}
+
+func TestVerifyMultipleSignatures(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating Ed25519 key: %v", err)
+	}
+	resolver := StaticResolver{"s1._domainkey.example.org": edKey.Public()}
+
+	signed, err := Sign([]byte(testMessage), SignOptions{Domain: "example.org", Selector: "s1", Signer: edKey})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	signed, err = Sign(signed, SignOptions{Domain: "example.com", Selector: "s2", Signer: edKey})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	results, err := Verify(context.Background(), signed, resolver)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Domain != "example.com" || results[0].Status != StatusPermError {
+		t.Errorf("unexpected first result: %+v", results[0])
+	}
+	if results[1].Domain != "example.org" || results[1].Status != StatusPass {
+		t.Errorf("unexpected second result: %+v", results[1])
+	}
+}
+
+func TestVerifyUnsigned(t *testing.T) {
+	results, err := Verify(context.Background(), []byte(testMessage), StaticResolver{})
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no results, got %+v", results)
+	}
+}
+
+func TestParseKeyRecord(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating RSA key: %v", err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatalf("error marshalling RSA key: %v", err)
+	}
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating Ed25519 key: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		record   string
+		expected crypto.PublicKey
+		err      error
+	}{
+		{
+			name:     "RSA SubjectPublicKeyInfo",
+			record:   "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(pkix),
+			expected: rsaKey.Public(),
+		},
+		{
+			name:     "RSA PKCS1 without key type",
+			record:   "v=DKIM1; p=" + base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)),
+			expected: rsaKey.Public(),
+		},
+		{
+			name:     "Ed25519",
+			record:   "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPublic),
+			expected: edPublic,
+		},
+		{
+			name:   "Revoked",
+			record: "v=DKIM1; k=rsa; p=",
+			err:    ErrKeyRevoked,
+		},
+		{
+			name:   "Unknown key type",
+			record: "v=DKIM1; k=dsa; p=AAAA",
+			err:    ErrMalformedKey,
+		},
+		{
+			name:   "Missing key",
+			record: "v=DKIM1; k=rsa",
+			err:    ErrMalformedKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKeyRecord(tt.record)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("ParseKeyRecord() error = %v, expected %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeyRecord() error = %v", err)
+			}
+			if !key.(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.expected) {
+				t.Errorf("ParseKeyRecord() key does not match")
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package dkim
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// HeaderField is a single header field of a message, exactly as it appears in the raw
+// message including any folding and the terminating CRLF.
+type HeaderField struct {
+	Name string
+	Raw  string
+}
+
+// Value returns the unparsed value of the header field, after the colon.
+func (f HeaderField) Value() string {
+	_, value, _ := strings.Cut(f.Raw, ":")
+	return strings.TrimSuffix(value, "\r\n")
+}
+
+// Header is the ordered list of header fields of a message.
+type Header []HeaderField
+
+// Fields returns all header fields with the given name, in the order they appear.
+func (h Header) Fields(name string) []HeaderField {
+	var fields []HeaderField
+	for _, field := range h {
+		if strings.EqualFold(field.Name, name) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// SplitMessage splits a raw message into its header fields and body without any other
+// parsing, so that signatures can be verified against the bytes that were signed.  Messages
+// using bare LF line endings are converted to CRLF first.
+func SplitMessage(raw []byte) (Header, []byte, error) {
+	if !bytes.Contains(raw, []byte("\r\n")) {
+		raw = bytes.ReplaceAll(raw, []byte("\n"), []byte("\r\n"))
+	}
+
+	var header Header
+	rest := raw
+	for {
+		if len(rest) == 0 {
+			return header, nil, nil
+		}
+		if bytes.HasPrefix(rest, []byte("\r\n")) {
+			return header, rest[2:], nil
+		}
+
+		// A header field ends at the first CRLF not followed by whitespace (folding)
+		end := 0
+		for {
+			i := bytes.Index(rest[end:], []byte("\r\n"))
+			if i < 0 {
+				end = len(rest)
+				break
+			}
+			end += i + 2
+			if end >= len(rest) || (rest[end] != ' ' && rest[end] != '\t') {
+				break
+			}
+		}
+
+		raw := string(rest[:end])
+		name, _, found := strings.Cut(raw, ":")
+		if !found || strings.ContainsAny(name, " \t\r\n") {
+			return nil, nil, fmt.Errorf("malformed header field: %q", strings.TrimSuffix(raw, "\r\n"))
+		}
+		header = append(header, HeaderField{Name: name, Raw: raw})
+		rest = rest[end:]
+	}
+}
+
+// ParseTagList parses a DKIM tag=value list, as defined in RFC 6376 section 3.2.
+func ParseTagList(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(spec, "=")
+		if !found {
+			return nil, fmt.Errorf("malformed tag: %q", spec)
+		}
+		name = strings.TrimSpace(name)
+		if _, exists := tags[name]; exists {
+			return nil, fmt.Errorf("duplicate tag: %s", name)
+		}
+		tags[name] = strings.TrimSpace(value)
+	}
+	return tags, nil
+}
+
+// StripSignature removes the value of the b= tag from a signature header field, leaving
+// everything else untouched.  The result is what the signer hashed.
+func StripSignature(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+	specs := strings.Split(value, ";")
+	for i, spec := range specs {
+		tagName, _, found := strings.Cut(spec, "=")
+		if found && strings.TrimSpace(tagName) == "b" {
+			specs[i] = spec[:strings.Index(spec, "=")+1]
+		}
+	}
+
+	stripped := name + ":" + strings.Join(specs, ";")
+	if strings.HasSuffix(raw, "\r\n") && !strings.HasSuffix(stripped, "\r\n") {
+		stripped += "\r\n"
+	}
+	return stripped
+}
+
+// removeWhitespace removes all folding whitespace from a tag value, such as a base64 string.
+func removeWhitespace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,122 @@
+package dkim
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Resolver retrieves the public key published by a signing domain for a selector.
+// Implementations return ErrKeyNotFound when no key is published, and any other error
+// for temporary failures.
+type Resolver interface {
+	LookupKey(ctx context.Context, domain, selector string) (crypto.PublicKey, error)
+}
+
+// DNSResolver looks up public keys from DNS TXT records at <selector>._domainkey.<domain>.
+type DNSResolver struct {
+	Resolver *net.Resolver
+}
+
+// NewDNSResolver creates a DNSResolver backed by the default system resolver.
+func NewDNSResolver() *DNSResolver {
+	return &DNSResolver{Resolver: net.DefaultResolver}
+}
+
+// LookupKey looks up the key record for the selector and parses the first valid one.
+func (r *DNSResolver) LookupKey(ctx context.Context, domain, selector string) (crypto.PublicKey, error) {
+	records, err := r.Resolver.LookupTXT(ctx, keyRecordName(domain, selector))
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyRecordName(domain, selector))
+		}
+		return nil, fmt.Errorf("error looking up key record %s: %w", keyRecordName(domain, selector), err)
+	}
+
+	var parseErr error
+	for _, record := range records {
+		key, err := ParseKeyRecord(record)
+		if err == nil {
+			return key, nil
+		}
+		parseErr = err
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyRecordName(domain, selector))
+}
+
+// StaticResolver resolves keys from a fixed map keyed by "<selector>._domainkey.<domain>".
+// It is intended for tests.
+type StaticResolver map[string]crypto.PublicKey
+
+// LookupKey returns the key stored for the selector and domain.
+func (r StaticResolver) LookupKey(_ context.Context, domain, selector string) (crypto.PublicKey, error) {
+	key, ok := r[keyRecordName(domain, selector)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyRecordName(domain, selector))
+	}
+	return key, nil
+}
+
+// ParseKeyRecord parses a DKIM key record, as defined in RFC 6376 section 3.6.1.
+func ParseKeyRecord(record string) (crypto.PublicKey, error) {
+	tags, err := ParseTagList(record)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedKey, err)
+	}
+
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrMalformedKey, v)
+	}
+
+	p, ok := tags["p"]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing p= tag", ErrMalformedKey)
+	}
+	p = removeWhitespace(p)
+	if p == "" {
+		return nil, ErrKeyRevoked
+	}
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid p= tag: %v", ErrMalformedKey, err)
+	}
+
+	switch keyType := strings.ToLower(tags["k"]); keyType {
+	case "", "rsa":
+		// Keys are normally SubjectPublicKeyInfo, but some signers publish PKCS #1 keys
+		if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+			rsaKey, ok := key.(*rsa.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("%w: key is not an RSA key", ErrMalformedKey)
+			}
+			return rsaKey, nil
+		}
+		key, err := x509.ParsePKCS1PublicKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid RSA key: %v", ErrMalformedKey, err)
+		}
+		return key, nil
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid Ed25519 key length %d", ErrMalformedKey, len(der))
+		}
+		return ed25519.PublicKey(der), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type %q", ErrMalformedKey, keyType)
+	}
+}
+
+func keyRecordName(domain, selector string) string {
+	return fmt.Sprintf("%s._domainkey.%s", selector, domain)
+}
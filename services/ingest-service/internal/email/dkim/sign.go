@@ -0,0 +1,118 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultSignedHeaders are the header fields signed when SignOptions.Headers is empty.
+var defaultSignedHeaders = []string{"From", "To", "Subject", "Date", "Message-ID"}
+
+// SignOptions configures how a message is signed by Sign.
+type SignOptions struct {
+	Domain   string
+	Selector string
+
+	// Signer is the private key, either an *rsa.PrivateKey or an ed25519.PrivateKey
+	Signer crypto.Signer
+
+	HeaderCanonicalization Canonicalization
+	BodyCanonicalization   Canonicalization
+
+	// Headers are the names of the header fields to sign
+	Headers []string
+
+	// Expiration is written as the x= tag when set
+	Expiration time.Time
+}
+
+// Sign signs the raw message and returns it with a DKIM-Signature header field prepended.
+// It is used to produce signed messages for tests and synthetic traffic.
+func Sign(raw []byte, opts SignOptions) ([]byte, error) {
+	header, body, err := SplitMessage(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error reading message header: %w", err)
+	}
+
+	algorithm, err := SignerAlgorithm(opts.Signer)
+	if err != nil {
+		return nil, err
+	}
+
+	headerCanonicalization := opts.HeaderCanonicalization
+	if headerCanonicalization == "" {
+		headerCanonicalization = CanonicalizationRelaxed
+	}
+	bodyCanonicalization := opts.BodyCanonicalization
+	if bodyCanonicalization == "" {
+		bodyCanonicalization = CanonicalizationRelaxed
+	}
+
+	headers := opts.Headers
+	if len(headers) == 0 {
+		for _, name := range defaultSignedHeaders {
+			if len(header.Fields(name)) > 0 {
+				headers = append(headers, name)
+			}
+		}
+	}
+
+	bodyHash := sha256.Sum256(CanonicalizeBody(body, bodyCanonicalization))
+
+	var tags strings.Builder
+	fmt.Fprintf(&tags, "v=1; a=%s; c=%s/%s; d=%s; s=%s; t=%d;", algorithm, headerCanonicalization, bodyCanonicalization, opts.Domain, opts.Selector, now().Unix())
+	if !opts.Expiration.IsZero() {
+		fmt.Fprintf(&tags, " x=%d;", opts.Expiration.Unix())
+	}
+	fmt.Fprintf(&tags, "\r\n\th=%s;\r\n\tbh=%s;\r\n\tb=", strings.Join(headers, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	field := HeaderField{Name: "DKIM-Signature", Raw: "DKIM-Signature: " + tags.String() + "\r\n"}
+	signature, err := SignData(opts.Signer, algorithm, SignedHeaderData(header, headers, field, headerCanonicalization))
+	if err != nil {
+		return nil, err
+	}
+
+	signed := "DKIM-Signature: " + tags.String() + base64.StdEncoding.EncodeToString(signature) + "\r\n"
+	return append([]byte(signed), toCRLF(raw)...), nil
+}
+
+// SignData signs the SHA-256 hash of data with the given algorithm.
+func SignData(signer crypto.Signer, algorithm string, data []byte) ([]byte, error) {
+	hashed := sha256.Sum256(data)
+
+	switch algorithm {
+	case AlgorithmRSASHA256:
+		return signer.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	case AlgorithmEd25519SHA256:
+		return signer.Sign(rand.Reader, hashed[:], crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+}
+
+// SignerAlgorithm returns the signing algorithm matching the type of the private key.
+func SignerAlgorithm(signer crypto.Signer) (string, error) {
+	switch signer.(type) {
+	case *rsa.PrivateKey:
+		return AlgorithmRSASHA256, nil
+	case ed25519.PrivateKey:
+		return AlgorithmEd25519SHA256, nil
+	default:
+		return "", fmt.Errorf("%w: unsupported key type %T", ErrUnsupportedAlgorithm, signer)
+	}
+}
+
+// toCRLF converts a message with bare LF line endings to CRLF.
+func toCRLF(raw []byte) []byte {
+	if strings.Contains(string(raw), "\r\n") {
+		return raw
+	}
+	return []byte(strings.ReplaceAll(string(raw), "\n", "\r\n"))
+}
@@ -0,0 +1,273 @@
+package message
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/email/dkim"
+)
+
+const (
+	headerARCSeal                  = "ARC-Seal"
+	headerARCMessageSignature      = "ARC-Message-Signature"
+	headerARCAuthenticationResults = "ARC-Authentication-Results"
+)
+
+// maxARCInstances is the highest instance number allowed by RFC 8617.
+const maxARCInstances = 50
+
+// ARCResult is the outcome of validating an ARC chain, as defined in RFC 8617.
+type ARCResult string
+
+const (
+	ARCResultNone ARCResult = "none"
+	ARCResultPass ARCResult = "pass"
+	ARCResultFail ARCResult = "fail"
+)
+
+// ARCSet is the group of ARC header fields added by a single intermediary.
+type ARCSet struct {
+	Instance int
+
+	Seal                  dkim.HeaderField
+	MessageSignature      dkim.HeaderField
+	AuthenticationResults dkim.HeaderField
+
+	// ChainValidation is the cv= tag of the seal: the intermediary's view of the chain it received
+	ChainValidation ARCResult
+}
+
+// AuthResults returns the authentication results recorded by the intermediary, without the
+// instance tag.
+func (s ARCSet) AuthResults() string {
+	_, results, _ := strings.Cut(s.AuthenticationResults.Value(), ";")
+	return strings.TrimSpace(results)
+}
+
+// ARCValidation is the result of validating the ARC chain of a message.
+type ARCValidation struct {
+	Result ARCResult
+
+	// Sets are the ARC sets of the message, ordered by instance number
+	Sets []ARCSet
+
+	// Err describes why the chain failed validation
+	Err error
+}
+
+// OriginalAuthResults returns the authentication results recorded by the first intermediary,
+// which describe how the message authenticated before it was forwarded.  They can only be
+// trusted when the chain passes validation.
+func (v *ARCValidation) OriginalAuthResults() string {
+	if v.Result != ARCResultPass || len(v.Sets) == 0 {
+		return ""
+	}
+	return v.Sets[0].AuthResults()
+}
+
+// ParseARCSets groups the ARC header fields of a message by instance number and checks
+// that the chain is structurally valid: every instance from 1 to the highest has exactly
+// one of each field.
+func ParseARCSets(header dkim.Header) ([]ARCSet, error) {
+	sets := map[int]*ARCSet{}
+	set := func(instance int) *ARCSet {
+		if sets[instance] == nil {
+			sets[instance] = &ARCSet{Instance: instance}
+		}
+		return sets[instance]
+	}
+
+	for _, field := range header {
+		var target *dkim.HeaderField
+		instance, err := arcInstance(field)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case strings.EqualFold(field.Name, headerARCSeal):
+			target = &set(instance).Seal
+		case strings.EqualFold(field.Name, headerARCMessageSignature):
+			target = &set(instance).MessageSignature
+		case strings.EqualFold(field.Name, headerARCAuthenticationResults):
+			target = &set(instance).AuthenticationResults
+		default:
+			continue
+		}
+
+		if target.Raw != "" {
+			return nil, fmt.Errorf("duplicate %s header field for instance %d", field.Name, instance)
+		}
+		*target = field
+	}
+
+	instances := make([]int, 0, len(sets))
+	for instance := range sets {
+		instances = append(instances, instance)
+	}
+	sort.Ints(instances)
+
+	result := make([]ARCSet, 0, len(instances))
+	for i, instance := range instances {
+		if instance != i+1 {
+			return nil, fmt.Errorf("missing ARC set for instance %d", i+1)
+		}
+
+		s := sets[instance]
+		if s.Seal.Raw == "" || s.MessageSignature.Raw == "" || s.AuthenticationResults.Raw == "" {
+			return nil, fmt.Errorf("incomplete ARC set for instance %d", instance)
+		}
+
+		tags, err := dkim.ParseTagList(s.Seal.Value())
+		if err != nil {
+			return nil, fmt.Errorf("malformed %s for instance %d: %w", headerARCSeal, instance, err)
+		}
+		s.ChainValidation = ARCResult(strings.ToLower(tags["cv"]))
+
+		result = append(result, *s)
+	}
+
+	return result, nil
+}
+
+// ValidateARC validates the ARC chain of a raw message, verifying the most recent
+// ARC-Message-Signature and every ARC-Seal with keys from the resolver.  An error is only
+// returned if the message itself cannot be split into a header and body.
+func ValidateARC(ctx context.Context, raw []byte, resolver dkim.Resolver) (*ARCValidation, error) {
+	header, body, err := dkim.SplitMessage(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error reading message header: %w", err)
+	}
+
+	sets, err := ParseARCSets(header)
+	if err != nil {
+		return &ARCValidation{Result: ARCResultFail, Err: err}, nil
+	}
+	validation := &ARCValidation{Result: ARCResultNone, Sets: sets}
+	if len(sets) == 0 {
+		return validation, nil
+	}
+
+	if err := validateARCChain(ctx, resolver, header, body, sets); err != nil {
+		validation.Result = ARCResultFail
+		validation.Err = err
+		return validation, nil
+	}
+
+	validation.Result = ARCResultPass
+	return validation, nil
+}
+
+func validateARCChain(ctx context.Context, resolver dkim.Resolver, header dkim.Header, body []byte, sets []ARCSet) error {
+	if len(sets) > maxARCInstances {
+		return fmt.Errorf("ARC chain has %d instances, more than the maximum of %d", len(sets), maxARCInstances)
+	}
+
+	latest := sets[len(sets)-1]
+	if latest.ChainValidation == ARCResultFail {
+		return fmt.Errorf("ARC chain was marked as failed by instance %d", latest.Instance)
+	}
+	for _, set := range sets {
+		expected := ARCResultPass
+		if set.Instance == 1 {
+			expected = ARCResultNone
+		}
+		if set.ChainValidation != expected {
+			return fmt.Errorf("ARC-Seal for instance %d has cv=%s, expected cv=%s", set.Instance, set.ChainValidation, expected)
+		}
+	}
+
+	signature, err := dkim.ParseSignature(latest.MessageSignature)
+	if err != nil {
+		return fmt.Errorf("invalid ARC-Message-Signature for instance %d: %w", latest.Instance, err)
+	}
+	if err := signature.Verify(ctx, resolver, header, body); err != nil {
+		return fmt.Errorf("ARC-Message-Signature for instance %d did not verify: %w", latest.Instance, err)
+	}
+
+	for i := len(sets) - 1; i >= 0; i-- {
+		if err := verifyARCSeal(ctx, resolver, sets[:i+1]); err != nil {
+			return fmt.Errorf("ARC-Seal for instance %d did not verify: %w", sets[i].Instance, err)
+		}
+	}
+
+	return nil
+}
+
+// verifyARCSeal verifies the seal of the last set in sets, which covers every ARC header
+// field up to and including its own instance.
+func verifyARCSeal(ctx context.Context, resolver dkim.Resolver, sets []ARCSet) error {
+	seal := sets[len(sets)-1].Seal
+	tags, err := dkim.ParseTagList(seal.Value())
+	if err != nil {
+		return fmt.Errorf("%w: %v", dkim.ErrMalformedSignature, err)
+	}
+	for _, required := range []string{"a", "b", "d", "s"} {
+		if _, ok := tags[required]; !ok {
+			return fmt.Errorf("%w: missing %s= tag", dkim.ErrMalformedSignature, required)
+		}
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(tags["b"]), ""))
+	if err != nil {
+		return fmt.Errorf("%w: invalid b= tag: %v", dkim.ErrMalformedSignature, err)
+	}
+
+	key, err := resolver.LookupKey(ctx, strings.ToLower(tags["d"]), tags["s"])
+	if err != nil {
+		return err
+	}
+
+	return dkim.VerifyData(key, strings.ToLower(tags["a"]), ARCSealData(sets), signature)
+}
+
+// ARCSealData returns the canonicalized data covered by the seal of the last set in sets:
+// the ARC-Authentication-Results, ARC-Message-Signature and ARC-Seal fields of each
+// instance in order, with the b= value of the last seal removed.
+func ARCSealData(sets []ARCSet) []byte {
+	var buf strings.Builder
+	for i, set := range sets {
+		buf.WriteString(dkim.CanonicalizeHeader(set.AuthenticationResults.Raw, dkim.CanonicalizationRelaxed))
+		buf.WriteString(dkim.CanonicalizeHeader(set.MessageSignature.Raw, dkim.CanonicalizationRelaxed))
+		if i < len(sets)-1 {
+			buf.WriteString(dkim.CanonicalizeHeader(set.Seal.Raw, dkim.CanonicalizationRelaxed))
+		} else {
+			stripped := dkim.CanonicalizeHeader(dkim.StripSignature(set.Seal.Raw), dkim.CanonicalizationRelaxed)
+			buf.WriteString(strings.TrimSuffix(stripped, "\r\n"))
+		}
+	}
+	return []byte(buf.String())
+}
+
+// arcInstance returns the instance number of an ARC header field, or zero for other fields.
+func arcInstance(field dkim.HeaderField) (int, error) {
+	var value string
+	switch {
+	case strings.EqualFold(field.Name, headerARCAuthenticationResults):
+		// The instance tag is followed by a free-form authentication results payload
+		value, _, _ = strings.Cut(field.Value(), ";")
+	case strings.EqualFold(field.Name, headerARCSeal), strings.EqualFold(field.Name, headerARCMessageSignature):
+		tags, err := dkim.ParseTagList(field.Value())
+		if err != nil {
+			return 0, fmt.Errorf("malformed %s header field: %w", field.Name, err)
+		}
+		value = "i=" + tags["i"]
+	default:
+		return 0, nil
+	}
+
+	name, instance, found := strings.Cut(strings.TrimSpace(value), "=")
+	if !found || strings.TrimSpace(name) != "i" {
+		return 0, fmt.Errorf("%s header field is missing its instance tag", field.Name)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(instance))
+	if err != nil || n < 1 || n > maxARCInstances {
+		return 0, fmt.Errorf("invalid ARC instance: %s", strings.TrimSpace(instance))
+	}
+	return n, nil
+}
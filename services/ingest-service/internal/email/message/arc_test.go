@@ -0,0 +1,202 @@
+package message
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/email/dkim"
+)
+
+func TestValidateARC(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating Ed25519 key: %v", err)
+	}
+	resolver := dkim.StaticResolver{
+		"arc._domainkey.tenant.example":    key.Public(),
+		"arc._domainkey.forwarder.example": key.Public(),
+	}
+
+	original, err := NewBuilder().
+		From(&mail.Address{Address: "noreply-dmarc-support@google.com"}).
+		To(&mail.Address{Address: "dmarc@tenant.example"}).
+		Subject("Report domain: tenant.example").
+		Date(time.Date(2024, 8, 1, 10, 30, 0, 0, time.UTC)).
+		Attach(NewAttachmentPart("report.xml", "text/xml", []byte("<feedback/>"))).
+		Bytes()
+	if err != nil {
+		t.Fatalf("error building message: %v", err)
+	}
+
+	once := sealARC(t, original, key, "tenant.example", "mx.tenant.example; dkim=pass header.d=google.com", "none")
+	twice := sealARC(t, once, key, "forwarder.example", "mx.forwarder.example; arc=pass", "pass")
+
+	tests := []struct {
+		name          string
+		raw           []byte
+		expected      ARCResult
+		instances     int
+		originalAuth  string
+		expectedError string
+	}{
+		{
+			name:     "No ARC sets",
+			raw:      original,
+			expected: ARCResultNone,
+		},
+		{
+			name:         "Single set",
+			raw:          once,
+			expected:     ARCResultPass,
+			instances:    1,
+			originalAuth: "mx.tenant.example; dkim=pass header.d=google.com",
+		},
+		{
+			name:         "Two sets",
+			raw:          twice,
+			expected:     ARCResultPass,
+			instances:    2,
+			originalAuth: "mx.tenant.example; dkim=pass header.d=google.com",
+		},
+		{
+			name:          "Body modified after sealing",
+			raw:           []byte(strings.Replace(string(twice), "PGZlZWRiYWNrLz4=", "PGZlZWRiYWNrIC8+", 1)),
+			expected:      ARCResultFail,
+			instances:     2,
+			expectedError: "ARC-Message-Signature for instance 2",
+		},
+		{
+			name:          "Earlier authentication results modified",
+			raw:           []byte(strings.Replace(string(twice), "dkim=pass header.d=google.com", "dkim=pass header.d=example.com", 1)),
+			expected:      ARCResultFail,
+			instances:     2,
+			expectedError: "ARC-Seal for instance 2",
+		},
+		{
+			name:          "Latest seal marks chain as failed",
+			raw:           sealARC(t, once, key, "forwarder.example", "mx.forwarder.example; arc=fail", "fail"),
+			expected:      ARCResultFail,
+			instances:     2,
+			expectedError: "marked as failed",
+		},
+		{
+			name:          "First seal with cv=pass",
+			raw:           sealARC(t, original, key, "tenant.example", "mx.tenant.example; dkim=pass", "pass"),
+			expected:      ARCResultFail,
+			instances:     1,
+			expectedError: "expected cv=none",
+		},
+		{
+			name:          "Unknown sealing key",
+			raw:           sealARC(t, original, key, "unknown.example", "mx.unknown.example; dkim=pass", "none"),
+			expected:      ARCResultFail,
+			instances:     1,
+			expectedError: "key not found",
+		},
+		{
+			name:          "Missing instance",
+			raw:           []byte(strings.ReplaceAll(string(once), "i=1;", "i=2;")),
+			expected:      ARCResultFail,
+			expectedError: "missing ARC set for instance 1",
+		},
+		{
+			name:          "Duplicate set",
+			raw:           []byte(arcHeaderBlock(once) + string(once)),
+			expected:      ARCResultFail,
+			expectedError: "duplicate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validation, err := ValidateARC(context.Background(), tt.raw, resolver)
+			if err != nil {
+				t.Fatalf("ValidateARC() error = %v", err)
+			}
+
+			if validation.Result != tt.expected {
+				t.Errorf("ValidateARC() result = %s, expected %s (err: %v)", validation.Result, tt.expected, validation.Err)
+			}
+			if len(validation.Sets) != tt.instances {
+				t.Errorf("ValidateARC() sets = %d, expected %d", len(validation.Sets), tt.instances)
+			}
+			if auth := validation.OriginalAuthResults(); auth != tt.originalAuth {
+				t.Errorf("OriginalAuthResults() = %q, expected %q", auth, tt.originalAuth)
+			}
+			if tt.expectedError != "" && (validation.Err == nil || !strings.Contains(validation.Err.Error(), tt.expectedError)) {
+				t.Errorf("ValidateARC() err = %v, expected it to contain %q", validation.Err, tt.expectedError)
+			}
+		})
+	}
+}
+
+// sealARC adds a new ARC set to the message, as a forwarding intermediary would.
+func sealARC(t *testing.T, raw []byte, signer crypto.Signer, domain, authResults string, cv ARCResult) []byte {
+	t.Helper()
+
+	header, body, err := dkim.SplitMessage(raw)
+	if err != nil {
+		t.Fatalf("error splitting message: %v", err)
+	}
+	sets, err := ParseARCSets(header)
+	if err != nil {
+		t.Fatalf("error parsing ARC sets: %v", err)
+	}
+	algorithm, err := dkim.SignerAlgorithm(signer)
+	if err != nil {
+		t.Fatalf("error getting algorithm: %v", err)
+	}
+	instance := len(sets) + 1
+
+	aar := dkim.HeaderField{
+		Name: headerARCAuthenticationResults,
+		Raw:  fmt.Sprintf("%s: i=%d; %s\r\n", headerARCAuthenticationResults, instance, authResults),
+	}
+
+	bodyHash := sha256.Sum256(dkim.CanonicalizeBody(body, dkim.CanonicalizationRelaxed))
+	signedHeaders := []string{"From", "To", "Subject", "Date"}
+	amsTags := fmt.Sprintf("i=%d; a=%s; c=relaxed/relaxed; d=%s; s=arc; h=%s; bh=%s; b=",
+		instance, algorithm, domain, strings.Join(signedHeaders, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	ams := dkim.HeaderField{Name: headerARCMessageSignature, Raw: headerARCMessageSignature + ": " + amsTags + "\r\n"}
+	amsSignature, err := dkim.SignData(signer, algorithm, dkim.SignedHeaderData(header, signedHeaders, ams, dkim.CanonicalizationRelaxed))
+	if err != nil {
+		t.Fatalf("error signing ARC-Message-Signature: %v", err)
+	}
+	ams.Raw = headerARCMessageSignature + ": " + amsTags + base64.StdEncoding.EncodeToString(amsSignature) + "\r\n"
+
+	sealTags := fmt.Sprintf("i=%d; a=%s; cv=%s; d=%s; s=arc; b=", instance, algorithm, cv, domain)
+	seal := dkim.HeaderField{Name: headerARCSeal, Raw: headerARCSeal + ": " + sealTags + "\r\n"}
+	sealSignature, err := dkim.SignData(signer, algorithm, ARCSealData(append(sets, ARCSet{
+		Instance:              instance,
+		Seal:                  seal,
+		MessageSignature:      ams,
+		AuthenticationResults: aar,
+	})))
+	if err != nil {
+		t.Fatalf("error signing ARC-Seal: %v", err)
+	}
+	seal.Raw = headerARCSeal + ": " + sealTags + base64.StdEncoding.EncodeToString(sealSignature) + "\r\n"
+
+	return []byte(seal.Raw + ams.Raw + aar.Raw + string(raw))
+}
+
+// arcHeaderBlock returns the ARC header fields at the top of a sealed message.
+func arcHeaderBlock(raw []byte) string {
+	header, _, _ := dkim.SplitMessage(raw)
+	var block strings.Builder
+	for _, field := range header {
+		if strings.HasPrefix(field.Name, "ARC-") {
+			block.WriteString(field.Raw)
+		}
+	}
+	return block.String()
+}
@@ -0,0 +1,484 @@
+package message
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+const contentTypeMessageRFC822 = "message/rfc822"
+
+// Transfer encodings understood by the Builder.
+const (
+	Encoding7Bit            = "7bit"
+	Encoding8Bit            = "8bit"
+	EncodingBinary          = "binary"
+	EncodingBase64          = "base64"
+	EncodingQuotedPrintable = "quoted-printable"
+)
+
+// base64LineLength is the maximum length of a base64 encoded line, as defined by RFC 2045.
+const base64LineLength = 76
+
+// Part is a single MIME entity within a message built by a Builder.  A Part carries
+// either a Body, a nested Message (for message/rfc822 parts) or, when its media type
+// is multipart, a list of child Parts.
+type Part struct {
+	// ContentType is the media type of the part, without parameters
+	ContentType string
+
+	// Params are the media type parameters, such as charset
+	Params map[string]string
+
+	// Encoding is the Content-Transfer-Encoding used to write the Body
+	Encoding string
+
+	// Disposition is the Content-Disposition of the part, typically inline or attachment
+	Disposition string
+
+	// Filename is written as the filename parameter of the Content-Disposition header
+	Filename string
+
+	// ContentID is written as the Content-ID header, used by embedded files
+	ContentID string
+
+	// Header holds any additional headers written for the part
+	Header textproto.MIMEHeader
+
+	Body    []byte
+	Message *Builder
+	Parts   []*Part
+}
+
+// NewTextPart creates a text/plain part with a UTF-8 charset.
+func NewTextPart(body string) *Part {
+	return newTextPart(contentTypeTextPlain, body)
+}
+
+// NewHTMLPart creates a text/html part with a UTF-8 charset.
+func NewHTMLPart(body string) *Part {
+	return newTextPart(contentTypeTextHtml, body)
+}
+
+func newTextPart(contentType, body string) *Part {
+	encoding := Encoding7Bit
+	if !is7Bit([]byte(body)) {
+		encoding = EncodingQuotedPrintable
+	}
+
+	return &Part{
+		ContentType: contentType,
+		Params:      map[string]string{"charset": "UTF-8"},
+		Encoding:    encoding,
+		Body:        []byte(body),
+	}
+}
+
+// NewAttachmentPart creates an attachment part which is base64 encoded by default.  The
+// encoding can be changed by setting the Encoding field of the returned part.
+func NewAttachmentPart(filename, contentType string, data []byte) *Part {
+	return &Part{
+		ContentType: contentType,
+		Params:      map[string]string{"name": filename},
+		Encoding:    EncodingBase64,
+		Disposition: "attachment",
+		Filename:    filename,
+		Body:        data,
+	}
+}
+
+// NewMultipart creates a multipart part of the given subtype (mixed, alternative, related, ...)
+// containing the given child parts.
+func NewMultipart(subtype string, parts ...*Part) *Part {
+	return &Part{
+		ContentType: "multipart/" + subtype,
+		Parts:       parts,
+	}
+}
+
+// NewMessagePart creates a message/rfc822 part which embeds the message produced by b, as
+// used when an email is forwarded as an attachment.
+func NewMessagePart(b *Builder) *Part {
+	return &Part{
+		ContentType: contentTypeMessageRFC822,
+		Message:     b,
+	}
+}
+
+// Builder programmatically constructs RFC 5322 email messages.  Headers are written in the
+// order they are set, followed by the MIME headers of the body.
+type Builder struct {
+	header []headerField
+	date   time.Time
+	from   []*mail.Address
+
+	text        *Part
+	html        *Part
+	attachments []*Part
+	body        *Part
+}
+
+type headerField struct {
+	key   string
+	value string
+}
+
+// NewBuilder creates an empty Builder.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// From sets the From header.  At least one From address is required.
+func (b *Builder) From(addresses ...*mail.Address) *Builder {
+	b.from = addresses
+	return b.setHeader("From", formatAddressList(addresses))
+}
+
+// Sender sets the Sender header.
+func (b *Builder) Sender(address *mail.Address) *Builder {
+	return b.setHeader("Sender", address.String())
+}
+
+// To sets the To header.
+func (b *Builder) To(addresses ...*mail.Address) *Builder {
+	return b.setHeader("To", formatAddressList(addresses))
+}
+
+// Cc sets the Cc header.
+func (b *Builder) Cc(addresses ...*mail.Address) *Builder {
+	return b.setHeader("Cc", formatAddressList(addresses))
+}
+
+// ReplyTo sets the Reply-To header.
+func (b *Builder) ReplyTo(addresses ...*mail.Address) *Builder {
+	return b.setHeader("Reply-To", formatAddressList(addresses))
+}
+
+// Subject sets the Subject header, encoding it as an RFC 2047 encoded-word when it
+// contains non-ASCII characters.
+func (b *Builder) Subject(subject string) *Builder {
+	return b.setHeader("Subject", mime.QEncoding.Encode("UTF-8", subject))
+}
+
+// Date sets the Date header.  When no date is set, the time the message is built is used.
+func (b *Builder) Date(date time.Time) *Builder {
+	b.date = date
+	return b.setHeader("Date", date.Format(time.RFC1123Z))
+}
+
+// MessageID sets the Message-ID header.  The angle brackets are added if missing.
+func (b *Builder) MessageID(id string) *Builder {
+	return b.setHeader("Message-ID", "<"+strings.Trim(id, "<>")+">")
+}
+
+// Header adds an arbitrary header field.  Unlike the typed setters, repeated calls with the
+// same key add multiple fields, which is how trace headers such as Received are written.
+func (b *Builder) Header(key, value string) *Builder {
+	b.header = append(b.header, headerField{key: textproto.CanonicalMIMEHeaderKey(key), value: value})
+	return b
+}
+
+// Text sets the plain text body of the message.
+func (b *Builder) Text(body string) *Builder {
+	b.text = NewTextPart(body)
+	return b
+}
+
+// HTML sets the HTML body of the message.
+func (b *Builder) HTML(body string) *Builder {
+	b.html = NewHTMLPart(body)
+	return b
+}
+
+// Attach adds attachment parts to the message.
+func (b *Builder) Attach(parts ...*Part) *Builder {
+	b.attachments = append(b.attachments, parts...)
+	return b
+}
+
+// Body sets the root MIME part of the message explicitly, overriding anything set with Text,
+// HTML or Attach.  This allows arbitrary MIME trees to be built.
+func (b *Builder) Body(part *Part) *Builder {
+	b.body = part
+	return b
+}
+
+// Bytes builds the message and returns it as a byte slice.
+func (b *Builder) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteTo builds the message and writes it to w.
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	if len(b.from) == 0 {
+		return 0, fmt.Errorf("message requires at least one From address")
+	}
+
+	cw := &countingWriter{w: w}
+	for _, field := range b.header {
+		fmt.Fprintf(cw, "%s: %s\r\n", field.key, field.value)
+	}
+	if b.date.IsZero() {
+		fmt.Fprintf(cw, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	}
+	fmt.Fprint(cw, "MIME-Version: 1.0\r\n")
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	if err := writeEntity(cw, b.root()); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+// root returns the root MIME part of the message, assembling one from the text, HTML and
+// attachment parts when no explicit body has been set.
+func (b *Builder) root() *Part {
+	if b.body != nil {
+		return b.body
+	}
+
+	var content *Part
+	switch {
+	case b.text != nil && b.html != nil:
+		content = NewMultipart("alternative", b.text, b.html)
+	case b.html != nil:
+		content = b.html
+	case b.text != nil:
+		content = b.text
+	}
+
+	if len(b.attachments) == 0 {
+		if content == nil {
+			return NewTextPart("")
+		}
+		return content
+	}
+
+	var parts []*Part
+	if content != nil {
+		parts = append(parts, content)
+	}
+	return NewMultipart("mixed", append(parts, b.attachments...)...)
+}
+
+// setHeader sets a header field, replacing any existing field with the same key.
+func (b *Builder) setHeader(key, value string) *Builder {
+	for i, field := range b.header {
+		if field.key == key {
+			b.header[i].value = value
+			return b
+		}
+	}
+	b.header = append(b.header, headerField{key: key, value: value})
+	return b
+}
+
+// writeEntity writes the MIME headers of the part, a blank line and the encoded body.
+func writeEntity(w io.Writer, p *Part) error {
+	var boundary string
+	if p.isMultipart() {
+		boundary = randomBoundary()
+	}
+
+	header, err := p.mimeHeader(boundary)
+	if err != nil {
+		return err
+	}
+	for _, field := range header {
+		if _, err := fmt.Fprintf(w, "%s: %s\r\n", field.key, field.value); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+
+	if p.isMultipart() {
+		return writeMultipartBody(w, p.Parts, boundary)
+	}
+	return p.writeBody(w)
+}
+
+func writeMultipartBody(w io.Writer, parts []*Part, boundary string) error {
+	for i, part := range parts {
+		delimiter := "\r\n--" + boundary + "\r\n"
+		if i == 0 {
+			delimiter = "--" + boundary + "\r\n"
+		}
+		if _, err := io.WriteString(w, delimiter); err != nil {
+			return err
+		}
+		if err := writeEntity(w, part); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, "\r\n--"+boundary+"--\r\n")
+	return err
+}
+
+func (p *Part) isMultipart() bool {
+	return strings.HasPrefix(p.ContentType, "multipart/")
+}
+
+// mimeHeader returns the MIME header fields of the part in a stable order.
+func (p *Part) mimeHeader(boundary string) ([]headerField, error) {
+	params := make(map[string]string, len(p.Params)+1)
+	for k, v := range p.Params {
+		params[k] = v
+	}
+	if boundary != "" {
+		params["boundary"] = boundary
+	}
+
+	contentType := mime.FormatMediaType(p.ContentType, params)
+	if contentType == "" {
+		return nil, fmt.Errorf("invalid content type: %s", p.ContentType)
+	}
+	header := []headerField{{key: "Content-Type", value: contentType}}
+
+	if p.Encoding != "" && !p.isMultipart() {
+		header = append(header, headerField{key: "Content-Transfer-Encoding", value: p.Encoding})
+	}
+
+	if p.Disposition != "" {
+		dispositionParams := map[string]string{}
+		if p.Filename != "" {
+			dispositionParams["filename"] = p.Filename
+		}
+		disposition := mime.FormatMediaType(p.Disposition, dispositionParams)
+		if disposition == "" {
+			return nil, fmt.Errorf("invalid content disposition: %s", p.Disposition)
+		}
+		header = append(header, headerField{key: "Content-Disposition", value: disposition})
+	}
+
+	if p.ContentID != "" {
+		header = append(header, headerField{key: "Content-Id", value: "<" + strings.Trim(p.ContentID, "<>") + ">"})
+	}
+
+	keys := make([]string, 0, len(p.Header))
+	for key := range p.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range p.Header[key] {
+			header = append(header, headerField{key: key, value: value})
+		}
+	}
+
+	return header, nil
+}
+
+// writeBody encodes the body of a non-multipart part using its transfer encoding.
+func (p *Part) writeBody(w io.Writer) error {
+	body := p.Body
+	if p.Message != nil {
+		nested, err := p.Message.Bytes()
+		if err != nil {
+			return fmt.Errorf("error building nested message: %w", err)
+		}
+		body = nested
+	}
+
+	switch strings.ToLower(p.Encoding) {
+	case EncodingBase64:
+		return writeBase64(w, body)
+	case EncodingQuotedPrintable:
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(body); err != nil {
+			return err
+		}
+		return qp.Close()
+	case "", Encoding7Bit:
+		if !is7Bit(body) {
+			return fmt.Errorf("body of %s part contains 8bit data but is encoded as 7bit", p.ContentType)
+		}
+		_, err := w.Write(toCRLF(body))
+		return err
+	case Encoding8Bit:
+		_, err := w.Write(toCRLF(body))
+		return err
+	case EncodingBinary:
+		_, err := w.Write(body)
+		return err
+	default:
+		return fmt.Errorf("unknown encoding: %s", p.Encoding)
+	}
+}
+
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := min(len(encoded), base64LineLength)
+		if _, err := io.WriteString(w, encoded[:n]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+func formatAddressList(addresses []*mail.Address) string {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		formatted[i] = address.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func is7Bit(data []byte) bool {
+	for _, c := range data {
+		if c > 127 || c == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// toCRLF converts bare LF line endings to CRLF, as required by RFC 5322.
+func toCRLF(data []byte) []byte {
+	normalized := bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(normalized, []byte("\n"), []byte("\r\n"))
+}
+
+func randomBoundary() string {
+	var buf [24]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%x", buf[:])
+}
+
+// countingWriter records the number of bytes written and the first error encountered.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,266 @@
+package message
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuilderRoundTrip(t *testing.T) {
+	date := time.Date(2024, 8, 1, 10, 30, 0, 0, time.UTC)
+	report := []byte("\x1f\x8b\x08\x00compressed report data\xff\x00")
+
+	tests := []struct {
+		name        string
+		builder     *Builder
+		contentType string
+		subject     string
+		from        []mail.Address
+		textBody    string
+		htmlBody    string
+		attachments []attachmentData
+	}{
+		{
+			name: "Plain text",
+			builder: NewBuilder().
+				From(&mail.Address{Name: "John Doe", Address: "jdoe@example.com"}).
+				To(&mail.Address{Address: "dmarc@example.net"}).
+				Subject("Saying Hello").
+				Date(date).
+				Text("This is a message just to say hello."),
+			contentType: "text/plain",
+			subject:     "Saying Hello",
+			from:        []mail.Address{{Name: "John Doe", Address: "jdoe@example.com"}},
+			textBody:    "This is a message just to say hello.",
+		},
+		{
+			name: "Encoded headers",
+			builder: NewBuilder().
+				From(&mail.Address{Name: "Peter Paholík", Address: "peter@example.com"}).
+				Subject("Rapport DMARC für example.com — 2024-08-01").
+				Date(date).
+				HTML("<p>report</p>"),
+			contentType: "text/html",
+			subject:     "Rapport DMARC für example.com — 2024-08-01",
+			from:        []mail.Address{{Name: "Peter Paholík", Address: "peter@example.com"}},
+			htmlBody:    "<p>report</p>",
+		},
+		{
+			name: "Alternative bodies with gzip attachment",
+			builder: NewBuilder().
+				From(&mail.Address{Address: "noreply-dmarc-support@google.com"}).
+				Subject("Report domain: example.com").
+				Date(date).
+				Text("text body").
+				HTML("<div>html body</div>").
+				Attach(NewAttachmentPart("google.com!example.com!1722470400!1722556799.xml.gz", "application/gzip", report)),
+			contentType: "multipart/mixed",
+			subject:     "Report domain: example.com",
+			from:        []mail.Address{{Address: "noreply-dmarc-support@google.com"}},
+			textBody:    "text body",
+			htmlBody:    "<div>html body</div>",
+			attachments: []attachmentData{
+				{
+					filename:    "google.com!example.com!1722470400!1722556799.xml.gz",
+					contentType: "application/gzip",
+					data:        string(report),
+				},
+			},
+		},
+		{
+			name: "Attachments in every transfer encoding",
+			builder: NewBuilder().
+				From(&mail.Address{Address: "dmarc@example.org"}).
+				Date(date).
+				Attach(
+					withEncoding(NewAttachmentPart("base64.xml", "text/xml", []byte("<feedback/>")), EncodingBase64),
+					withEncoding(NewAttachmentPart("qp.xml", "text/xml", []byte("<org_name>Exämple=Org</org_name>")), EncodingQuotedPrintable),
+					withEncoding(NewAttachmentPart("7bit.xml", "text/xml", []byte("<feedback/>")), Encoding7Bit),
+					withEncoding(NewAttachmentPart("8bit.xml", "text/xml", []byte("<org_name>Exämple</org_name>")), Encoding8Bit),
+				),
+			contentType: "multipart/mixed",
+			from:        []mail.Address{{Address: "dmarc@example.org"}},
+			attachments: []attachmentData{
+				{filename: "base64.xml", contentType: "text/xml", data: "<feedback/>"},
+				{filename: "qp.xml", contentType: "text/xml", data: "<org_name>Exämple=Org</org_name>"},
+				{filename: "7bit.xml", contentType: "text/xml", data: "<feedback/>"},
+				{filename: "8bit.xml", contentType: "text/xml", data: "<org_name>Exämple</org_name>"},
+			},
+		},
+		{
+			name: "Forwarded message",
+			builder: NewBuilder().
+				From(&mail.Address{Address: "admin@tenant.example"}).
+				Subject("Fwd: Report domain: example.com").
+				Date(date).
+				Text("See attached").
+				Attach(&Part{
+					ContentType: contentTypeMessageRFC822,
+					Disposition: "attachment",
+					Filename:    "forwarded.eml",
+					Message: NewBuilder().
+						From(&mail.Address{Address: "dmarc@example.org"}).
+						Date(date).
+						Attach(NewAttachmentPart("report.xml.gz", "application/gzip", report)),
+				}),
+			contentType: "multipart/mixed",
+			subject:     "Fwd: Report domain: example.com",
+			from:        []mail.Address{{Address: "admin@tenant.example"}},
+			textBody:    "See attached",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := tt.builder.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes() error = %v", err)
+			}
+
+			email, err := ParseMail(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("ParseMail() error = %v\n%s", err, raw)
+			}
+
+			if !strings.HasPrefix(email.ContentType, tt.contentType) {
+				t.Errorf("Wrong content type. Expected prefix: %s, Got: %s", tt.contentType, email.ContentType)
+			}
+			if email.Subject != tt.subject {
+				t.Errorf("Wrong subject. Expected: %s, Got: %s", tt.subject, email.Subject)
+			}
+			if !email.Date.Equal(date) {
+				t.Errorf("Wrong date. Expected: %v, Got: %v", date, email.Date)
+			}
+			if from := dereferenceAddressList(email.From); !assertAddressListEq(tt.from, from) {
+				t.Errorf("Wrong from. Expected: %s, Got: %s", tt.from, from)
+			}
+			if email.TextBody != tt.textBody {
+				t.Errorf("Wrong text body. Expected: '%s', Got: '%s'", tt.textBody, email.TextBody)
+			}
+			if email.HTMLBody != tt.htmlBody {
+				t.Errorf("Wrong html body. Expected: '%s', Got: '%s'", tt.htmlBody, email.HTMLBody)
+			}
+
+			if tt.attachments == nil {
+				return
+			}
+			if len(email.Attachments) != len(tt.attachments) {
+				t.Fatalf("Incorrect number of attachments. Expected: %d, Got: %d", len(tt.attachments), len(email.Attachments))
+			}
+			for i, expected := range tt.attachments {
+				got := email.Attachments[i]
+				data, err := io.ReadAll(got.Data)
+				if err != nil {
+					t.Fatalf("error reading attachment data: %v", err)
+				}
+				if got.Filename != expected.filename || got.ContentType != expected.contentType || string(data) != expected.data {
+					t.Errorf("Wrong attachment %d. Expected: %s %s %q, Got: %s %s %q", i, expected.filename, expected.contentType, expected.data, got.Filename, got.ContentType, data)
+				}
+			}
+		})
+	}
+}
+
+func TestBuilderForwardedMessage(t *testing.T) {
+	inner := NewBuilder().
+		From(&mail.Address{Address: "dmarc@example.org"}).
+		Subject("Report domain: example.com").
+		Attach(NewAttachmentPart("report.xml", "text/xml", []byte("<feedback/>")))
+
+	raw, err := NewBuilder().
+		From(&mail.Address{Address: "admin@tenant.example"}).
+		Attach(&Part{ContentType: contentTypeMessageRFC822, Disposition: "attachment", Filename: "forwarded.eml", Message: inner}).
+		Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+
+	outer, err := ParseMail(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ParseMail() error = %v", err)
+	}
+	if len(outer.Attachments) != 1 || outer.Attachments[0].ContentType != contentTypeMessageRFC822 {
+		t.Fatalf("expected a single message/rfc822 attachment, got %+v", outer.Attachments)
+	}
+
+	email, err := ParseMail(outer.Attachments[0].Data)
+	if err != nil {
+		t.Fatalf("ParseMail() of forwarded message error = %v", err)
+	}
+	if email.Subject != "Report domain: example.com" {
+		t.Errorf("Wrong forwarded subject. Got: %s", email.Subject)
+	}
+	if len(email.Attachments) != 1 || email.Attachments[0].Filename != "report.xml" {
+		t.Errorf("expected forwarded report attachment, got %+v", email.Attachments)
+	}
+}
+
+func TestBuilderNestedMultipart(t *testing.T) {
+	raw, err := NewBuilder().
+		From(&mail.Address{Address: "jdoe@example.com"}).
+		Body(NewMultipart("mixed",
+			NewMultipart("related",
+				NewMultipart("alternative", NewTextPart("Time for the egg."), NewHTMLPart("<div>Time for the egg.</div>")),
+				&Part{ContentType: "image/png", Encoding: EncodingBase64, ContentID: "egg@example.com", Body: []byte("png")},
+			),
+			NewAttachmentPart("report.zip", "application/zip", []byte("PK\x03\x04")),
+		)).
+		Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+
+	email, err := ParseMail(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ParseMail() error = %v\n%s", err, raw)
+	}
+	if email.TextBody != "Time for the egg." || email.HTMLBody != "<div>Time for the egg.</div>" {
+		t.Errorf("Wrong bodies. Got text: '%s', html: '%s'", email.TextBody, email.HTMLBody)
+	}
+	if len(email.EmbeddedFiles) != 1 || email.EmbeddedFiles[0].CID != "egg@example.com" {
+		t.Errorf("expected a single embedded file, got %+v", email.EmbeddedFiles)
+	}
+	if len(email.Attachments) != 1 || email.Attachments[0].Filename != "report.zip" {
+		t.Errorf("expected a single zip attachment, got %+v", email.Attachments)
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *Builder
+	}{
+		{
+			name:    "Missing From",
+			builder: NewBuilder().Subject("No sender").Text("body"),
+		},
+		{
+			name: "8bit data in 7bit part",
+			builder: NewBuilder().
+				From(&mail.Address{Address: "jdoe@example.com"}).
+				Attach(withEncoding(NewAttachmentPart("report.xml", "text/xml", []byte("Exämple")), Encoding7Bit)),
+		},
+		{
+			name: "Unknown encoding",
+			builder: NewBuilder().
+				From(&mail.Address{Address: "jdoe@example.com"}).
+				Attach(withEncoding(NewAttachmentPart("report.xml", "text/xml", []byte("<feedback/>")), "x-uuencode")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.builder.Bytes(); err == nil {
+				t.Errorf("expected an error building the message")
+			}
+		})
+	}
+}
+
+func withEncoding(p *Part, encoding string) *Part {
+	p.Encoding = encoding
+	return p
+}
@@ -1,9 +1,85 @@
 package errors
 
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/smithy-go"
+)
+
+// Kind classifies an error by whether retrying the work that failed can succeed.  A Kind is
+// itself an error, so errors.Is(err, errors.Permanent) reports whether err is of that kind.
+type Kind int
+
+const (
+	// Transient errors, such as a service being unavailable, may succeed when retried.  Errors
+	// which have not been classified are treated as transient.
+	Transient Kind = iota
+
+	// Throttled errors are transient errors caused by exceeding a rate limit.
+	Throttled
+
+	// Permanent errors will fail however many times they are retried, such as when an object
+	// the message refers to no longer exists.
+	Permanent
+
+	// InvalidInput errors are permanent errors caused by a malformed message or report.
+	InvalidInput
+
+	// Security errors are permanent errors caused by input which looks malicious, such as a
+	// decompression bomb.
+	Security
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Transient:
+		return "Transient"
+	case Throttled:
+		return "Throttled"
+	case Permanent:
+		return "Permanent"
+	case InvalidInput:
+		return "InvalidInput"
+	case Security:
+		return "Security"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Error returns the name of the kind, so a Kind can be the target of errors.Is.
+func (k Kind) Error() string {
+	return k.String()
+}
+
+// Retryable reports whether errors of this kind may succeed when retried.
+func (k Kind) Retryable() bool {
+	return k == Transient || k == Throttled
+}
+
+// statusCode returns the HTTP status code reported for errors of this kind.
+func (k Kind) statusCode() int {
+	switch k {
+	case Throttled:
+		return http.StatusTooManyRequests
+	case Permanent:
+		return http.StatusUnprocessableEntity
+	case InvalidInput:
+		return http.StatusBadRequest
+	case Security:
+		return http.StatusForbidden
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
 // A LambdaError is an error that occurred during the execution of a Lambda function.
 type LambdaError struct {
 	StatusCode    int    `json:"code"`
 	Message       string `json:"message"`
+	Kind          Kind   `json:"kind"`
 	OriginalError error  `json:"-"`
 }
 
@@ -12,10 +88,113 @@ func (e LambdaError) Error() string {
 	return e.Message
 }
 
-// NewLambdaError creates a new LambdaError.
+// Unwrap returns the error that caused the LambdaError, if any.
+func (e LambdaError) Unwrap() error {
+	return e.OriginalError
+}
+
+// Is reports whether the target is the Kind of the error.  Throttled errors are also
+// Transient, and InvalidInput and Security errors are also Permanent.
+func (e LambdaError) Is(target error) bool {
+	kind, ok := target.(Kind)
+	if !ok {
+		return false
+	}
+	switch kind {
+	case e.Kind:
+		return true
+	case Transient:
+		return e.Kind.Retryable()
+	case Permanent:
+		return !e.Kind.Retryable()
+	default:
+		return false
+	}
+}
+
+// NewLambdaError creates a new LambdaError.  It is classified as Transient, so the work is
+// retried.
 func NewLambdaError(statusCode int, message string) LambdaError {
 	return LambdaError{
 		StatusCode: statusCode,
 		Message:    message,
+		Kind:       Transient,
 	}
 }
+
+// New creates a LambdaError of the kind.
+func New(kind Kind, message string) LambdaError {
+	return LambdaError{
+		StatusCode: kind.statusCode(),
+		Message:    message,
+		Kind:       kind,
+	}
+}
+
+// Wrap creates a LambdaError of the kind caused by err, with the message "message: err".
+func Wrap(kind Kind, err error, message string) LambdaError {
+	return LambdaError{
+		StatusCode:    kind.statusCode(),
+		Message:       fmt.Sprintf("%s: %v", message, err),
+		Kind:          kind,
+		OriginalError: err,
+	}
+}
+
+// Classify creates a LambdaError caused by err, of the kind KindOf classifies err as, with the
+// message "message: err".  It is used where the kind depends on the cause, such as when a
+// request to AWS fails.
+func Classify(err error, message string) LambdaError {
+	return Wrap(KindOf(err), err, message)
+}
+
+// throttlingCodes are the AWS error codes returned when a request exceeds a rate limit
+var throttlingCodes = map[string]bool{
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"ThrottledException":                     true,
+	"RequestThrottledException":              true,
+	"TooManyRequestsException":               true,
+	"ProvisionedThroughputExceededException": true,
+	"RequestLimitExceeded":                   true,
+	"SlowDown":                               true,
+}
+
+// permanentCodes are the AWS error codes returned when a request can never succeed as made,
+// such as when the function is not allowed to make it or a resource it names does not exist
+var permanentCodes = map[string]bool{
+	"AccessDenied":                            true,
+	"AccessDeniedException":                   true,
+	"ValidationException":                     true,
+	"InvalidParameterValue":                   true,
+	"ResourceNotFoundException":               true,
+	"NoSuchBucket":                            true,
+	"AWS.SimpleQueueService.NonExistentQueue": true,
+}
+
+// KindOf returns the kind of the first LambdaError in the chain of err.  Errors without one
+// are Throttled if they are an AWS throttling error, Permanent if they are an AWS error that
+// retrying cannot fix, and Transient otherwise.
+func KindOf(err error) Kind {
+	var lambdaErr LambdaError
+	if stderrors.As(err, &lambdaErr) {
+		return lambdaErr.Kind
+	}
+
+	var apiErr smithy.APIError
+	if stderrors.As(err, &apiErr) {
+		switch {
+		case throttlingCodes[apiErr.ErrorCode()]:
+			return Throttled
+		case permanentCodes[apiErr.ErrorCode()]:
+			return Permanent
+		}
+	}
+
+	return Transient
+}
+
+// IsRetryable reports whether the work that failed with err may succeed when retried.
+func IsRetryable(err error) bool {
+	return KindOf(err).Retryable()
+}
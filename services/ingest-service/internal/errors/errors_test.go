@@ -0,0 +1,130 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		kind      Kind
+		retryable bool
+	}{
+		{
+			name:      "Unclassified error",
+			err:       stderrors.New("connection reset"),
+			kind:      Transient,
+			retryable: true,
+		},
+		{
+			name:      "NewLambdaError",
+			err:       NewLambdaError(500, "error sending message"),
+			kind:      Transient,
+			retryable: true,
+		},
+		{
+			name: "Wrapped LambdaError",
+			err:  fmt.Errorf("error processing record: %w", Wrap(InvalidInput, stderrors.New("unexpected EOF"), "error parsing report")),
+			kind: InvalidInput,
+		},
+		{
+			name: "Security",
+			err:  New(Security, "attachment exceeds decompression limits"),
+			kind: Security,
+		},
+		{
+			name:      "AWS throttling error",
+			err:       fmt.Errorf("error putting item: %w", &smithy.GenericAPIError{Code: "ProvisionedThroughputExceededException"}),
+			kind:      Throttled,
+			retryable: true,
+		},
+		{
+			name:      "Other AWS error",
+			err:       &smithy.GenericAPIError{Code: "InternalError"},
+			kind:      Transient,
+			retryable: true,
+		},
+		{
+			name: "AWS access denied",
+			err:  fmt.Errorf("error putting object: %w", &smithy.GenericAPIError{Code: "AccessDenied"}),
+			kind: Permanent,
+		},
+		{
+			name: "Classify keeps the kind of the cause",
+			err:  Classify(fmt.Errorf("error publishing message: %w", &smithy.GenericAPIError{Code: "AccessDeniedException"}), "error publishing messages to SQS"),
+			kind: Permanent,
+		},
+		{
+			name:      "Classify unclassified error",
+			err:       Classify(stderrors.New("connection reset"), "error publishing messages to SQS"),
+			kind:      Transient,
+			retryable: true,
+		},
+		{
+			name: "Outermost LambdaError wins",
+			err:  Wrap(Permanent, Wrap(Throttled, stderrors.New("slow down"), "error getting object"), "error getting report"),
+			kind: Permanent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if kind := KindOf(tt.err); kind != tt.kind {
+				t.Errorf("KindOf() = %v, expected %v", kind, tt.kind)
+			}
+			if retryable := IsRetryable(tt.err); retryable != tt.retryable {
+				t.Errorf("IsRetryable() = %v, expected %v", retryable, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestLambdaErrorIs(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		target   error
+		expected bool
+	}{
+		{"Same kind", New(Permanent, "gone"), Permanent, true},
+		{"Different kind", New(Permanent, "gone"), Transient, false},
+		{"InvalidInput is Permanent", New(InvalidInput, "bad"), Permanent, true},
+		{"Security is Permanent", New(Security, "bomb"), Permanent, true},
+		{"Throttled is Transient", New(Throttled, "slow down"), Transient, true},
+		{"Permanent is not InvalidInput", New(Permanent, "gone"), InvalidInput, false},
+		{"Through fmt.Errorf", fmt.Errorf("error: %w", New(Security, "bomb")), Security, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if is := stderrors.Is(tt.err, tt.target); is != tt.expected {
+				t.Errorf("errors.Is(%v, %v) = %v, expected %v", tt.err, tt.target, is, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	cause := stderrors.New("unexpected EOF")
+	err := fmt.Errorf("error processing record: %w", Wrap(InvalidInput, cause, "error parsing report"))
+
+	if !stderrors.Is(err, cause) {
+		t.Error("errors.Is() did not find the wrapped cause")
+	}
+
+	var lambdaErr LambdaError
+	if !stderrors.As(err, &lambdaErr) {
+		t.Fatal("errors.As() did not find the LambdaError")
+	}
+	if lambdaErr.Message != "error parsing report: unexpected EOF" {
+		t.Errorf("Message = %q, expected %q", lambdaErr.Message, "error parsing report: unexpected EOF")
+	}
+	if lambdaErr.StatusCode != 400 {
+		t.Errorf("StatusCode = %d, expected 400", lambdaErr.StatusCode)
+	}
+}
@@ -1,10 +1,14 @@
 package models
 
-// DmarcReportMetadataItem represents a DMARC report item in the DynamoDB table.  This item
-// contains the metadata for a DMARC report.
+// DmarcReportMetadataItem represents a DMARC report item in the DMARC table.  This item
+// contains the metadata for a DMARC report.  Reports are partitioned by tenant and policy
+// domain, and sorted by the beginning of their date range.
 type DmarcReportMetadataItem struct {
-	ID               string `dynamodbav:"id"`
+	PK               string `dynamodbav:"pk"`
+	SK               string `dynamodbav:"sk"`
+	ReporterKey      string `dynamodbav:"reporterKey"`
 	ReportId         string `dynamodbav:"reportId"`
+	TenantID         string `dynamodbav:"tenantId"`
 	OrgName          string `dynamodbav:"orgName"`
 	Email            string `dynamodbav:"email"`
 	ExtraContactInfo string `dynamodbav:"extraContactInfo"`
@@ -17,13 +21,23 @@ type DmarcReportMetadataItem struct {
 	Sp               string `dynamodbav:"sp"`
 	Pct              int    `dynamodbav:"pct"`
 	Np               string `dynamodbav:"np"`
+
+	AttachmentFormat string        `dynamodbav:"attachmentFormat"`
+	ReportFilename   string        `dynamodbav:"reportFilename"`
+	DKIMResults      []DKIMResult  `dynamodbav:"dkimResults"`
+	ARCResult        ARCResult     `dynamodbav:"arcResult"`
+	History          []StageRecord `dynamodbav:"history"`
 }
 
-// DmarcRecordItem represents a DMARC record item in the DynamoDB table.  This item
-// contains the details of a DMARC record.  Each record is associated with a single DMARC report.
+// DmarcRecordItem represents a DMARC record item in the DMARC table.  This item contains the
+// details of a DMARC record.  Each record is associated with a single DMARC report, and is
+// stored in the same partition as it.
 type DmarcRecordItem struct {
-	ID                         string                           `dynamodbav:"id"`
+	PK                         string                           `dynamodbav:"pk"`
+	SK                         string                           `dynamodbav:"sk"`
+	SourceIPKey                string                           `dynamodbav:"sourceIpKey"`
 	ReportId                   string                           `dynamodbav:"reportId"`
+	RecordIndex                int                              `dynamodbav:"recordIndex"`
 	SourceIp                   string                           `dynamodbav:"sourceIp"`
 	Count                      int                              `dynamodbav:"count"`
 	PolicyEvaluatedDisposition string                           `dynamodbav:"policyEvaluatedDisposition"`
@@ -34,6 +48,30 @@ type DmarcRecordItem struct {
 	AuthResultsSpf             DmarcAuthResultNestedAttribute   `dynamodbav:"authResultsSpf"`
 }
 
+// DmarcRollupItem represents the daily totals of a tenant's DMARC records for a domain, from
+// either a single source IP or a single reporter.  Rollups are updated as reports are stored,
+// so dashboards can read daily totals without reading every record.
+type DmarcRollupItem struct {
+	PK       string `dynamodbav:"pk"`
+	SK       string `dynamodbav:"sk"`
+	Domain   string `dynamodbav:"domain"`
+	Day      string `dynamodbav:"day"`
+	SourceIp string `dynamodbav:"sourceIp,omitempty"`
+	OrgName  string `dynamodbav:"orgName,omitempty"`
+
+	Messages              int64 `dynamodbav:"messages"`
+	DKIMPass              int64 `dynamodbav:"dkimPass"`
+	SPFPass               int64 `dynamodbav:"spfPass"`
+	Fail                  int64 `dynamodbav:"fail"`
+	DispositionNone       int64 `dynamodbav:"dispositionNone"`
+	DispositionQuarantine int64 `dynamodbav:"dispositionQuarantine"`
+	DispositionReject     int64 `dynamodbav:"dispositionReject"`
+
+	// Report is the sort key of the report the counters are added from.  It is recorded by a
+	// separate marker item rather than on the rollup, so is not stored.
+	Report string `dynamodbav:"-"`
+}
+
 // DmarcAuthResultNestedAttribute represents a nested attribute for the DMARC record item in the DynamoDB table.
 // This attribute contains the details of the authentication results for a specific domain.
 type DmarcAuthResultNestedAttribute struct {
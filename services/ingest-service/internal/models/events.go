@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// EventSchemaVersion is the version of the schema of every event's detail.  It is increased
+// whenever a field is removed or changes meaning, so consumers can match on the version they
+// understand.  Adding a field does not change the version.
+const EventSchemaVersion = 1
+
+// EmailReceivedEvent is published when an email has been received and queued for extraction.
+type EmailReceivedEvent struct {
+	TenantID   string    `json:"tenantID"`
+	MessageID  string    `json:"messageID"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+func (EmailReceivedEvent) EventType() string { return "EmailReceived" }
+
+// ReportStoredEvent is published when a DMARC report and its records have been stored.
+type ReportStoredEvent struct {
+	TenantID       string `json:"tenantID"`
+	MessageID      string `json:"messageID"`
+	ReportID       string `json:"reportID"`
+	OrgName        string `json:"orgName"`
+	Domain         string `json:"domain"`
+	DateRangeBegin int64  `json:"dateRangeBegin"`
+	DateRangeEnd   int64  `json:"dateRangeEnd"`
+	RecordCount    int    `json:"recordCount"`
+}
+
+func (ReportStoredEvent) EventType() string { return "ReportStored" }
+
+// ReportRejectedEvent is published when an attachment is quarantined instead of being
+// extracted, such as when it exceeds the decompression limits.
+type ReportRejectedEvent struct {
+	TenantID   string `json:"tenantID"`
+	MessageID  string `json:"messageID"`
+	Attachment string `json:"attachment"`
+	Reason     string `json:"reason"`
+}
+
+func (ReportRejectedEvent) EventType() string { return "ReportRejected" }
+
+// ParseFailedEvent is published when an extracted report cannot be parsed.
+type ParseFailedEvent struct {
+	TenantID               string `json:"tenantID"`
+	MessageID              string `json:"messageID"`
+	AttachmentS3ObjectPath string `json:"attachmentS3ObjectPath"`
+	Reason                 string `json:"reason"`
+}
+
+func (ParseFailedEvent) EventType() string { return "ParseFailed" }
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/errors"
+)
+
+// IngestMessageVersion is the version of IngestMessage published by this build.  It must be
+// increased whenever a field is added, removed or changes meaning, so that a stage running an
+// older build rejects the message rather than silently dropping the fields it does not know.
+//
+// Version 1 is the unversioned message.  Version 2 added Version and History, and removed
+// ReportID and ReportTimestamp, which no stage read; version 1 messages carrying them are still
+// accepted, and the fields are ignored.
+const IngestMessageVersion = 2
+
+// MinIngestMessageVersion is the oldest version of IngestMessage this build can process.
+const MinIngestMessageVersion = 1
+
+// ErrIncompatibleMessage is returned by ParseIngestMessage for a message published by a stage
+// running a different, incompatible build.
+var ErrIncompatibleMessage = stderrors.New("incompatible message version")
+
+// ParseIngestMessage decodes a message published by the previous stage, checking its version
+// is one this build can process.  Messages with no version are treated as version 1.
+//
+// An incompatible message is an InvalidInput error, since retrying it with the same build can
+// never succeed: it is quarantined, and can be replayed once every stage runs a build that
+// supports it.  Messages of the current version are decoded strictly, so a field added without
+// increasing the version is also reported as incompatible.
+func ParseIngestMessage(body string) (IngestMessage, error) {
+	var message IngestMessage
+	if err := json.Unmarshal([]byte(body), &message); err != nil {
+		return IngestMessage{}, errors.Wrap(errors.InvalidInput, err, "error unmarshalling message")
+	}
+
+	version := message.Version
+	if version == 0 {
+		version = 1
+	}
+	if version < MinIngestMessageVersion || version > IngestMessageVersion {
+		err := fmt.Errorf("%w: message is version %d, but this stage supports versions %d to %d", ErrIncompatibleMessage, version, MinIngestMessageVersion, IngestMessageVersion)
+		return IngestMessage{}, errors.Wrap(errors.InvalidInput, err, "error unmarshalling message")
+	}
+
+	if version == IngestMessageVersion {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(body)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&IngestMessage{}); err != nil {
+			err = fmt.Errorf("%w: %w", ErrIncompatibleMessage, err)
+			return IngestMessage{}, errors.Wrap(errors.InvalidInput, err, "error unmarshalling message")
+		}
+	}
+
+	return message, nil
+}
+
+// MarshalIngestMessage encodes a message for the next stage, setting its Version to
+// IngestMessageVersion.
+func MarshalIngestMessage(message IngestMessage) (string, error) {
+	message.Version = IngestMessageVersion
+	body, err := json.Marshal(message)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling message: %w", err)
+	}
+	return string(body), nil
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	stderrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/errors"
+)
+
+func TestParseIngestMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		messageID    string
+		version      int
+		historyLen   int
+		wantErr      bool
+		incompatible bool
+		kind         errors.Kind
+	}{
+		{
+			name:      "Unversioned message",
+			body:      `{"messageID":"msg-1","tenantID":"tenant","reportID":"","reportTimestamp":""}`,
+			messageID: "msg-1",
+		},
+		{
+			name:      "Version 1 message with report fields",
+			body:      `{"version":1,"messageID":"msg-1","tenantID":"tenant","reportID":"report-1","reportTimestamp":"2024-07-17T00:00:00Z"}`,
+			messageID: "msg-1",
+			version:   1,
+		},
+		{
+			name:       "Current version",
+			body:       fmt.Sprintf(`{"version":%d,"messageID":"msg-1","history":[{"stage":"enqueue-email","attempt":1}]}`, IngestMessageVersion),
+			messageID:  "msg-1",
+			version:    IngestMessageVersion,
+			historyLen: 1,
+		},
+		{
+			name:         "Newer version",
+			body:         fmt.Sprintf(`{"version":%d,"messageID":"msg-1"}`, IngestMessageVersion+1),
+			wantErr:      true,
+			incompatible: true,
+			kind:         errors.InvalidInput,
+		},
+		{
+			name:         "Unknown field in current version",
+			body:         fmt.Sprintf(`{"version":%d,"messageID":"msg-1","reportSize":42}`, IngestMessageVersion),
+			wantErr:      true,
+			incompatible: true,
+			kind:         errors.InvalidInput,
+		},
+		{
+			name:    "Invalid JSON",
+			body:    `{"messageID":`,
+			wantErr: true,
+			kind:    errors.InvalidInput,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := ParseIngestMessage(tt.body)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ParseIngestMessage() error = nil, want error")
+				}
+				if kind := errors.KindOf(err); kind != tt.kind {
+					t.Errorf("KindOf() = %s, want %s", kind, tt.kind)
+				}
+				if got := stderrors.Is(err, ErrIncompatibleMessage); got != tt.incompatible {
+					t.Errorf("errors.Is(err, ErrIncompatibleMessage) = %v, want %v", got, tt.incompatible)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseIngestMessage() error = %v", err)
+			}
+			if message.MessageID != tt.messageID {
+				t.Errorf("MessageID = %q, want %q", message.MessageID, tt.messageID)
+			}
+			if message.Version != tt.version {
+				t.Errorf("Version = %d, want %d", message.Version, tt.version)
+			}
+			if len(message.History) != tt.historyLen {
+				t.Errorf("len(History) = %d, want %d", len(message.History), tt.historyLen)
+			}
+		})
+	}
+}
+
+func TestMarshalIngestMessage(t *testing.T) {
+	body, err := MarshalIngestMessage(IngestMessage{MessageID: "msg-1", History: []StageRecord{{Stage: "enqueue-email", Attempt: 1}}})
+	if err != nil {
+		t.Fatalf("MarshalIngestMessage() error = %v", err)
+	}
+
+	message, err := ParseIngestMessage(body)
+	if err != nil {
+		t.Fatalf("ParseIngestMessage() error = %v", err)
+	}
+	if message.Version != IngestMessageVersion {
+		t.Errorf("Version = %d, want %d", message.Version, IngestMessageVersion)
+	}
+	if len(message.History) != 1 || message.History[0].Stage != "enqueue-email" {
+		t.Errorf("History = %+v, want the enqueue-email stage", message.History)
+	}
+}
@@ -1,7 +1,15 @@
 package models
 
-// IngestMessage represents the message sent to the SQS queue by each stage of the pipeline
+import "time"
+
+// IngestMessage represents the message sent to the SQS queue by each stage of the pipeline.
+// Stages decode it with ParseIngestMessage and encode it with MarshalIngestMessage, which
+// check and set its Version.
 type IngestMessage struct {
+	// Version is the IngestMessageVersion of the stage that published the message.  Messages
+	// published before the message was versioned have no version.
+	Version int `json:"version"`
+
 	// MessageID is the unique identifier for the email message, provided by SES
 	MessageID string `json:"messageID"`
 
@@ -21,11 +29,115 @@ type IngestMessage struct {
 	// Populated by the extract-attachment function
 	AttachmentS3ObjectPath string `json:"attachmentS3ObjectPath"`
 
-	// ReportID is the unique identifier for the DMARC report, provided by the report
-	// Populated by the parse-report function
-	ReportID string `json:"reportID"`
+	// AttachmentFormat is the detected format of the attachment the report was extracted from
+	// Populated by the extract-attachment function
+	AttachmentFormat string `json:"attachmentFormat"`
+
+	// ReportFilename is the name of the report within the attachment, which differs from the
+	// attachment name when the report was extracted from an archive
+	// Populated by the extract-attachment function
+	ReportFilename string `json:"reportFilename"`
+
+	// DKIMResults are the results of verifying the DKIM signatures on the raw email message
+	// Populated by the extract-attachment function
+	DKIMResults []DKIMResult `json:"dkimResults"`
+
+	// ARCResult is the result of validating the ARC chain on a forwarded email message
+	// Populated by the extract-attachment function
+	ARCResult ARCResult `json:"arcResult"`
+
+	// History records every stage that has processed the message, in order
+	// Appended to by each function before publishing the message to the next stage
+	History []StageRecord `json:"history"`
+}
+
+// StageRecord records a pipeline stage processing a message, providing the provenance of the
+// reports stored from it.
+type StageRecord struct {
+	// Stage is the name of the pipeline stage
+	Stage string `json:"stage" dynamodbav:"stage"`
+
+	// FunctionVersion is the version of the Lambda function that ran the stage
+	FunctionVersion string `json:"functionVersion" dynamodbav:"functionVersion"`
+
+	// StartedAt is the time the stage started processing the message
+	StartedAt time.Time `json:"startedAt" dynamodbav:"startedAt"`
+
+	// EndedAt is the time the stage finished processing the message
+	EndedAt time.Time `json:"endedAt" dynamodbav:"endedAt"`
+
+	// Attempt is the number of times the stage has received the message, counting this one
+	Attempt int `json:"attempt" dynamodbav:"attempt"`
+}
+
+// End returns a copy of the record with EndedAt set to the current time.
+func (r StageRecord) End() StageRecord {
+	r.EndedAt = time.Now().UTC()
+	return r
+}
+
+// DKIMResult is the outcome of verifying a single DKIM signature on the email message that
+// delivered a report.  It is stored with each report for provenance.
+type DKIMResult struct {
+	Domain   string `json:"domain" dynamodbav:"domain"`
+	Selector string `json:"selector" dynamodbav:"selector"`
+	Result   string `json:"result" dynamodbav:"result"`
+}
+
+// ARCResult is the outcome of validating the ARC chain on the email message that delivered a
+// report.  When a tenant forwards reports from their own mailbox and the chain passes, the
+// authentication results recorded by the first intermediary can be trusted.
+type ARCResult struct {
+	Result                        string `json:"result" dynamodbav:"result"`
+	Instances                     int    `json:"instances" dynamodbav:"instances"`
+	OriginalAuthenticationResults string `json:"originalAuthenticationResults" dynamodbav:"originalAuthenticationResults"`
+}
+
+// QuarantineRecord is stored when a pipeline stage fails permanently on a message, such as
+// when an attachment is a decompression bomb.  Quarantined messages are not retried.
+type QuarantineRecord struct {
+	// Message is the message the stage was processing when it failed
+	Message IngestMessage `json:"message"`
+
+	// Stage is the name of the pipeline stage that quarantined the message
+	Stage string `json:"stage"`
+
+	// Reason describes why the message was quarantined
+	Reason string `json:"reason"`
+
+	// Kind is the kind of error that caused the failure, such as InvalidInput or Security
+	Kind string `json:"kind,omitempty"`
+
+	// Body is the raw message body, recorded when it could not be parsed into Message
+	Body string `json:"body,omitempty"`
+
+	// Attachment is the filename of the attachment that caused the failure, if any
+	Attachment string `json:"attachment,omitempty"`
+
+	// QuarantinedAt is the time the message was quarantined
+	QuarantinedAt string `json:"quarantinedAt"`
+}
+
+// FailureRecord is stored when a pipeline stage fails on a message with an error that may
+// succeed when retried.  It is replaced on every failure, so if the message reaches the
+// dead-letter queue it describes the last failure.
+type FailureRecord struct {
+	// SQSMessageID is the ID of the SQS message, which is kept when the message is moved to
+	// the dead-letter queue
+	SQSMessageID string `json:"sqsMessageId"`
+
+	// Stage is the name of the pipeline stage that failed
+	Stage string `json:"stage"`
+
+	// Reason describes why the stage failed
+	Reason string `json:"reason"`
+
+	// Kind is the kind of error that caused the failure, such as Transient or Throttled
+	Kind string `json:"kind"`
+
+	// ReceiveCount is the number of times the message had been received when it failed
+	ReceiveCount int `json:"receiveCount"`
 
-	// ReportTimestamp is the time the DMARC report was received, provided by the report
-	// Populated by the parse-report function
-	ReportTimestamp string `json:"reportTimestamp"`
+	// FailedAt is the time the stage failed
+	FailedAt string `json:"failedAt"`
 }
@@ -0,0 +1,66 @@
+package quarantine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/errors"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+// failureKey returns the key of the failure record of an SQS message.
+func failureKey(stage, sqsMessageID string) string {
+	return fmt.Sprintf("failures/%s/%s.json", stage, sqsMessageID)
+}
+
+// RecordFailure stores a failure record in the bucket under the failures/ prefix for an SQS
+// message which failed with a retryable error, so the reason can be found if the message
+// reaches the dead-letter queue.  Failing to store the record only logs an error, since the
+// message is retried either way.
+func RecordFailure(ctx context.Context, awsClient aws.ObjectStore, bucket string, stage string, message events.SQSMessage, err error) {
+	record := models.FailureRecord{
+		SQSMessageID: message.MessageId,
+		Stage:        stage,
+		Reason:       err.Error(),
+		Kind:         errors.KindOf(err).String(),
+		ReceiveCount: aws.ReceiveCount(message),
+		FailedAt:     fmt.Sprintf("%d", time.Now().Unix()),
+	}
+
+	body, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Error marshalling failure record of message %s: %v", message.MessageId, err)
+		return
+	}
+
+	opts := aws.S3ObjectOptions{
+		ContentType: "application/json",
+		Tags:        map[string]string{aws.TagStage: stage},
+	}
+	if err := awsClient.S3PutObject(ctx, bucket, failureKey(stage, message.MessageId), opts, body); err != nil {
+		log.Printf("Error saving failure record of message %s to S3: %v", message.MessageId, err)
+	}
+}
+
+// GetFailure returns the failure record of an SQS message, or nil if the message has not
+// failed with a retryable error.
+func GetFailure(ctx context.Context, awsClient aws.ObjectStore, bucket string, stage string, sqsMessageID string) (*models.FailureRecord, error) {
+	body, err := awsClient.S3GetObject(ctx, bucket, failureKey(stage, sqsMessageID))
+	if err != nil {
+		if aws.IsObjectNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting failure record of message %s: %w", sqsMessageID, err)
+	}
+
+	var record models.FailureRecord
+	if err := json.Unmarshal(body, &record); err != nil {
+		return nil, fmt.Errorf("error unmarshalling failure record of message %s: %w", sqsMessageID, err)
+	}
+	return &record, nil
+}
@@ -0,0 +1,82 @@
+package quarantine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/aws"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/errors"
+	"github.com/rsturla/dmarc-monitor/services/ingest-service/internal/models"
+)
+
+// unknownID replaces the tenant and message IDs in the key of a record whose message could not
+// be parsed
+const unknownID = "unknown"
+
+// Put stores a quarantine record in the bucket under the quarantine/ prefix.  Messages are
+// quarantined when they fail in a way that retrying can never fix, so they can be inspected
+// without being retried until they reach the dead-letter queue.
+func Put(ctx context.Context, awsClient aws.ObjectStore, bucket string, record models.QuarantineRecord) error {
+	now := time.Now()
+	if record.QuarantinedAt == "" {
+		record.QuarantinedAt = fmt.Sprintf("%d", now.Unix())
+	}
+
+	body, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error marshalling quarantine record: %w", err)
+	}
+
+	tenantID, messageID := record.Message.TenantID, record.Message.MessageID
+	if tenantID == "" {
+		tenantID = unknownID
+	}
+	if messageID == "" {
+		messageID = unknownID
+	}
+
+	key := fmt.Sprintf("quarantine/%s/%s/%s/%d.json", record.Stage, tenantID, messageID, now.UnixNano())
+	opts := aws.S3ObjectOptions{
+		ContentType: "application/json",
+		Tags: map[string]string{
+			aws.TagTenantID:  record.Message.TenantID,
+			aws.TagMessageID: record.Message.MessageID,
+			aws.TagStage:     record.Stage,
+		},
+	}
+	if err := awsClient.S3PutObject(ctx, bucket, key, opts, body); err != nil {
+		return fmt.Errorf("error saving quarantine record to S3: %w", err)
+	}
+
+	return nil
+}
+
+// Permanent quarantines an SQS message whose processing failed with an error that retrying
+// cannot fix, returning nil so the message is removed from the queue.  Retryable errors are
+// returned unchanged so the message is retried.
+func Permanent(ctx context.Context, awsClient aws.ObjectStore, bucket string, stage string, message events.SQSMessage, err error) error {
+	if err == nil || errors.IsRetryable(err) {
+		return err
+	}
+
+	log.Printf("Quarantining message %s (%s): %v", message.MessageId, errors.KindOf(err), err)
+
+	record := models.QuarantineRecord{
+		Stage:  stage,
+		Reason: err.Error(),
+		Kind:   errors.KindOf(err).String(),
+	}
+	if parseErr := aws.ParseSQSMessage(message.Body, &record.Message); parseErr != nil {
+		record.Body = message.Body
+	}
+
+	if putErr := Put(ctx, awsClient, bucket, record); putErr != nil {
+		return fmt.Errorf("error quarantining message after %w: %w", err, putErr)
+	}
+
+	return nil
+}